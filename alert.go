@@ -0,0 +1,147 @@
+// Define alert rules on the sensor values in this file
+package serial
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// AlertRule fires when the value of the sensor is above or below the threshold
+// for the duration, and it is resolved when the value is back.
+// Only the sensors with one value can be used (temperature, humidity, pressure, light and broadband).
+type AlertRule struct {
+	Name string `json:"name"`
+	// Device ID, or every device if it is empty
+	Device string `json:"device,omitempty"`
+	// SensorName of the value
+	Sensor string   `json:"sensor"`
+	Above  *float64 `json:"above,omitempty"`
+	Below  *float64 `json:"below,omitempty"`
+	// How long the value has to be out of the threshold before it fires
+	For Duration `json:"for,omitempty"`
+}
+
+// Alert is given to AlertEngine.OnAlert when a rule fires (Firing) or is resolved.
+type Alert struct {
+	Rule   string
+	Device string
+	Sensor string
+	Value  float64
+	Firing bool
+	Time   time.Time
+}
+
+// AlertEngine evaluates the rules on the data of the devices.
+type AlertEngine struct {
+	// OnAlert is called when a rule fires or is resolved. It logs them if it is nil.
+	OnAlert func(alert Alert)
+
+	rules []AlertRule
+
+	mu     sync.Mutex
+	states map[alertKey]*alertState
+}
+
+type alertKey struct {
+	rule   int
+	device string
+}
+
+type alertState struct {
+	// when the value went out of the threshold (zero if it is in)
+	since  time.Time
+	firing bool
+}
+
+func NewAlertEngine(rules []AlertRule) *AlertEngine {
+	return &AlertEngine{
+		rules:  rules,
+		states: make(map[alertKey]*alertState),
+	}
+}
+
+// Observe evaluates the rules on the data of the device.
+func (e *AlertEngine) Observe(device string, timeSeriesData *TimeSeriesData) {
+	var alerts []Alert
+
+	e.mu.Lock()
+	for i, rule := range e.rules {
+		if rule.Device != "" && rule.Device != device {
+			continue
+		}
+
+		cmd, err := SensorCmd(rule.Sensor)
+		if err != nil {
+			continue
+		}
+		data, exist := timeSeriesData.Data[cmd]
+		if !exist || !isValidData(data) {
+			continue
+		}
+		value, ok := scalarValue(data)
+		if !ok {
+			continue
+		}
+
+		at := timeSeriesData.Time
+		if timing, exist := timeSeriesData.Timing[cmd]; exist && timing != nil {
+			at = timing.Received
+		}
+
+		key := alertKey{rule: i, device: device}
+		state, exist := e.states[key]
+		if !exist {
+			state = new(alertState)
+			e.states[key] = state
+		}
+
+		out := (rule.Above != nil && value > *rule.Above) || (rule.Below != nil && value < *rule.Below)
+		if !out {
+			state.since = time.Time{}
+			if state.firing {
+				state.firing = false
+				alerts = append(alerts, Alert{Rule: rule.Name, Device: device, Sensor: rule.Sensor, Value: value, Time: at})
+			}
+			continue
+		}
+
+		if state.since.IsZero() {
+			state.since = at
+		}
+		if !state.firing && at.Sub(state.since) >= time.Duration(rule.For) {
+			state.firing = true
+			alerts = append(alerts, Alert{Rule: rule.Name, Device: device, Sensor: rule.Sensor, Value: value, Firing: true, Time: at})
+		}
+	}
+	e.mu.Unlock()
+
+	// called without the lock, so OnAlert can take time
+	for _, alert := range alerts {
+		if e.OnAlert != nil {
+			e.OnAlert(alert)
+		} else if alert.Firing {
+			log.Printf("ALERT %s: %s %s = %v", alert.Rule, alert.Device, alert.Sensor, alert.Value)
+		} else {
+			log.Printf("RESOLVED %s: %s %s = %v", alert.Rule, alert.Device, alert.Sensor, alert.Value)
+		}
+	}
+}
+
+// scalarValue returns the value of the sensors which have only one value.
+func scalarValue(data SensorData) (float64, bool) {
+	switch value := data.(type) {
+	case TemperatureData:
+		return float64(value), true
+	case HumidityData:
+		return float64(value), true
+	case PressureData:
+		return float64(value), true
+	case LightData:
+		return float64(value), true
+	case BroadbandData:
+		return float64(value), true
+	}
+
+	return 0, false
+}
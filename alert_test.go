@@ -0,0 +1,108 @@
+package serial
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAlertEngine(t *testing.T) {
+	above := 30.0
+	below := 10.0
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type observation struct {
+		device string
+		second int
+		value  float64
+	}
+	tests := []struct {
+		name         string
+		rule         AlertRule
+		observations []observation
+		// "+" is firing and "-" is resolved, with the value
+		want []Alert
+	}{
+		{
+			name: "above without duration",
+			rule: AlertRule{Name: "hot", Sensor: "temperature", Above: &above},
+			observations: []observation{
+				{"lab", 0, 25}, {"lab", 1, 31}, {"lab", 2, 32}, {"lab", 3, 29},
+			},
+			want: []Alert{
+				{Rule: "hot", Device: "lab", Sensor: "temperature", Value: 31, Firing: true, Time: base.Add(time.Second)},
+				{Rule: "hot", Device: "lab", Sensor: "temperature", Value: 29, Time: base.Add(3 * time.Second)},
+			},
+		},
+		{
+			name: "below for the duration",
+			rule: AlertRule{Name: "cold", Sensor: "temperature", Below: &below, For: Duration(2 * time.Second)},
+			observations: []observation{
+				{"lab", 0, 9}, {"lab", 1, 8}, {"lab", 2, 11}, {"lab", 3, 9}, {"lab", 4, 9}, {"lab", 5, 7},
+			},
+			want: []Alert{
+				{Rule: "cold", Device: "lab", Sensor: "temperature", Value: 7, Firing: true, Time: base.Add(5 * time.Second)},
+			},
+		},
+		{
+			name: "each device has its own state",
+			rule: AlertRule{Name: "hot", Sensor: "temperature", Above: &above},
+			observations: []observation{
+				{"lab", 0, 31}, {"roof", 1, 25}, {"roof", 2, 35}, {"lab", 3, 20},
+			},
+			want: []Alert{
+				{Rule: "hot", Device: "lab", Sensor: "temperature", Value: 31, Firing: true, Time: base},
+				{Rule: "hot", Device: "roof", Sensor: "temperature", Value: 35, Firing: true, Time: base.Add(2 * time.Second)},
+				{Rule: "hot", Device: "lab", Sensor: "temperature", Value: 20, Time: base.Add(3 * time.Second)},
+			},
+		},
+		{
+			name: "only the device of the rule",
+			rule: AlertRule{Name: "hot", Device: "roof", Sensor: "temperature", Above: &above},
+			observations: []observation{
+				{"lab", 0, 31}, {"roof", 1, 31},
+			},
+			want: []Alert{
+				{Rule: "hot", Device: "roof", Sensor: "temperature", Value: 31, Firing: true, Time: base.Add(time.Second)},
+			},
+		},
+		{
+			name: "other sensors are ignored",
+			rule: AlertRule{Name: "humid", Sensor: "humidity", Above: &above},
+			observations: []observation{
+				{"lab", 0, 31},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Alert
+			engine := NewAlertEngine([]AlertRule{tt.rule})
+			engine.OnAlert = func(alert Alert) { got = append(got, alert) }
+
+			for _, o := range tt.observations {
+				engine.Observe(o.device, &TimeSeriesData{
+					Time: base.Add(time.Duration(o.second) * time.Second),
+					Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(o.value)},
+				})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("alerts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlertEngineInvalidData(t *testing.T) {
+	below := 10.0
+	engine := NewAlertEngine([]AlertRule{{Name: "cold", Sensor: "temperature", Below: &below}})
+	fired := 0
+	engine.OnAlert = func(Alert) { fired++ }
+
+	// the data which couldn't be parsed (-1) is not a value below the threshold
+	engine.Observe("lab", &TimeSeriesData{Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(ParseErrorCodeDLPTH1C)}})
+	if fired != 0 {
+		t.Errorf("fired %d times by invalid data", fired)
+	}
+}
@@ -0,0 +1,387 @@
+// Define HTTP REST API with server-sent events streaming in this file
+package serial
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default address of "api" mode
+const DefaultAPIAddr string = ":9702"
+
+// Default range of the history when from is not given
+const DefaultHistoryRange time.Duration = time.Hour
+
+// Interval of the comments sent to keep the stream alive through proxies
+const StreamKeepAlive time.Duration = 30 * time.Second
+
+// APIServer serves the devices over HTTP:
+//
+//	GET  /devices
+//	GET  /devices/{id}/latest
+//	GET  /devices/{id}/history?sensor=&from=&to=
+//	POST /devices/{id}/range
+//	GET  /devices/{id}/stream?sensor=&decimation=
+//
+// Sensors are given by SensorName (several by comma), and times in RFC 3339.
+// The stream is server-sent events whose data is TimeSeriesData as JSON.
+type APIServer struct {
+	mu      sync.Mutex
+	devices map[string]*apiDevice
+}
+
+type apiDevice struct {
+	id    string
+	d     *DLPTH1C
+	hub   *Hub
+	store *Store
+
+	// the latest value of each sensor, merged from the hub
+	mu     sync.Mutex
+	latest *TimeSeriesData
+}
+
+// Device in the response of GET /devices
+type APIDevice struct {
+	ID      string    `json:"id"`
+	Port    string    `json:"port"`
+	Updated time.Time `json:"updated,omitempty"`
+	History bool      `json:"history"`
+}
+
+func NewAPIServer() *APIServer {
+	return &APIServer{devices: make(map[string]*apiDevice)}
+}
+
+// Register adds the device with the id used in the paths.
+// The latest data and the stream come from the hub, and the history from the store
+// where the data are appended with the id (the history is not served if it is nil).
+func (s *APIServer) Register(id string, d *DLPTH1C, hub *Hub, store *Store) {
+	device := &apiDevice{id: id, d: d, hub: hub, store: store}
+
+	// the latest value of each sensor is enough
+	sub := hub.Subscribe(SubscribeOptions{BufferSize: 1, Policy: CoalesceLatest})
+	go func() {
+		for timeSeriesData := range sub.C {
+			device.observe(timeSeriesData)
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.devices[id] = device
+}
+
+func (device *apiDevice) observe(timeSeriesData *TimeSeriesData) {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+
+	if device.latest == nil {
+		device.latest = &TimeSeriesData{Data: make(map[byte]SensorData)}
+	}
+	coalesce(device.latest, timeSeriesData, func(*TimeSeriesData) {})
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// /devices/{id}/{resource}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "devices" || len(parts) > 3 {
+		writeAPIError(w, http.StatusNotFound, APINotFoundError())
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, APIMethodNotAllowedError())
+			return
+		}
+		s.serveDevices(w)
+		return
+	}
+
+	s.mu.Lock()
+	device, exist := s.devices[parts[1]]
+	s.mu.Unlock()
+	if !exist || len(parts) == 2 {
+		writeAPIError(w, http.StatusNotFound, APINotFoundError())
+		return
+	}
+
+	method := http.MethodGet
+	if parts[2] == "range" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		writeAPIError(w, http.StatusMethodNotAllowed, APIMethodNotAllowedError())
+		return
+	}
+
+	switch parts[2] {
+	case "latest":
+		device.serveLatest(w)
+	case "history":
+		device.serveHistory(w, r)
+	case "range":
+		device.serveRange(w, r)
+	case "stream":
+		device.serveStream(w, r)
+	default:
+		writeAPIError(w, http.StatusNotFound, APINotFoundError())
+	}
+}
+
+func (s *APIServer) serveDevices(w http.ResponseWriter) {
+	s.mu.Lock()
+	devices := make([]APIDevice, 0, len(s.devices))
+	for id, device := range s.devices {
+		device.mu.Lock()
+		var updated time.Time
+		if device.latest != nil {
+			updated = device.latest.Time
+		}
+		device.mu.Unlock()
+
+		devices = append(devices, APIDevice{
+			ID:      id,
+			Port:    device.d.portName,
+			Updated: updated,
+			History: device.store != nil,
+		})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	writeJSON(w, http.StatusOK, devices)
+}
+
+// serveLatest writes a copy of the latest data, so a slow client doesn't hold the lock that observe needs.
+func (device *apiDevice) serveLatest(w http.ResponseWriter) {
+	var latest *TimeSeriesData
+	device.mu.Lock()
+	if device.latest != nil {
+		// the maps of latest are changed by observe
+		latest = &TimeSeriesData{Data: make(map[byte]SensorData, len(device.latest.Data))}
+		coalesce(latest, device.latest, func(*TimeSeriesData) {})
+	}
+	device.mu.Unlock()
+
+	if latest == nil {
+		writeAPIError(w, http.StatusNotFound, DataMissingError())
+		return
+	}
+	writeJSON(w, http.StatusOK, latest)
+}
+
+func (device *apiDevice) serveHistory(w http.ResponseWriter, r *http.Request) {
+	if device.store == nil {
+		writeAPIError(w, http.StatusNotFound, APINotFoundError())
+		return
+	}
+
+	query := r.URL.Query()
+	sensors, err := parseSensorNames(query["sensor"])
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	to := time.Now()
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	from := to.Add(-DefaultHistoryRange)
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	history, err := device.store.Query(device.id, sensors, from, to)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if history == nil {
+		history = []*TimeSeriesData{}
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// serveRange takes the range from the body {"g": 8} or from the query ?g=8.
+func (device *apiDevice) serveRange(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		G int `json:"g"`
+	}
+
+	if value := r.URL.Query().Get("g"); value != "" {
+		g, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(value), "g"))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		body.G = g
+	} else if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch body.G {
+	case 2, 4, 8, 16:
+	default:
+		writeAPIError(w, http.StatusBadRequest, InvalidCommandError())
+		return
+	}
+
+	// the sensor didn't respond
+	if err := device.d.SetRange(body.G); err != nil {
+		writeAPIError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (device *apiDevice) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, APIStreamingUnsupportedError())
+		return
+	}
+
+	query := r.URL.Query()
+	sensors, err := parseSensorNames(query["sensor"])
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	decimation := 1
+	if value := query.Get("decimation"); value != "" {
+		if decimation, err = strconv.Atoi(value); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// a slow client loses the oldest data rather than delaying the others
+	sub := device.hub.Subscribe(SubscribeOptions{Sensors: sensors, Decimation: decimation, Policy: DropOldest})
+	defer sub.Unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(StreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case timeSeriesData, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(timeSeriesData)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprintf(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// parseSensorNames takes ?sensor=temperature&sensor=humidity or ?sensor=temperature,humidity.
+func parseSensorNames(values []string) ([]byte, error) {
+	var sensors []byte
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name == "" {
+				continue
+			}
+			cmd, err := SensorCmd(name)
+			if err != nil {
+				return nil, err
+			}
+			sensors = append(sensors, cmd)
+		}
+	}
+	return sensors, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// runAPI serves the API until the context is done.
+// If dir is given, the data are stored there and the history is served from it.
+// TLS and tokens are read from the environment variables (see APISecurityFromEnv).
+func runAPI(ctx context.Context, d *DLPTH1C, in <-chan *TimeSeriesData, addr string, dir string) error {
+	sec, err := APISecurityFromEnv()
+	if err != nil {
+		return err
+	}
+
+	id := path.Base(d.portName)
+	hub := NewHub()
+
+	// the store is flushed and closed by its sink when the hub is closed
+	stored := make(chan error, 1)
+	var store *Store
+	if dir != "" {
+		var err error
+		if store, err = OpenStore(dir, StoreOptions{}); err != nil {
+			return err
+		}
+		sub := hub.Subscribe(SubscribeOptions{Policy: DropOldest})
+		go func() {
+			stored <- NewPipeline(PipelineOptions{}, store.Sink(id)).Run(context.Background(), sub.C)
+		}()
+	} else {
+		stored <- nil
+	}
+
+	api := NewAPIServer()
+	api.Register(id, d, hub, store)
+
+	server, err := sec.NewServer(addr, api)
+	if err != nil {
+		return err
+	}
+
+	// the streams end when the hub is closed
+	go func() {
+		hub.Run(ctx, in)
+		server.Shutdown(context.Background())
+	}()
+
+	if err := listenAndServe(server); err != http.ErrServerClosed {
+		return err
+	}
+	return <-stored
+}
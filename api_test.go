@@ -0,0 +1,331 @@
+package serial
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAPI serves the device "lab" with a store, and "roof" without store.
+type testAPI struct {
+	server *httptest.Server
+	port   *fakePort
+	d      *DLPTH1C
+	hub    *Hub
+	store  *Store
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+
+	store, err := OpenStore(t.TempDir(), StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &testAPI{port: newFakePort(sensorResponses), hub: NewHub(), store: store}
+	api.d = NewDLPTH1CFromPort("/dev/ttyFAKE0", api.port)
+	roof := NewHub()
+
+	server := NewAPIServer()
+	server.Register("lab", api.d, api.hub, store)
+	server.Register("roof", NewDLPTH1CFromPort("/dev/ttyFAKE1", newFakePort(sensorResponses)), roof, nil)
+	api.server = httptest.NewServer(server)
+
+	t.Cleanup(func() {
+		api.server.Close()
+		api.hub.Close()
+		roof.Close()
+		api.d.Close()
+		store.Close()
+	})
+	return api
+}
+
+// request returns the status and the body.
+func (api *testAPI) request(t *testing.T, method string, path string, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, api.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestAPIDevices(t *testing.T) {
+	api := newTestAPI(t)
+
+	status, body := api.request(t, http.MethodGet, "/devices", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d: %s", status, body)
+	}
+	var devices []APIDevice
+	if err := json.Unmarshal([]byte(body), &devices); err != nil {
+		t.Fatal(err)
+	}
+	want := []APIDevice{
+		{ID: "lab", Port: "/dev/ttyFAKE0", History: true},
+		{ID: "roof", Port: "/dev/ttyFAKE1"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("devices = %+v, want %+v", devices, want)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	api := newTestAPI(t)
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/", http.StatusNotFound},
+		{http.MethodGet, "/sensors", http.StatusNotFound},
+		{http.MethodPost, "/devices", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/devices/attic/latest", http.StatusNotFound},
+		{http.MethodGet, "/devices/lab", http.StatusNotFound},
+		{http.MethodGet, "/devices/lab/unknown", http.StatusNotFound},
+		{http.MethodGet, "/devices/lab/latest/more", http.StatusNotFound},
+		{http.MethodPost, "/devices/lab/latest", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/devices/lab/range", http.StatusMethodNotAllowed},
+		// no data yet
+		{http.MethodGet, "/devices/lab/latest", http.StatusNotFound},
+		// no store
+		{http.MethodGet, "/devices/roof/history", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			status, body := api.request(t, tt.method, tt.path, "")
+			if status != tt.want {
+				t.Errorf("status = %d, want %d", status, tt.want)
+			}
+			var apiError map[string]string
+			if err := json.Unmarshal([]byte(body), &apiError); err != nil || apiError["error"] == "" {
+				t.Errorf("body = %q, want an error", body)
+			}
+		})
+	}
+}
+
+func TestAPILatest(t *testing.T) {
+	api := newTestAPI(t)
+
+	// the latest value of each sensor is merged
+	api.hub.Publish(&TimeSeriesData{Time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(20)}})
+	api.hub.Publish(&TimeSeriesData{Time: time.Date(2026, 1, 1, 0, 0, 1, 0, time.UTC), Data: map[byte]SensorData{HumidityASCIICmd: HumidityData(40)}})
+
+	want := &TimeSeriesData{
+		Time: time.Date(2026, 1, 1, 0, 0, 1, 0, time.UTC),
+		Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(20), HumidityASCIICmd: HumidityData(40)},
+	}
+	var latest TimeSeriesData
+	waitUntil(t, "observing", func() bool {
+		status, body := api.request(t, http.MethodGet, "/devices/lab/latest", "")
+		if status != http.StatusOK {
+			return false
+		}
+		if err := json.Unmarshal([]byte(body), &latest); err != nil {
+			t.Fatal(err)
+		}
+		return latest.Time.Equal(want.Time)
+	})
+	if !reflect.DeepEqual(latest.Data, want.Data) {
+		t.Errorf("latest = %+v, want %+v", latest.Data, want.Data)
+	}
+}
+
+func TestAPIHistory(t *testing.T) {
+	api := newTestAPI(t)
+	for sec := 0; sec < 3; sec++ {
+		if err := api.store.Append("lab", storeData(sec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+		// temperatures, and whether humidity is returned
+		temperatures []SensorData
+		humidity     bool
+	}{
+		{
+			name:         "from and to",
+			query:        "from=2026-01-01T00:00:00Z&to=2026-01-01T00:00:02Z",
+			want:         http.StatusOK,
+			temperatures: []SensorData{TemperatureData(20), TemperatureData(21)},
+			humidity:     true,
+		},
+		{
+			// from is an hour before to
+			name:         "default from",
+			query:        "to=2026-01-01T00:00:01Z",
+			want:         http.StatusOK,
+			temperatures: []SensorData{TemperatureData(20)},
+			humidity:     true,
+		},
+		{
+			name:         "sensor filter",
+			query:        "sensor=temperature&from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z",
+			want:         http.StatusOK,
+			temperatures: []SensorData{TemperatureData(20), TemperatureData(21), TemperatureData(22)},
+		},
+		{
+			name:         "nothing in the range",
+			query:        "from=2025-01-01T00:00:00Z&to=2025-01-02T00:00:00Z",
+			want:         http.StatusOK,
+			temperatures: []SensorData{},
+		},
+		{name: "bad from", query: "from=yesterday", want: http.StatusBadRequest},
+		{name: "bad to", query: "to=2026-01-01", want: http.StatusBadRequest},
+		{name: "unknown sensor", query: "sensor=temperature,wind", want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := api.request(t, http.MethodGet, "/devices/lab/history?"+tt.query, "")
+			if status != tt.want {
+				t.Fatalf("status = %d, want %d: %s", status, tt.want, body)
+			}
+			if status != http.StatusOK {
+				return
+			}
+
+			var history []*TimeSeriesData
+			if err := json.Unmarshal([]byte(body), &history); err != nil {
+				t.Fatal(err)
+			}
+			temperatures := []SensorData{}
+			for _, timeSeriesData := range history {
+				temperatures = append(temperatures, timeSeriesData.Data[TemperatureASCIICmd])
+				if _, exist := timeSeriesData.Data[HumidityASCIICmd]; exist != tt.humidity {
+					t.Errorf("humidity returned: %v, want %v", exist, tt.humidity)
+				}
+			}
+			if !reflect.DeepEqual(temperatures, tt.temperatures) {
+				t.Errorf("temperatures = %v, want %v", temperatures, tt.temperatures)
+			}
+		})
+	}
+}
+
+func TestAPIRange(t *testing.T) {
+	api := newTestAPI(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		want    int
+		wantCmd byte
+	}{
+		{name: "body", path: "/devices/lab/range", body: `{"g": 8}`, want: http.StatusNoContent, wantCmd: Set8GASCIICmd},
+		{name: "query", path: "/devices/lab/range?g=2G", want: http.StatusNoContent, wantCmd: Set2GASCIICmd},
+		{name: "invalid range", path: "/devices/lab/range", body: `{"g": 3}`, want: http.StatusBadRequest},
+		{name: "invalid query", path: "/devices/lab/range?g=high", want: http.StatusBadRequest},
+		{name: "invalid body", path: "/devices/lab/range", body: `8`, want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(api.port.commands())
+			status, body := api.request(t, http.MethodPost, tt.path, tt.body)
+			if status != tt.want {
+				t.Fatalf("status = %d, want %d: %s", status, tt.want, body)
+			}
+
+			sent := api.port.commands()[before:]
+			if tt.wantCmd != 0 && !strings.Contains(sent, string(tt.wantCmd)) {
+				t.Errorf("sent %q, want %q", sent, tt.wantCmd)
+			}
+			if tt.wantCmd == 0 && sent != "" {
+				t.Errorf("sent %q", sent)
+			}
+		})
+	}
+
+	// the sensor can't be reached
+	api.d.Close()
+	if status, _ := api.request(t, http.MethodPost, "/devices/lab/range", `{"g": 16}`); status != http.StatusBadGateway {
+		t.Errorf("status of a closed device = %d, want %d", status, http.StatusBadGateway)
+	}
+}
+
+func TestAPIStream(t *testing.T) {
+	api := newTestAPI(t)
+
+	if status, _ := api.request(t, http.MethodGet, "/devices/lab/stream?decimation=many", ""); status != http.StatusBadRequest {
+		t.Errorf("status of invalid decimation = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	resp, err := http.Get(api.server.URL + "/devices/lab/stream?sensor=temperature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+
+	// published until the stream has subscribed
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for sec := 0; ; sec++ {
+			api.hub.Publish(storeData(sec))
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+
+	events := make(chan string)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, found := strings.CutPrefix(scanner.Text(), "data: "); found {
+				events <- data
+			}
+		}
+	}()
+
+	select {
+	case event := <-events:
+		var timeSeriesData TimeSeriesData
+		if err := json.Unmarshal([]byte(event), &timeSeriesData); err != nil {
+			t.Fatal(err)
+		}
+		if _, exist := timeSeriesData.Data[TemperatureASCIICmd]; !exist || len(timeSeriesData.Data) != 1 {
+			t.Errorf("event = %s, want only temperature", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+
+	// the stream ends when the hub is closed
+	api.hub.Close()
+	for range events {
+	}
+}
@@ -0,0 +1,218 @@
+// Define TLS and bearer token authentication of the HTTP API in this file
+package serial
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Scope is what a token is allowed to do.
+type Scope int
+
+const (
+	// ReadScope can get the devices, the data and the stream.
+	ReadScope Scope = iota + 1
+	// ControlScope can also change the sensor (e.g. the range), and it includes ReadScope.
+	ControlScope
+)
+
+// APISecurity is optional, and the zero value serves plain HTTP without authentication.
+type APISecurity struct {
+	// TLS certificate and key (PEM). TLS is used if both are given.
+	CertFile string
+	KeyFile  string
+	// CA (PEM) which the client certificates must be signed by (mTLS).
+	ClientCAFile string
+
+	// Bearer tokens and their scopes. Every request needs a token if it is not empty.
+	Tokens map[string]Scope
+	// The tokens are refused without TLS, since they would be sent in plain text,
+	// unless this is set (e.g. behind a reverse proxy which terminates TLS).
+	AllowInsecureTokens bool
+}
+
+// TLSConfig returns the config of the server, or nil if TLS is not used.
+// A part of the TLS settings without the others is an error, so the API is never served insecure by mistake.
+func (sec APISecurity) TLSConfig() (*tls.Config, error) {
+	if sec.CertFile == "" && sec.KeyFile == "" {
+		if sec.ClientCAFile != "" {
+			return nil, APISecurityError("the client CA requires the certificate and the key")
+		}
+		return nil, nil
+	}
+	if sec.CertFile == "" || sec.KeyFile == "" {
+		return nil, APISecurityError("both the certificate and the key are required for TLS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(sec.CertFile, sec.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if sec.ClientCAFile != "" {
+		pem, err := os.ReadFile(sec.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, InvalidCertificateError()
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// Handler checks the bearer token of each request.
+// GET (and HEAD) needs ReadScope, and the others (e.g. POST /devices/{id}/range) need ControlScope.
+func (sec APISecurity) Handler(next http.Handler) http.Handler {
+	if len(sec.Tokens) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		required := ControlScope
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			required = ReadScope
+		}
+
+		scope := sec.scopeOf(r)
+		if scope == 0 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dlpth1c"`)
+			writeAPIError(w, http.StatusUnauthorized, APIUnauthorizedError())
+			return
+		}
+		if scope < required {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dlpth1c", error="insufficient_scope"`)
+			writeAPIError(w, http.StatusForbidden, APIForbiddenError())
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scopeOf returns 0 if the request has no valid token.
+func (sec APISecurity) scopeOf(r *http.Request) Scope {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return 0
+	}
+	token := []byte(strings.TrimSpace(authorization[7:]))
+
+	// every token is compared, so the time doesn't tell which one is close
+	var scope Scope
+	for known, knownScope := range sec.Tokens {
+		if subtle.ConstantTimeCompare(token, []byte(known)) == 1 {
+			scope = knownScope
+		}
+	}
+	return scope
+}
+
+// NewServer returns the server of the handler with the token check and the TLS config.
+// It is served by ListenAndServeTLS("", "") if TLSConfig is not nil.
+func (sec APISecurity) NewServer(addr string, handler http.Handler) (*http.Server, error) {
+	config, err := sec.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil && len(sec.Tokens) > 0 && !sec.AllowInsecureTokens {
+		return nil, APISecurityError("the tokens would be sent in plain text without TLS")
+	}
+
+	return &http.Server{
+		Addr:      addr,
+		Handler:   sec.Handler(handler),
+		TLSConfig: config,
+	}, nil
+}
+
+// ListenAndServe serves the handler with the token check, over TLS if it is configured.
+func (sec APISecurity) ListenAndServe(addr string, handler http.Handler) error {
+	server, err := sec.NewServer(addr, handler)
+	if err != nil {
+		return err
+	}
+
+	return listenAndServe(server)
+}
+
+func listenAndServe(server *http.Server) error {
+	if server.TLSConfig != nil {
+		// the certificate is already in the config
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// APISecurityFromEnv reads the security of "api" mode from the environment variables:
+//
+//	DLPTH1C_API_CERT, DLPTH1C_API_KEY     TLS certificate and key
+//	DLPTH1C_API_CLIENT_CA                 CA of the client certificates (mTLS)
+//	DLPTH1C_API_READ_TOKENS               comma separated tokens with ReadScope
+//	DLPTH1C_API_CONTROL_TOKENS            comma separated tokens with ControlScope
+//	DLPTH1C_API_INSECURE                  "true" allows the tokens without TLS
+//
+// A token in both lists is an error, since its scope would be ambiguous.
+func APISecurityFromEnv() (APISecurity, error) {
+	sec := APISecurity{
+		CertFile:     os.Getenv("DLPTH1C_API_CERT"),
+		KeyFile:      os.Getenv("DLPTH1C_API_KEY"),
+		ClientCAFile: os.Getenv("DLPTH1C_API_CLIENT_CA"),
+		Tokens:       make(map[string]Scope),
+	}
+
+	if insecure := os.Getenv("DLPTH1C_API_INSECURE"); insecure != "" {
+		allow, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return APISecurity{}, APISecurityError(fmt.Sprintf("DLPTH1C_API_INSECURE %q is not true or false", insecure))
+		}
+		sec.AllowInsecureTokens = allow
+	}
+
+	read := strings.Split(os.Getenv("DLPTH1C_API_READ_TOKENS"), ",")
+	control := strings.Split(os.Getenv("DLPTH1C_API_CONTROL_TOKENS"), ",")
+	if err := sec.addTokens(read, control); err != nil {
+		return APISecurity{}, err
+	}
+
+	return sec, nil
+}
+
+// addTokens adds the read and the control tokens, and rejects a token in both.
+func (sec APISecurity) addTokens(read []string, control []string) error {
+	for _, tokens := range []struct {
+		list  []string
+		scope Scope
+	}{
+		{read, ReadScope},
+		{control, ControlScope},
+	} {
+		for _, token := range tokens.list {
+			token = strings.TrimSpace(token)
+			if token == "" {
+				continue
+			}
+			if scope, exist := sec.Tokens[token]; exist && scope != tokens.scope {
+				return APISecurityError("a token is given both as a read token and as a control token")
+			}
+			sec.Tokens[token] = tokens.scope
+		}
+	}
+	return nil
+}
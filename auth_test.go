@@ -0,0 +1,282 @@
+package serial
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPKI is a CA with a certificate of the server (127.0.0.1) and one of a client, signed by it.
+type testPKI struct {
+	caFile, certFile, keyFile string
+	pool                      *x509.CertPool
+	client                    tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "test"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+
+	pki := &testPKI{
+		caFile:   filepath.Join(dir, "ca.pem"),
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+		pool:     x509.NewCertPool(),
+	}
+	pki.pool.AddCert(ca)
+
+	certPEM, keyPEM := issue(2, x509.ExtKeyUsageServerAuth)
+	for path, b := range map[string][]byte{
+		pki.caFile:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		pki.certFile: certPEM,
+		pki.keyFile:  keyPEM,
+	} {
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clientPEM, clientKeyPEM := issue(3, x509.ExtKeyUsageClientAuth)
+	if pki.client, err = tls.X509KeyPair(clientPEM, clientKeyPEM); err != nil {
+		t.Fatal(err)
+	}
+	return pki
+}
+
+func TestAPISecurityTLSConfig(t *testing.T) {
+	pki := newTestPKI(t)
+
+	tests := []struct {
+		name    string
+		sec     APISecurity
+		wantTLS bool
+		wantErr bool
+	}{
+		{name: "plain", sec: APISecurity{}},
+		{name: "tls", sec: APISecurity{CertFile: pki.certFile, KeyFile: pki.keyFile}, wantTLS: true},
+		{name: "mtls", sec: APISecurity{CertFile: pki.certFile, KeyFile: pki.keyFile, ClientCAFile: pki.caFile}, wantTLS: true},
+		{name: "only cert", sec: APISecurity{CertFile: pki.certFile}, wantErr: true},
+		{name: "only key", sec: APISecurity{KeyFile: pki.keyFile}, wantErr: true},
+		{name: "client ca without cert", sec: APISecurity{ClientCAFile: pki.caFile}, wantErr: true},
+		{name: "tokens without tls", sec: APISecurity{Tokens: map[string]Scope{"r": ReadScope}}, wantErr: true},
+		{name: "tokens allowed without tls", sec: APISecurity{Tokens: map[string]Scope{"r": ReadScope}, AllowInsecureTokens: true}},
+		{name: "tokens with tls", sec: APISecurity{CertFile: pki.certFile, KeyFile: pki.keyFile, Tokens: map[string]Scope{"r": ReadScope}}, wantTLS: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := tt.sec.NewServer("127.0.0.1:0", http.NotFoundHandler())
+			if tt.wantErr {
+				if err == nil {
+					t.Error("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (server.TLSConfig != nil) != tt.wantTLS {
+				t.Errorf("TLS = %v, want %v", server.TLSConfig != nil, tt.wantTLS)
+			}
+		})
+	}
+}
+
+func TestAPISecurityScopes(t *testing.T) {
+	sec := APISecurity{Tokens: map[string]Scope{"reader": ReadScope, "controller": ControlScope}}
+	server := httptest.NewServer(sec.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{name: "no token", method: http.MethodGet, want: http.StatusUnauthorized},
+		{name: "unknown token", method: http.MethodGet, token: "nobody", want: http.StatusUnauthorized},
+		{name: "get with read token", method: http.MethodGet, token: "reader", want: http.StatusNoContent},
+		{name: "get with control token", method: http.MethodGet, token: "controller", want: http.StatusNoContent},
+		{name: "post range with read token", method: http.MethodPost, token: "reader", want: http.StatusForbidden},
+		{name: "post range with control token", method: http.MethodPost, token: "controller", want: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+"/devices/lab/range", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestAPISecurityMTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	sec := APISecurity{CertFile: pki.certFile, KeyFile: pki.keyFile, ClientCAFile: pki.caFile}
+	config, err := sec.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.TLS = config
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(certificates []tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pki.pool, Certificates: certificates},
+		}}
+		defer client.CloseIdleConnections()
+
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get(nil); err == nil {
+		t.Error("the handshake without a client certificate succeeded")
+	}
+	if err := get([]tls.Certificate{pki.client}); err != nil {
+		t.Errorf("with the client certificate: %v", err)
+	}
+}
+
+func TestAPISecurityFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		read     string
+		control  string
+		insecure string
+		want     map[string]Scope
+		wantErr  bool
+	}{
+		{
+			name:    "scopes",
+			read:    "r1, r2",
+			control: "c1",
+			want:    map[string]Scope{"r1": ReadScope, "r2": ReadScope, "c1": ControlScope},
+		},
+		{
+			name:    "token in both",
+			read:    "r1,same",
+			control: "same",
+			wantErr: true,
+		},
+		{
+			name:     "invalid insecure",
+			insecure: "maybe",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DLPTH1C_API_READ_TOKENS", tt.read)
+			t.Setenv("DLPTH1C_API_CONTROL_TOKENS", tt.control)
+			t.Setenv("DLPTH1C_API_INSECURE", tt.insecure)
+
+			sec, err := APISecurityFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sec.Tokens) != len(tt.want) {
+				t.Fatalf("tokens = %v, want %v", sec.Tokens, tt.want)
+			}
+			for token, scope := range tt.want {
+				if sec.Tokens[token] != scope {
+					t.Errorf("%s: scope = %d, want %d", token, sec.Tokens[token], scope)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+// Define the output buffer and its overflow policies in this file
+package serial
+
+import (
+	"context"
+	"sync"
+)
+
+// OverflowPolicy decides what the read functions do
+// when the output buffer is full because the consumer is slow.
+type OverflowPolicy int
+
+const (
+	// BlockOnOverflow waits until the consumer receives (the default).
+	// The next sensor request is delayed, so the timing of samples is skewed.
+	BlockOnOverflow OverflowPolicy = iota
+	// DropOldest discards the oldest data in the buffer to make room.
+	DropOldest
+	// DropNewest discards the data that has just been read.
+	DropNewest
+	// CoalesceLatest merges the new data into the newest one in the buffer,
+	// so the latest value of each sensor is kept.
+	CoalesceLatest
+)
+
+// SetOutputBuffer puts a buffer of the size between the read functions and the output channel.
+// When the buffer is full, the data is handled by the policy and the lost samples are counted
+// in Stats().Dropped. The size 0 with BlockOnOverflow sends directly to the channel (the default).
+func (d *DLPTH1C) SetOutputBuffer(size int, policy OverflowPolicy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the other policies need at least one place to drop or coalesce
+	if size <= 0 && policy != BlockOnOverflow {
+		size = 1
+	}
+	if size < 0 {
+		size = 0
+	}
+
+	d.outputSize = size
+	d.outputPolicy = policy
+
+	// the senders waiting for the room check the new size
+	for _, buffer := range d.outputs {
+		notify(buffer.taken)
+	}
+}
+
+// outputBuffer is created for each output channel, and its goroutine forwards the data to it.
+// It is also used by the subscriptions of Hub (./hub.go).
+// The data being sent by forward is still counted in the size, so the buffer never holds more than the size.
+type outputBuffer struct {
+	mu    sync.Mutex
+	items []*TimeSeriesData
+	// the data taken by forward, until the channel receives it
+	sending *TimeSeriesData
+	// set by push to take sending back (DropOldest or CoalesceLatest), and called by forward if it is taken back
+	recalled func(sending *TimeSeriesData)
+	closed   bool
+
+	// signaled when an item is pushed, when an item is taken (or the size changed),
+	// and when sending is recalled
+	pushed chan struct{}
+	taken  chan struct{}
+	recall chan struct{}
+	// closed when forward returns
+	stopped chan struct{}
+}
+
+// emit sends the data to the output channel through its buffer.
+// It returns ClosedError when the DLPTH1C is closed while it is waiting,
+// and nothing when the context is done.
+func (d *DLPTH1C) emit(ctx context.Context, out chan<- *TimeSeriesData, timeSeriesData *TimeSeriesData) error {
+	d.mu.Lock()
+	size := d.outputSize
+	buffer, exist := d.outputs[out]
+	if !exist && size > 0 {
+		buffer = newOutputBuffer()
+		d.outputs[out] = buffer
+		// the buffer is sent until it is empty even after Close, see flushOutput
+		go buffer.forward(out, nil)
+	}
+	d.mu.Unlock()
+
+	// no buffer, it goes out to the channel directly
+	if buffer == nil {
+		select {
+		case out <- timeSeriesData:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-d.done:
+			return ClosedError()
+		}
+	}
+
+	for {
+		d.mu.Lock()
+		size, policy := d.outputSize, d.outputPolicy
+		d.mu.Unlock()
+
+		if buffer.push(timeSeriesData, size, policy, d.stats.recordDrop) {
+			return nil
+		}
+
+		// BlockOnOverflow with full buffer
+		select {
+		case <-buffer.taken:
+		case <-ctx.Done():
+			return nil
+		case <-d.done:
+			return ClosedError()
+		}
+	}
+}
+
+// flushOutput waits until the data left in the buffer of the channel are sent, and forgets the buffer.
+// It is called after the last emit to the channel, so the channel can be closed safely by the caller.
+func (d *DLPTH1C) flushOutput(out chan<- *TimeSeriesData) {
+	d.mu.Lock()
+	buffer, exist := d.outputs[out]
+	delete(d.outputs, out)
+	d.mu.Unlock()
+
+	if exist {
+		buffer.close()
+		<-buffer.stopped
+	}
+}
+
+func newOutputBuffer() *outputBuffer {
+	return &outputBuffer{
+		pushed:  make(chan struct{}, 1),
+		taken:   make(chan struct{}, 1),
+		recall:  make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+}
+
+// push returns false only when the data has to wait for the room.
+// drop is called with the data lost by the policy.
+func (b *outputBuffer) push(timeSeriesData *TimeSeriesData, size int, policy OverflowPolicy, drop func(*TimeSeriesData)) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	held := len(b.items)
+	if b.sending != nil {
+		held++
+	}
+
+	if held >= size && held > 0 {
+		switch policy {
+		case BlockOnOverflow:
+			return false
+		case DropOldest:
+			if b.sending != nil && b.recalled == nil {
+				// the oldest is being sent, it is dropped unless the channel has received it meanwhile
+				b.takeBack(drop)
+			} else {
+				drop(b.items[0])
+				b.items = b.items[1:]
+			}
+		case DropNewest:
+			drop(timeSeriesData)
+			return true
+		case CoalesceLatest:
+			if len(b.items) > 0 {
+				coalesce(b.items[len(b.items)-1], timeSeriesData, drop)
+				return true
+			}
+			// the newest is being sent, the new data is merged into it unless the channel has received it meanwhile
+			b.takeBack(func(sending *TimeSeriesData) {
+				if len(b.items) == 0 {
+					b.items = append(b.items, sending)
+					return
+				}
+				coalesce(sending, b.items[0], drop)
+				b.items[0] = sending
+			})
+		}
+	}
+
+	b.items = append(b.items, timeSeriesData)
+	notify(b.pushed)
+	return true
+}
+
+// takeBack asks forward to give up sending, and to call the function with it. b.mu must be locked.
+func (b *outputBuffer) takeBack(recalled func(sending *TimeSeriesData)) {
+	b.recalled = recalled
+	notify(b.recall)
+}
+
+// coalesce overwrites the older data by the values of the newer one.
+// The values of the same sensors are replaced, so they are counted as dropped.
+func coalesce(older, newer *TimeSeriesData, drop func(*TimeSeriesData)) {
+	replaced := &TimeSeriesData{Data: make(map[byte]SensorData)}
+	for cmd, data := range newer.Data {
+		if _, exist := older.Data[cmd]; exist {
+			replaced.Data[cmd] = data
+		}
+		older.Data[cmd] = data
+	}
+	drop(replaced)
+
+	if newer.Timing != nil {
+		if older.Timing == nil {
+			older.Timing = make(map[byte]*SampleTiming, len(newer.Timing))
+		}
+		for cmd, timing := range newer.Timing {
+			older.Timing[cmd] = timing
+		}
+	}
+	if newer.Raw != nil {
+		if older.Raw == nil {
+			older.Raw = make(map[byte][]byte, len(newer.Raw))
+		}
+		for cmd, raw := range newer.Raw {
+			older.Raw[cmd] = raw
+		}
+	}
+	older.Time = newer.Time
+}
+
+// forward keeps sending the buffered data to the channel until done is closed,
+// or until the buffer is closed and every data in it has been sent.
+func (b *outputBuffer) forward(out chan<- *TimeSeriesData, done <-chan struct{}) {
+	defer close(b.stopped)
+
+	for {
+		b.mu.Lock()
+		if len(b.items) == 0 {
+			closed := b.closed
+			b.mu.Unlock()
+			if closed {
+				return
+			}
+
+			select {
+			case <-b.pushed:
+				continue
+			case <-done:
+				return
+			}
+		}
+		sending := b.items[0]
+		b.items = b.items[1:]
+		b.sending = sending
+		b.mu.Unlock()
+
+		select {
+		case out <- sending:
+			b.mu.Lock()
+			// too late to take it back
+			b.recalled = nil
+			select {
+			case <-b.recall:
+			default:
+			}
+		case <-b.recall:
+			b.mu.Lock()
+			if b.recalled != nil {
+				b.recalled(sending)
+				b.recalled = nil
+			}
+		case <-done:
+			return
+		}
+		b.sending = nil
+		notify(b.taken)
+		b.mu.Unlock()
+	}
+}
+
+// close lets forward return after sending the rest of the buffer.
+func (b *outputBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	notify(b.pushed)
+}
+
+func notify(signal chan struct{}) {
+	select {
+	case signal <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,194 @@
+package serial
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func temperatureSample(value float64) *TimeSeriesData {
+	return &TimeSeriesData{Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(value)}}
+}
+
+// droppedSamples records the data dropped by a policy.
+type droppedSamples struct {
+	mu   sync.Mutex
+	data []map[byte]SensorData
+}
+
+func (d *droppedSamples) drop(timeSeriesData *TimeSeriesData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.data = append(d.data, timeSeriesData.Data)
+}
+
+func (d *droppedSamples) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.data)
+}
+
+// waitUntil polls the condition for a second.
+func waitUntil(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatalf("%s is not done", what)
+}
+
+func TestOutputBufferPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		policy OverflowPolicy
+		// the first one is taken by forward, which is blocked since nobody receives yet
+		pushes []*TimeSeriesData
+		// whether the last push is accepted
+		wantPushed  bool
+		wantDropped int
+		want        []map[byte]SensorData
+	}{
+		{
+			name:       "block when the data being sent fills the size",
+			size:       1,
+			policy:     BlockOnOverflow,
+			pushes:     []*TimeSeriesData{temperatureSample(1), temperatureSample(2)},
+			wantPushed: false,
+			want:       []map[byte]SensorData{{TemperatureASCIICmd: TemperatureData(1)}},
+		},
+		{
+			name:        "drop oldest",
+			size:        2,
+			policy:      DropOldest,
+			pushes:      []*TimeSeriesData{temperatureSample(1), temperatureSample(2), temperatureSample(3)},
+			wantPushed:  true,
+			wantDropped: 1,
+			want:        []map[byte]SensorData{{TemperatureASCIICmd: TemperatureData(2)}, {TemperatureASCIICmd: TemperatureData(3)}},
+		},
+		{
+			name:        "drop oldest being sent",
+			size:        1,
+			policy:      DropOldest,
+			pushes:      []*TimeSeriesData{temperatureSample(1), temperatureSample(2)},
+			wantPushed:  true,
+			wantDropped: 1,
+			want:        []map[byte]SensorData{{TemperatureASCIICmd: TemperatureData(2)}},
+		},
+		{
+			name:        "drop newest",
+			size:        2,
+			policy:      DropNewest,
+			pushes:      []*TimeSeriesData{temperatureSample(1), temperatureSample(2), temperatureSample(3)},
+			wantPushed:  true,
+			wantDropped: 1,
+			want:        []map[byte]SensorData{{TemperatureASCIICmd: TemperatureData(1)}, {TemperatureASCIICmd: TemperatureData(2)}},
+		},
+		{
+			name:   "coalesce into the newest being sent",
+			size:   1,
+			policy: CoalesceLatest,
+			pushes: []*TimeSeriesData{
+				{Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(1), HumidityASCIICmd: HumidityData(40)}},
+				temperatureSample(2),
+			},
+			wantPushed:  true,
+			wantDropped: 1,
+			want:        []map[byte]SensorData{{TemperatureASCIICmd: TemperatureData(2), HumidityASCIICmd: HumidityData(40)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := make(chan *TimeSeriesData)
+			done := make(chan struct{})
+			defer close(done)
+
+			b := newOutputBuffer()
+			go b.forward(out, done)
+
+			dropped := &droppedSamples{}
+			pushed := false
+			for i, timeSeriesData := range tt.pushes {
+				pushed = b.push(timeSeriesData, tt.size, tt.policy, dropped.drop)
+				if i == 0 {
+					waitUntil(t, "taking the first data", func() bool {
+						b.mu.Lock()
+						defer b.mu.Unlock()
+						return b.sending != nil
+					})
+				}
+			}
+			if pushed != tt.wantPushed {
+				t.Errorf("pushed = %v, want %v", pushed, tt.wantPushed)
+			}
+			waitUntil(t, "dropping", func() bool { return dropped.count() == tt.wantDropped })
+
+			b.close()
+			got := []map[byte]SensorData{}
+			for timeSeriesData := range drain(out, b) {
+				got = append(got, timeSeriesData.Data)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("received %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// drain receives the data from the channel until forward returns.
+func drain(out chan *TimeSeriesData, b *outputBuffer) <-chan *TimeSeriesData {
+	received := make(chan *TimeSeriesData, 100)
+	go func() {
+		defer close(received)
+		for {
+			select {
+			case timeSeriesData := <-out:
+				received <- timeSeriesData
+			case <-b.stopped:
+				return
+			}
+		}
+	}()
+	return received
+}
+
+func TestOutputFlushedOnClose(t *testing.T) {
+	d := NewDLPTH1CFromPort("fake", newFakePort(sensorResponses))
+	d.SetOutputBuffer(10, DropOldest)
+
+	in := make(chan *TimeSeriesData)
+	produce(d, func(out chan<- *TimeSeriesData) error {
+		for i := 1; i <= 5; i++ {
+			if err := d.emit(context.Background(), out, temperatureSample(float64(i))); err != nil {
+				return err
+			}
+		}
+		// the consumer hasn't received anything yet
+		d.Close()
+		return ClosedError()
+	}, in)
+
+	var got []SensorData
+	for timeSeriesData := range in {
+		got = append(got, timeSeriesData.Data[TemperatureASCIICmd])
+	}
+
+	want := []SensorData{TemperatureData(1), TemperatureData(2), TemperatureData(3), TemperatureData(4), TemperatureData(5)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.outputs) != 0 {
+		t.Errorf("%d output buffers are left", len(d.outputs))
+	}
+}
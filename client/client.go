@@ -0,0 +1,181 @@
+// Package client talks to the daemon of DLPTH1C (RunWithCommand("daemon")) over its Unix domain socket.
+// It mirrors the API of serial.DLPTH1C, so applications can share one device.
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/w00cheol/serial"
+)
+
+// Client is safe for concurrent use.
+// Requests are sent one by one on its connection, and each stream has its own connection.
+type Client struct {
+	path string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	enc     *json.Encoder
+	scanner *bufio.Scanner
+	id      uint64
+}
+
+// Dial connects to the daemon listening on the path (e.g. serial.DefaultSocketPath).
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		path:    path,
+		conn:    conn,
+		enc:     json.NewEncoder(conn),
+		scanner: newScanner(conn),
+	}, nil
+}
+
+// Read requests one kind of data once (see serial.SensorASCIICmds for the commands).
+func (c *Client) Read(cmd byte) (*serial.TimeSeriesData, error) {
+	response, err := c.do(&serial.DaemonRequest{Op: serial.DaemonRead, Sensor: serial.SensorName(cmd)})
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// ReadAll requests every sensor once.
+func (c *Client) ReadAll() (*serial.TimeSeriesData, error) {
+	response, err := c.do(&serial.DaemonRequest{Op: serial.DaemonReadAll})
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// SetRange changes the range of the accelerometer (2, 4, 8 or 16 G).
+func (c *Client) SetRange(g int) error {
+	_, err := c.do(&serial.DaemonRequest{Op: serial.DaemonRange, G: g})
+	return err
+}
+
+// Transact sends the command to the sensor and returns the raw response.
+func (c *Client) Transact(cmd []byte) ([]byte, error) {
+	response, err := c.do(&serial.DaemonRequest{Op: serial.DaemonTransact, Cmd: cmd})
+	if err != nil {
+		return nil, err
+	}
+	return response.Raw, nil
+}
+
+// Stats returns the snapshot of counters of the DLPTH1C served by the daemon.
+func (c *Client) Stats() (serial.Stats, error) {
+	response, err := c.do(&serial.DaemonRequest{Op: serial.DaemonStats})
+	if err != nil || response.Stats == nil {
+		return serial.Stats{}, err
+	}
+	return *response.Stats, nil
+}
+
+// Stream sends the data sampled by the daemon to the channel until the context is done
+// or the connection is lost. sensors are the commands to be received (every sensor if empty),
+// and one of every decimation samples of each sensor is received.
+func (c *Client) Stream(ctx context.Context, sensors []byte, decimation int, out chan<- *serial.TimeSeriesData) error {
+	conn, err := net.Dial("unix", c.path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the blocked read returns when the connection is closed
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	request := &serial.DaemonRequest{Op: serial.DaemonStream, Decimation: decimation}
+	for _, cmd := range sensors {
+		request.Sensors = append(request.Sensors, serial.SensorName(cmd))
+	}
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return err
+	}
+
+	scanner := newScanner(conn)
+	for scanner.Scan() {
+		var response serial.DaemonResponse
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			return err
+		}
+		if response.Error != "" {
+			return serial.DaemonError(response.Error)
+		}
+
+		select {
+		case out <- response.Data:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return serial.ClosedError()
+}
+
+// Close closes the connection. The device is left to the daemon.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) do(request *serial.DaemonRequest) (*serial.DaemonResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.id++
+	request.ID = c.id
+	if err := c.enc.Encode(request); err != nil {
+		return nil, err
+	}
+
+	for c.scanner.Scan() {
+		var response serial.DaemonResponse
+		if err := json.Unmarshal(c.scanner.Bytes(), &response); err != nil {
+			return nil, err
+		}
+		// e.g. the error of a line the daemon could not decode has no ID
+		if response.ID != request.ID {
+			continue
+		}
+
+		if response.Error != "" {
+			return nil, serial.DaemonError(response.Error)
+		}
+		return &response, nil
+	}
+
+	if err := c.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, serial.ClosedError()
+}
+
+// newScanner can read a line as long as the raw responses of every sensor.
+func newScanner(conn net.Conn) *bufio.Scanner {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return scanner
+}
@@ -0,0 +1,301 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/w00cheol/serial"
+)
+
+// Responses of the DLP-TH1C in ASCII mode
+const (
+	temperatureResponse = "\nTemperature = 23.45\xb0C\r\n"
+	humidityResponse    = "Humidity = 40.5%\r\n"
+	pressureResponse    = "Pressure = 1013.25\r\n"
+	tiltResponse        = "X:10 Y:-3 Z:1000\r\n"
+	vibrationResponse   = "\nFund: 12Hz:0.5\r\nPeak2: 24Hz:0.4\r\nPeak3: 36Hz:0.3\r\nPeak4: 48Hz:0.2\r\nPeak5: 60Hz:0.1\r\nPeak6: 72Hz:0.05\r\n"
+	lightResponse       = "Light: 55\r\n"
+	soundResponse       = "\nFund: 100Hz:1.5\r\nPeak2: 200Hz:0.4\r\nPeak3: 300Hz:0.3\r\nPeak4: 400Hz:0.2\r\nPeak5: 500Hz:0.1\r\nPeak6: 600Hz:0.05\r\n"
+	broadbandResponse   = "Broadband: 0.75\r\n"
+	pingResponse        = "Q\r\n"
+)
+
+// fakePort answers the commands written to it like the sensor does.
+type fakePort struct {
+	mu      sync.Mutex
+	pending []byte
+	written []byte
+}
+
+var sensorResponses = map[byte]string{
+	serial.TemperatureASCIICmd: temperatureResponse,
+	serial.HumidityASCIICmd:    humidityResponse,
+	serial.PressureASCIICmd:    pressureResponse,
+	serial.TiltASCIICmd:        tiltResponse,
+	serial.VibrationXASCIICmd:  vibrationResponse,
+	serial.VibrationYASCIICmd:  vibrationResponse,
+	serial.VibrationZASCIICmd:  vibrationResponse,
+	serial.LightASCIICmd:       lightResponse,
+	serial.SoundASCIICmd:       soundResponse,
+	serial.BroadbandASCIICmd:   broadbandResponse,
+	serial.PingASCIICmd:        pingResponse,
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, cmd := range b {
+		p.written = append(p.written, cmd)
+		p.pending = append(p.pending, sensorResponses[cmd]...)
+	}
+	return len(b), nil
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.pending) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *fakePort) Close() error {
+	return nil
+}
+
+func (p *fakePort) commands() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return string(p.written)
+}
+
+// testDaemon serves a DLPTH1C on a fake port, and its hub is fed by in.
+type testDaemon struct {
+	port   *fakePort
+	d      *serial.DLPTH1C
+	daemon *serial.Daemon
+	in     chan *serial.TimeSeriesData
+	client *Client
+}
+
+func newTestDaemon(t *testing.T) *testDaemon {
+	t.Helper()
+
+	td := &testDaemon{port: &fakePort{}, in: make(chan *serial.TimeSeriesData)}
+	td.d = serial.NewDLPTH1CFromPort("fake", td.port)
+	hub := serial.NewHub()
+	go hub.Run(context.Background(), td.in)
+	td.daemon = serial.NewDaemon(td.d, hub)
+
+	path := filepath.Join(t.TempDir(), "dlpth1c.sock")
+	done := make(chan error, 1)
+	go func() { done <- td.daemon.ListenAndServe(path, "") }()
+	t.Cleanup(func() {
+		td.daemon.Close()
+		<-done
+		close(td.in)
+		td.d.Close()
+	})
+
+	var err error
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if td.client, err = Dial(path); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { td.client.Close() })
+	return td
+}
+
+func TestClientRead(t *testing.T) {
+	td := newTestDaemon(t)
+
+	data, err := td.client.Read(serial.TemperatureASCIICmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[byte]serial.SensorData{serial.TemperatureASCIICmd: serial.TemperatureData(23.45)}
+	if !reflect.DeepEqual(data.Data, want) {
+		t.Errorf("data = %v, want %v", data.Data, want)
+	}
+
+	// the error is sent back by the daemon
+	if _, err := td.client.Read(serial.PingASCIICmd); err == nil || err.Error() != serial.InvalidCommandError().Error() {
+		t.Errorf("reading ping: %v, want %v", err, serial.InvalidCommandError())
+	}
+}
+
+func TestClientReadAll(t *testing.T) {
+	td := newTestDaemon(t)
+
+	data, err := td.client.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Data) != len(serial.SensorASCIICmds) {
+		t.Errorf("%d sensors, want %d", len(data.Data), len(serial.SensorASCIICmds))
+	}
+	tests := map[byte]serial.SensorData{
+		serial.TemperatureASCIICmd: serial.TemperatureData(23.45),
+		serial.HumidityASCIICmd:    serial.HumidityData(40.5),
+		serial.TiltASCIICmd:        &serial.TiltData{XAxis: 10, YAxis: -3, ZAxis: 1000},
+	}
+	for cmd, want := range tests {
+		if got := data.Data[cmd]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", serial.SensorName(cmd), got, want)
+		}
+	}
+}
+
+func TestClientSetRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		g       int
+		wantCmd string
+		wantErr error
+	}{
+		{"2G", 2, string(serial.Set2GASCIICmd), nil},
+		{"16G", 16, string(serial.Set16GASCIICmd), nil},
+		{"invalid", 3, "", serial.InvalidCommandError()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDaemon(t)
+
+			err := td.client.SetRange(tt.g)
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("SetRange(%d): %v, want %v", tt.g, err, tt.wantErr)
+			}
+			if got := td.port.commands(); got != tt.wantCmd {
+				t.Errorf("commands = %q, want %q", got, tt.wantCmd)
+			}
+		})
+	}
+}
+
+func TestClientTransact(t *testing.T) {
+	td := newTestDaemon(t)
+
+	raw, err := td.client.Transact([]byte{serial.PingASCIICmd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != pingResponse {
+		t.Errorf("response = %q, want %q", raw, pingResponse)
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	td := newTestDaemon(t)
+
+	for i := 0; i < 2; i++ {
+		if _, err := td.client.Read(serial.HumidityASCIICmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := td.client.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stats.Requests[serial.HumidityASCIICmd]; got != 2 {
+		t.Errorf("humidity requests = %d, want 2", got)
+	}
+	if got := stats.Samples[serial.HumidityASCIICmd]; got != 2 {
+		t.Errorf("humidity samples = %d, want 2", got)
+	}
+	if latency := stats.Latency[serial.HumidityASCIICmd]; latency == nil || latency.Count != 2 {
+		t.Errorf("humidity latency = %+v, want 2 responses", latency)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	td := newTestDaemon(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan *serial.TimeSeriesData)
+	done := make(chan error, 1)
+	go func() { done <- td.client.Stream(ctx, []byte{serial.TemperatureASCIICmd}, 1, out) }()
+
+	// the stream is subscribed asynchronously, so the data are sent until they are received
+	stop := make(chan struct{})
+	fed := make(chan struct{})
+	defer func() {
+		close(stop)
+		<-fed
+	}()
+	go func() {
+		defer close(fed)
+		for i := 0; ; i++ {
+			data := &serial.TimeSeriesData{Time: time.Now(), Data: map[byte]serial.SensorData{
+				serial.TemperatureASCIICmd: serial.TemperatureData(float64(i)),
+				serial.HumidityASCIICmd:    serial.HumidityData(40),
+			}}
+			select {
+			case td.in <- data:
+			case <-stop:
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case data := <-out:
+			// the other sensors are filtered out by the daemon
+			if _, exist := data.Data[serial.TemperatureASCIICmd]; !exist || len(data.Data) != 1 {
+				t.Errorf("data = %v, want only the temperature", data.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no data streamed")
+		}
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Stream: %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestClientStreamInvalidSensor(t *testing.T) {
+	td := newTestDaemon(t)
+
+	err := td.client.Stream(context.Background(), []byte{serial.PingASCIICmd}, 1, make(chan *serial.TimeSeriesData))
+	if err == nil || err.Error() != serial.InvalidCommandError().Error() {
+		t.Errorf("Stream: %v, want %v", err, serial.InvalidCommandError())
+	}
+}
+
+func TestClientClosed(t *testing.T) {
+	td := newTestDaemon(t)
+
+	// the closed error of the DLPTH1C is restored
+	td.d.Close()
+	if _, err := td.client.Read(serial.TemperatureASCIICmd); !errors.Is(err, serial.ClosedError()) {
+		t.Errorf("reading a closed DLPTH1C: %v, want %v", err, serial.ClosedError())
+	}
+
+	// the connection is lost (the request or its response fails)
+	td.daemon.Close()
+	if _, err := td.client.Stats(); err == nil {
+		t.Error("requesting a closed daemon")
+	}
+}
@@ -0,0 +1,70 @@
+// Define clock which gives time to the DLPTH1C in this file
+package serial
+
+import "time"
+
+// Clock provides current time.
+// It can be replaced by SetClock for deterministic testing.
+type Clock interface {
+	Now() time.Time
+}
+
+// TimerClock is a Clock that also runs the timers of the Scheduler (./scheduler.go).
+// If the clock given to SetClock doesn't implement it, the system timers are used.
+type TimerClock interface {
+	Clock
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// SetClock replaces the clock of the DLPTH1C.
+// Monotonic times of SampleTiming are measured from the time of this call.
+func (d *DLPTH1C) SetClock(clock Clock) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.clock = clock
+	d.epoch = clock.Now()
+}
+
+func (d *DLPTH1C) now() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.clock.Now()
+}
+
+// timing converts the times of the transaction into SampleTiming.
+func (d *DLPTH1C) timing(result *transactionResult) *SampleTiming {
+	d.mu.Lock()
+	epoch := d.epoch
+	d.mu.Unlock()
+
+	return &SampleTiming{
+		Sent:         result.sent.Round(0),
+		Received:     result.received.Round(0),
+		SentMono:     result.sent.Sub(epoch),
+		ReceivedMono: result.received.Sub(epoch),
+	}
+}
+
+// after waits for the duration by the clock.
+func (d *DLPTH1C) after(duration time.Duration) <-chan time.Time {
+	d.mu.Lock()
+	clock := d.clock
+	d.mu.Unlock()
+
+	if timerClock, ok := clock.(TimerClock); ok {
+		return timerClock.After(duration)
+	}
+	return time.After(duration)
+}
@@ -0,0 +1,39 @@
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampleTiming(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+
+	d := NewDLPTH1CFromPort("fake", newFakePort(sensorResponses))
+	defer d.Close()
+	d.SetClock(clock)
+	clock.Advance(3 * time.Second)
+
+	result, err := d.Read(HumidityASCIICmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the clock doesn't move while the request is executed
+	want := &SampleTiming{
+		Sent:         start.Add(3 * time.Second),
+		Received:     start.Add(3 * time.Second),
+		SentMono:     3 * time.Second,
+		ReceivedMono: 3 * time.Second,
+	}
+	timing := result.Timing[HumidityASCIICmd]
+	if *timing != *want {
+		t.Errorf("timing = %+v, want %+v", timing, want)
+	}
+	if !result.Time.Equal(want.Sent) {
+		t.Errorf("time = %v, want %v", result.Time, want.Sent)
+	}
+	if timing.Latency() != 0 {
+		t.Errorf("latency = %v", timing.Latency())
+	}
+}
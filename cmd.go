@@ -52,3 +52,57 @@ const (
 	Set8GASCIICmd       byte = 0x2C // ','
 	Set16GASCIICmd      byte = 0x2E // '.'
 )
+
+// Every ASCII command that requests sensor data, in the order of readAllAsync
+var SensorASCIICmds = []byte{
+	TemperatureASCIICmd,
+	HumidityASCIICmd,
+	PressureASCIICmd,
+	TiltASCIICmd,
+	VibrationXASCIICmd,
+	VibrationYASCIICmd,
+	VibrationZASCIICmd,
+	LightASCIICmd,
+	SoundASCIICmd,
+	BroadbandASCIICmd,
+}
+
+// SensorName returns the name of data which is requested by the command.
+// It returns empty string if the command doesn't request any sensor data.
+func SensorName(cmd byte) string {
+	switch cmd {
+	case TemperatureASCIICmd:
+		return "temperature"
+	case HumidityASCIICmd:
+		return "humidity"
+	case PressureASCIICmd:
+		return "pressure"
+	case TiltASCIICmd:
+		return "tilt"
+	case VibrationXASCIICmd:
+		return "vibration_x"
+	case VibrationYASCIICmd:
+		return "vibration_y"
+	case VibrationZASCIICmd:
+		return "vibration_z"
+	case LightASCIICmd:
+		return "light"
+	case SoundASCIICmd:
+		return "sound"
+	case BroadbandASCIICmd:
+		return "broadband"
+	}
+
+	return ""
+}
+
+// SensorCmd returns the command which requests the data named by SensorName.
+func SensorCmd(name string) (byte, error) {
+	for _, cmd := range SensorASCIICmds {
+		if SensorName(cmd) == name {
+			return cmd, nil
+		}
+	}
+
+	return 0, InvalidCommandError()
+}
@@ -0,0 +1,221 @@
+// Define compact binary encoding of TimeSeriesData in this file
+package serial
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+)
+
+// Flags of each encoded data
+const (
+	codecHasTiming byte = 0x01
+)
+
+// appendTimeSeriesData encodes the data (Raw is left out).
+// Time is in nanoseconds, and the timing of each data is the difference from Time.
+//
+//	varint time | uvarint count | { cmd | flags | value | [varint sent | varint received] } * count
+func appendTimeSeriesData(b []byte, timeSeriesData *TimeSeriesData) []byte {
+	base := timeSeriesData.Time.UnixNano()
+	b = binary.AppendVarint(b, base)
+
+	// always in the same order, so the same data has the same bytes
+	cmds := make([]byte, 0, len(timeSeriesData.Data))
+	for cmd, data := range timeSeriesData.Data {
+		if isValidData(data) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i] < cmds[j] })
+
+	b = binary.AppendUvarint(b, uint64(len(cmds)))
+	for _, cmd := range cmds {
+		timing := timeSeriesData.Timing[cmd]
+
+		var flags byte
+		if timing != nil {
+			flags |= codecHasTiming
+		}
+		b = append(b, cmd, flags)
+		b = appendSensorData(b, timeSeriesData.Data[cmd])
+
+		if timing != nil {
+			b = binary.AppendVarint(b, timing.Sent.UnixNano()-base)
+			b = binary.AppendVarint(b, timing.Received.UnixNano()-base)
+		}
+	}
+
+	return b
+}
+
+func appendSensorData(b []byte, data SensorData) []byte {
+	switch value := data.(type) {
+	case TemperatureData:
+		return appendFloat64(b, float64(value))
+	case HumidityData:
+		return appendFloat64(b, float64(value))
+	case PressureData:
+		return appendFloat64(b, float64(value))
+	case BroadbandData:
+		return appendFloat64(b, float64(value))
+	case LightData:
+		return append(b, byte(value))
+	case *TiltData:
+		b = binary.AppendVarint(b, value.XAxis)
+		b = binary.AppendVarint(b, value.YAxis)
+		return binary.AppendVarint(b, value.ZAxis)
+	case *VibrationData:
+		return appendSpectrum(b, value.Peak, value.Amp)
+	case *SoundData:
+		return appendSpectrum(b, value.Peak, value.Amp)
+	}
+	return b
+}
+
+func appendFloat64(b []byte, value float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(value))
+}
+
+func appendSpectrum(b []byte, peak [6]int64, amp [6]float64) []byte {
+	for i := range peak {
+		b = binary.AppendVarint(b, peak[i])
+		b = appendFloat64(b, amp[i])
+	}
+	return b
+}
+
+// decoder reads the encoded data, and keeps the first error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (dec *decoder) varint() int64 {
+	if dec.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(dec.b)
+	if n <= 0 {
+		dec.err = CorruptedRecordError()
+		return 0
+	}
+	dec.b = dec.b[n:]
+	return v
+}
+
+func (dec *decoder) uvarint() uint64 {
+	if dec.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(dec.b)
+	if n <= 0 {
+		dec.err = CorruptedRecordError()
+		return 0
+	}
+	dec.b = dec.b[n:]
+	return v
+}
+
+func (dec *decoder) byte() byte {
+	if dec.err != nil {
+		return 0
+	}
+	if len(dec.b) < 1 {
+		dec.err = CorruptedRecordError()
+		return 0
+	}
+	v := dec.b[0]
+	dec.b = dec.b[1:]
+	return v
+}
+
+func (dec *decoder) float64() float64 {
+	if dec.err != nil {
+		return 0
+	}
+	if len(dec.b) < 8 {
+		dec.err = CorruptedRecordError()
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(dec.b))
+	dec.b = dec.b[8:]
+	return v
+}
+
+func (dec *decoder) spectrum() (peak [6]int64, amp [6]float64) {
+	for i := range peak {
+		peak[i] = dec.varint()
+		amp[i] = dec.float64()
+	}
+	return peak, amp
+}
+
+// decodeTimeSeriesData decodes the data encoded by appendTimeSeriesData.
+func decodeTimeSeriesData(b []byte) (*TimeSeriesData, error) {
+	dec := &decoder{b: b}
+
+	base := dec.varint()
+	count := dec.uvarint()
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	if count > uint64(len(SensorASCIICmds)) {
+		return nil, CorruptedRecordError()
+	}
+
+	timeSeriesData := &TimeSeriesData{
+		Time: time.Unix(0, base),
+		Data: make(map[byte]SensorData, count),
+	}
+
+	for i := uint64(0); i < count; i++ {
+		cmd := dec.byte()
+		flags := dec.byte()
+
+		var data SensorData
+		switch cmd {
+		case TemperatureASCIICmd:
+			data = TemperatureData(dec.float64())
+		case HumidityASCIICmd:
+			data = HumidityData(dec.float64())
+		case PressureASCIICmd:
+			data = PressureData(dec.float64())
+		case BroadbandASCIICmd:
+			data = BroadbandData(dec.float64())
+		case LightASCIICmd:
+			data = LightData(int8(dec.byte()))
+		case TiltASCIICmd:
+			data = &TiltData{XAxis: dec.varint(), YAxis: dec.varint(), ZAxis: dec.varint()}
+		case VibrationXASCIICmd, VibrationYASCIICmd, VibrationZASCIICmd:
+			peak, amp := dec.spectrum()
+			data = &VibrationData{Axis: cmd, Peak: peak, Amp: amp}
+		case SoundASCIICmd:
+			peak, amp := dec.spectrum()
+			data = &SoundData{Peak: peak, Amp: amp}
+		default:
+			return nil, CorruptedRecordError()
+		}
+		timeSeriesData.Data[cmd] = data
+
+		if flags&codecHasTiming != 0 {
+			sent := dec.varint()
+			received := dec.varint()
+			if timeSeriesData.Timing == nil {
+				timeSeriesData.Timing = make(map[byte]*SampleTiming, count)
+			}
+			// monotonic times are not kept
+			timeSeriesData.Timing[cmd] = &SampleTiming{
+				Sent:     time.Unix(0, base+sent),
+				Received: time.Unix(0, base+received),
+			}
+		}
+
+		if dec.err != nil {
+			return nil, dec.err
+		}
+	}
+
+	return timeSeriesData, nil
+}
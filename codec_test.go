@@ -0,0 +1,109 @@
+package serial
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 123456789, time.UTC)
+	spectrum := [6]float64{0.5, 0.25, 0.125, 0, 0, 0}
+
+	tests := []struct {
+		name string
+		data *TimeSeriesData
+		want *TimeSeriesData
+	}{
+		{
+			name: "every sensor",
+			data: &TimeSeriesData{
+				Time: base,
+				Data: map[byte]SensorData{
+					TemperatureASCIICmd: TemperatureData(23.45),
+					HumidityASCIICmd:    HumidityData(40.5),
+					PressureASCIICmd:    PressureData(1013.25),
+					TiltASCIICmd:        &TiltData{XAxis: 10, YAxis: -3, ZAxis: 1000},
+					VibrationXASCIICmd:  &VibrationData{Axis: VibrationXASCIICmd, Peak: [6]int64{10, 20, 30, 40, 50, 60}, Amp: spectrum},
+					VibrationZASCIICmd:  &VibrationData{Axis: VibrationZASCIICmd, Peak: [6]int64{1, 2, 3, 4, 5, 6}, Amp: spectrum},
+					LightASCIICmd:       LightData(-5),
+					SoundASCIICmd:       &SoundData{Peak: [6]int64{100, 200, 300, 400, 500, 600}, Amp: spectrum},
+					BroadbandASCIICmd:   BroadbandData(-42.5),
+				},
+			},
+		},
+		{
+			// monotonic times are not kept
+			name: "timing",
+			data: &TimeSeriesData{
+				Time: base,
+				Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(23.45)},
+				Timing: map[byte]*SampleTiming{
+					TemperatureASCIICmd: {Sent: base.Add(time.Millisecond), Received: base.Add(15 * time.Millisecond), SentMono: time.Second},
+				},
+			},
+			want: &TimeSeriesData{
+				Time: base,
+				Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(23.45)},
+				Timing: map[byte]*SampleTiming{
+					TemperatureASCIICmd: {Sent: base.Add(time.Millisecond), Received: base.Add(15 * time.Millisecond)},
+				},
+			},
+		},
+		{
+			// the data not parsed and Raw are left out
+			name: "invalid data and raw",
+			data: &TimeSeriesData{
+				Time: base,
+				Data: map[byte]SensorData{
+					TemperatureASCIICmd: TemperatureData(23.45),
+					HumidityASCIICmd:    HumidityData(ParseErrorCodeDLPTH1C),
+				},
+				Raw: map[byte][]byte{TemperatureASCIICmd: []byte("23.45")},
+			},
+			want: &TimeSeriesData{
+				Time: base,
+				Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(23.45)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == nil {
+				want = tt.data
+			}
+
+			encoded := appendTimeSeriesData(nil, tt.data)
+			got, err := decodeTimeSeriesData(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Time.Equal(want.Time) {
+				t.Errorf("time = %v, want %v", got.Time, want.Time)
+			}
+			if !reflect.DeepEqual(got.Data, want.Data) {
+				t.Errorf("data = %+v, want %+v", got.Data, want.Data)
+			}
+			if len(got.Timing) != len(want.Timing) {
+				t.Fatalf("timing = %+v, want %+v", got.Timing, want.Timing)
+			}
+			for cmd, timing := range want.Timing {
+				if !got.Timing[cmd].Sent.Equal(timing.Sent) || !got.Timing[cmd].Received.Equal(timing.Received) || got.Timing[cmd].SentMono != timing.SentMono {
+					t.Errorf("%s: timing = %+v, want %+v", SensorName(cmd), got.Timing[cmd], timing)
+				}
+			}
+			if got.Raw != nil {
+				t.Errorf("raw = %q", got.Raw)
+			}
+
+			// every truncation is reported, not decoded into wrong data
+			for n := 0; n < len(encoded); n++ {
+				if _, err := decodeTimeSeriesData(encoded[:n]); err == nil {
+					t.Errorf("%d of %d bytes are decoded", n, len(encoded))
+				}
+			}
+		})
+	}
+}
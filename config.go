@@ -0,0 +1,480 @@
+// Define configuration file of deployments in this file
+package serial
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directory of the links to the serial ports by the serial number of the USB device
+var serialByIDDir string = "/dev/serial/by-id"
+
+// Config describes the devices, the outputs and the alert rules (see RunConfig).
+// The file is JSON, and ${VAR} (or ${VAR:-default}) in its strings is replaced by the environment variable,
+// so each gateway can override the values. Any other $ is kept as it is.
+// A number or a bool can be overridden by a string, e.g. "range": "${RANGE:-8}".
+type Config struct {
+	Devices []DeviceConfig `json:"devices"`
+	Outputs []OutputConfig `json:"outputs,omitempty"`
+	Alerts  []AlertRule    `json:"alerts,omitempty"`
+}
+
+// Sampling modes of DeviceConfig
+const (
+	// Every sensor one after another, as fast as the sensor responds
+	SampleAll string = "all"
+	// Each sensor at its interval in Sampling (see ./scheduler.go)
+	SampleSchedule string = "schedule"
+)
+
+type DeviceConfig struct {
+	// ID is used as the device of the outputs (e.g. the topic, the tag and the path)
+	ID string `json:"id"`
+	// Port (e.g. "/dev/ttyACM0"), or Serial which is a part of the name in /dev/serial/by-id
+	Port   string `json:"port,omitempty"`
+	Serial string `json:"serial,omitempty"`
+	// Range of the accelerometer (2, 4, 8 or 16), left as it is if 0
+	Range int `json:"range,omitempty"`
+	// Mode is SampleAll (default) or SampleSchedule
+	Mode string `json:"mode,omitempty"`
+	// Intervals by SensorName for SampleSchedule, e.g. {"temperature": "60s", "vibration_x": "1s"}
+	Sampling map[string]Duration `json:"sampling,omitempty"`
+	// Labels are added to the InfluxDB tags
+	Labels map[string]string `json:"labels,omitempty"`
+	// Output buffer (see ./backpressure.go), Overflow is "block" (default), "drop_oldest", "drop_newest" or "coalesce"
+	Buffer   int    `json:"buffer,omitempty"`
+	Overflow string `json:"overflow,omitempty"`
+}
+
+// Types of OutputConfig
+const (
+	OutputMQTT    string = "mqtt"
+	OutputInflux  string = "influx"
+	OutputStore   string = "store"
+	OutputMetrics string = "metrics"
+	OutputAPI     string = "api"
+	OutputDaemon  string = "daemon"
+)
+
+// OutputConfig has the fields of every type, and only the fields of Type are used.
+type OutputConfig struct {
+	Type string `json:"type"`
+	// IDs of the devices, or every device if it is empty
+	Devices []string `json:"devices,omitempty"`
+
+	// mqtt
+	Broker        string `json:"broker,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	QoS           byte   `json:"qos,omitempty"`
+	Retain        bool   `json:"retain,omitempty"`
+	HomeAssistant bool   `json:"home_assistant,omitempty"`
+
+	// influx
+	URL      string `json:"url,omitempty"`
+	Org      string `json:"org,omitempty"`
+	Bucket   string `json:"bucket,omitempty"`
+	Token    string `json:"token,omitempty"`
+	Database string `json:"database,omitempty"`
+
+	// mqtt and influx: the data go through a DiskQueue in QueueDir (a directory per device),
+	// so they survive a restart while the uplink is down
+	QueueDir     string `json:"queue_dir,omitempty"`
+	QueueMaxSize int64  `json:"queue_max_size,omitempty"`
+
+	// store (also the history of api)
+	Dir     string   `json:"dir,omitempty"`
+	MaxAge  Duration `json:"max_age,omitempty"`
+	MaxSize int64    `json:"max_size,omitempty"`
+
+	// metrics and api
+	Addr string `json:"addr,omitempty"`
+
+	// api (see APISecurity)
+	Cert          string   `json:"cert,omitempty"`
+	Key           string   `json:"key,omitempty"`
+	ClientCA      string   `json:"client_ca,omitempty"`
+	ReadTokens    []string `json:"read_tokens,omitempty"`
+	ControlTokens []string `json:"control_tokens,omitempty"`
+	// the tokens are refused without TLS unless this is set
+	InsecureTokens bool `json:"insecure_tokens,omitempty"`
+
+	// daemon, only for one device
+	Socket string `json:"socket,omitempty"`
+	// the group which may connect to the socket besides the user
+	SocketGroup string `json:"socket_group,omitempty"`
+}
+
+// Duration is written as a string in the config, e.g. "60s" or "720h".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// LoadConfig reads the config file, replaces the environment variables and validates it.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the variables are replaced in the decoded strings, so their values can't change the structure of the JSON
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, ConfigError(path, err.Error())
+	}
+	if document, err = expandValue(document, reflect.TypeOf(Config{})); err != nil {
+		return nil, ConfigError(path, err.Error())
+	}
+	if b, err = json.Marshal(document); err != nil {
+		return nil, ConfigError(path, err.Error())
+	}
+
+	// unknown fields are errors, so a typo doesn't silently fall back to the default
+	decoder = json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+
+	config := new(Config)
+	if err := decoder.Decode(config); err != nil {
+		return nil, ConfigError(path, err.Error())
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// expandValue replaces ${VAR} and ${VAR:-default} in every string of the decoded JSON.
+// The type is the one the value is decoded into: a string written for a number or a bool is converted,
+// and the fields unknown to the type are left to the decoder, which reports them.
+func expandValue(value interface{}, t reflect.Type) (interface{}, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch value := value.(type) {
+	case string:
+		if !strings.Contains(value, "${") {
+			return value, nil
+		}
+		expanded, err := expandEnv(value)
+		if err != nil {
+			return nil, err
+		}
+
+		// e.g. Duration is decoded from the string
+		if reflect.PointerTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+			return expanded, nil
+		}
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			var number float64
+			if err := json.Unmarshal([]byte(expanded), &number); err != nil {
+				return nil, fmt.Errorf("%q is not a number", expanded)
+			}
+			return json.Number(expanded), nil
+		case reflect.Bool:
+			b, err := strconv.ParseBool(expanded)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not true or false", expanded)
+			}
+			return b, nil
+		}
+		return expanded, nil
+
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return value, nil
+		}
+		for i := range value {
+			expanded, err := expandValue(value[i], t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			value[i] = expanded
+		}
+
+	case map[string]interface{}:
+		for key := range value {
+			var elem reflect.Type
+			switch t.Kind() {
+			case reflect.Map:
+				elem = t.Elem()
+			case reflect.Struct:
+				field, exist := jsonField(t, key)
+				if !exist {
+					continue
+				}
+				elem = field.Type
+			default:
+				return value, nil
+			}
+
+			expanded, err := expandValue(value[key], elem)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = expanded
+		}
+	}
+	return value, nil
+}
+
+// jsonField finds the field of the struct by the name in JSON, which is not case sensitive like encoding/json.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == "" {
+			tag = field.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// expandEnv keeps a $ which doesn't start ${, e.g. in a password.
+func expandEnv(s string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:start])
+		s = s[start+2:]
+
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", fmt.Errorf("%q is not closed by }", "${"+s)
+		}
+		name, fallback, hasFallback := strings.Cut(s[:end], ":-")
+		if value, exist := os.LookupEnv(name); exist && (value != "" || !hasFallback) {
+			b.WriteString(value)
+		} else {
+			b.WriteString(fallback)
+		}
+		s = s[end+1:]
+	}
+}
+
+// Validate returns every problem of the config together.
+func (config *Config) Validate() error {
+	var errs []error
+	invalid := func(field string, format string, a ...interface{}) {
+		errs = append(errs, ConfigError(field, fmt.Sprintf(format, a...)))
+	}
+
+	if len(config.Devices) == 0 {
+		invalid("devices", "no device")
+	}
+
+	ids := make(map[string]bool)
+	for i, device := range config.Devices {
+		field := fmt.Sprintf("devices[%d]", i)
+
+		if device.ID == "" || strings.ContainsAny(device.ID, "/ ") {
+			invalid(field+".id", "%q must be a name without '/' and space", device.ID)
+		} else if ids[device.ID] {
+			invalid(field+".id", "%q is duplicated", device.ID)
+		}
+		ids[device.ID] = true
+
+		if (device.Port == "") == (device.Serial == "") {
+			invalid(field, "either port or serial is required")
+		}
+
+		switch device.Range {
+		case 0, 2, 4, 8, 16:
+		default:
+			invalid(field+".range", "%d is not 2, 4, 8 or 16", device.Range)
+		}
+
+		switch device.Mode {
+		case "", SampleAll:
+			if len(device.Sampling) > 0 {
+				invalid(field+".sampling", "is used only by mode %q", SampleSchedule)
+			}
+		case SampleSchedule:
+			if len(device.Sampling) == 0 {
+				invalid(field+".sampling", "no interval for mode %q", SampleSchedule)
+			}
+		default:
+			invalid(field+".mode", "%q is not %q or %q", device.Mode, SampleAll, SampleSchedule)
+		}
+		for name, interval := range device.Sampling {
+			if _, err := SensorCmd(name); err != nil {
+				invalid(field+".sampling", "unknown sensor %q", name)
+			}
+			if interval <= 0 {
+				invalid(field+".sampling."+name, "interval must be positive")
+			}
+		}
+
+		if device.Buffer < 0 {
+			invalid(field+".buffer", "must not be negative")
+		}
+		if _, err := overflowPolicy(device.Overflow); err != nil {
+			invalid(field+".overflow", "%q is not block, drop_oldest, drop_newest or coalesce", device.Overflow)
+		}
+	}
+
+	queueDirs := make(map[string]bool)
+	for i, output := range config.Outputs {
+		field := fmt.Sprintf("outputs[%d]", i)
+
+		if output.QueueDir != "" {
+			if output.Type != OutputMQTT && output.Type != OutputInflux {
+				invalid(field+".queue_dir", "is only for mqtt and influx")
+			}
+			if queueDirs[filepath.Clean(output.QueueDir)] {
+				invalid(field+".queue_dir", "%q is used by another output", output.QueueDir)
+			}
+			queueDirs[filepath.Clean(output.QueueDir)] = true
+		}
+
+		for _, id := range output.Devices {
+			if !ids[id] {
+				invalid(field+".devices", "unknown device %q", id)
+			}
+		}
+
+		switch output.Type {
+		case OutputMQTT:
+			if output.Broker == "" {
+				invalid(field+".broker", "is required")
+			}
+			if output.QoS > 1 {
+				invalid(field+".qos", "%d is not 0 or 1", output.QoS)
+			}
+		case OutputInflux:
+			if output.URL == "" {
+				invalid(field+".url", "is required")
+			}
+			if output.Bucket == "" && output.Database == "" {
+				invalid(field, "either bucket or database is required")
+			}
+		case OutputStore:
+			if output.Dir == "" {
+				invalid(field+".dir", "is required")
+			}
+		case OutputMetrics:
+		case OutputAPI:
+			if (output.Cert == "") != (output.Key == "") {
+				invalid(field, "both cert and key are required for TLS")
+			}
+			if output.ClientCA != "" && output.Cert == "" {
+				invalid(field+".client_ca", "requires cert and key")
+			}
+			if len(output.ReadTokens)+len(output.ControlTokens) > 0 && output.Cert == "" && !output.InsecureTokens {
+				invalid(field, "tokens require cert and key (or insecure_tokens)")
+			}
+			for _, token := range output.ReadTokens {
+				for _, control := range output.ControlTokens {
+					if token == control {
+						invalid(field, "a token is both in read_tokens and control_tokens")
+					}
+				}
+			}
+		case OutputDaemon:
+			if len(output.Devices) != 1 && len(config.Devices) != 1 {
+				invalid(field+".devices", "a daemon serves only one device")
+			}
+		default:
+			invalid(field+".type", "%q is not mqtt, influx, store, metrics, api or daemon", output.Type)
+		}
+	}
+
+	for i, rule := range config.Alerts {
+		field := fmt.Sprintf("alerts[%d]", i)
+
+		if rule.Name == "" {
+			invalid(field+".name", "is required")
+		}
+		if rule.Device != "" && !ids[rule.Device] {
+			invalid(field+".device", "unknown device %q", rule.Device)
+		}
+		switch rule.Sensor {
+		case "temperature", "humidity", "pressure", "light", "broadband":
+		default:
+			invalid(field+".sensor", "%q is not temperature, humidity, pressure, light or broadband", rule.Sensor)
+		}
+		if rule.Above == nil && rule.Below == nil {
+			invalid(field, "either above or below is required")
+		}
+		if rule.For < 0 {
+			invalid(field+".for", "must not be negative")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func overflowPolicy(name string) (OverflowPolicy, error) {
+	switch name {
+	case "", "block":
+		return BlockOnOverflow, nil
+	case "drop_oldest":
+		return DropOldest, nil
+	case "drop_newest":
+		return DropNewest, nil
+	case "coalesce":
+		return CoalesceLatest, nil
+	}
+
+	return 0, InvalidCommandError()
+}
+
+// PortName returns Port, or finds the port by Serial in /dev/serial/by-id.
+func (device DeviceConfig) PortName() (string, error) {
+	if device.Port != "" {
+		return device.Port, nil
+	}
+
+	links, err := filepath.Glob(filepath.Join(serialByIDDir, "*"))
+	if err != nil {
+		return "", err
+	}
+
+	var found []string
+	for _, link := range links {
+		if strings.Contains(filepath.Base(link), device.Serial) {
+			found = append(found, link)
+		}
+	}
+	if len(found) != 1 {
+		return "", DeviceNotFoundError(device.Serial, len(found))
+	}
+
+	return filepath.EvalSymlinks(found[0])
+}
@@ -0,0 +1,299 @@
+package serial
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigEnv(t *testing.T) {
+	t.Setenv("DLPTH1C_TEST_PORT", "/dev/ttyACM9")
+	// a value which would break the JSON if it were replaced in the text
+	t.Setenv("DLPTH1C_TEST_TOKEN", `a"b\c", "unknown": "x`)
+	t.Setenv("DLPTH1C_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		config  string
+		port    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name:   "variables",
+			config: `{"devices": [{"id": "lab", "port": "${DLPTH1C_TEST_PORT}"}], "outputs": [{"type": "influx", "url": "http://localhost:8086", "org": "o", "bucket": "b", "token": "${DLPTH1C_TEST_TOKEN}"}]}`,
+			port:   "/dev/ttyACM9",
+			token:  `a"b\c", "unknown": "x`,
+		},
+		{
+			name:   "defaults",
+			config: `{"devices": [{"id": "lab", "port": "${DLPTH1C_TEST_UNSET:-/dev/ttyACM1}"}], "outputs": [{"type": "influx", "url": "http://localhost:8086", "org": "o", "bucket": "b", "token": "${DLPTH1C_TEST_EMPTY:-t}"}]}`,
+			port:   "/dev/ttyACM1",
+			token:  "t",
+		},
+		{
+			name:   "literal dollars",
+			config: `{"devices": [{"id": "lab", "port": "/dev/ttyACM0"}], "outputs": [{"type": "influx", "url": "http://localhost:8086", "org": "o", "bucket": "b", "token": "pa$$word$HOME$"}]}`,
+			port:   "/dev/ttyACM0",
+			token:  "pa$$word$HOME$",
+		},
+		{
+			name:    "not closed",
+			config:  `{"devices": [{"id": "lab", "port": "${DLPTH1C_TEST_PORT"}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dlpth1c.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := LoadConfig(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if port := config.Devices[0].Port; port != tt.port {
+				t.Errorf("port = %q, want %q", port, tt.port)
+			}
+			if token := config.Outputs[0].Token; token != tt.token {
+				t.Errorf("token = %q, want %q", token, tt.token)
+			}
+		})
+	}
+}
+
+func TestLoadConfigNumberFromEnv(t *testing.T) {
+	t.Setenv("DLPTH1C_TEST_RANGE", "16")
+	t.Setenv("DLPTH1C_TEST_RETAIN", "true")
+	t.Setenv("DLPTH1C_TEST_NOT_NUMBER", "eight")
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{
+			name: "numbers, bools and durations",
+			config: `{"devices": [{"id": "lab", "port": "/dev/ttyACM0", "range": "${DLPTH1C_TEST_RANGE}", "buffer": "${DLPTH1C_TEST_UNSET:-100}",
+				"mode": "schedule", "sampling": {"temperature": "${DLPTH1C_TEST_UNSET:-60s}"}}],
+				"outputs": [{"type": "mqtt", "broker": "localhost:1883", "qos": "${DLPTH1C_TEST_UNSET:-1}", "retain": "${DLPTH1C_TEST_RETAIN}"}],
+				"alerts": [{"name": "hot", "sensor": "temperature", "above": "${DLPTH1C_TEST_UNSET:-40.5}"}]}`,
+		},
+		{
+			name:    "not a number",
+			config:  `{"devices": [{"id": "lab", "port": "/dev/ttyACM0", "range": "${DLPTH1C_TEST_NOT_NUMBER}"}]}`,
+			wantErr: true,
+		},
+		{
+			// a string without variable is not converted
+			name:    "literal string for a number",
+			config:  `{"devices": [{"id": "lab", "port": "/dev/ttyACM0", "range": "16"}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dlpth1c.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := LoadConfig(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			device := config.Devices[0]
+			if device.Range != 16 || device.Buffer != 100 || device.Sampling["temperature"] != Duration(time.Minute) {
+				t.Errorf("device = %+v", device)
+			}
+			if output := config.Outputs[0]; output.QoS != 1 || !output.Retain {
+				t.Errorf("output = %+v", output)
+			}
+			if above := config.Alerts[0].Above; above == nil || *above != 40.5 {
+				t.Errorf("above = %v, want 40.5", above)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	above := 40.0
+
+	tests := []struct {
+		name   string
+		config Config
+		// every error is reported together
+		want []string
+	}{
+		{
+			name: "valid",
+			config: Config{
+				Devices: []DeviceConfig{{ID: "lab", Port: "/dev/ttyACM0", Range: 8}},
+				Outputs: []OutputConfig{{Type: OutputStore, Dir: "./data"}},
+				Alerts:  []AlertRule{{Name: "hot", Sensor: "temperature", Above: &above}},
+			},
+		},
+		{
+			name: "no device",
+			want: []string{"devices: no device"},
+		},
+		{
+			name: "bad device",
+			config: Config{
+				Devices: []DeviceConfig{
+					{ID: "lab", Range: 3, Mode: SampleSchedule, Overflow: "drop_all"},
+					{ID: "lab", Port: "/dev/ttyACM1", Buffer: -1},
+				},
+			},
+			want: []string{
+				"devices[0]: either port or serial is required",
+				"devices[0].range: 3 is not 2, 4, 8 or 16",
+				`devices[0].sampling: no interval for mode "schedule"`,
+				`devices[0].overflow: "drop_all" is not block, drop_oldest, drop_newest or coalesce`,
+				`devices[1].id: "lab" is duplicated`,
+				"devices[1].buffer: must not be negative",
+			},
+		},
+		{
+			name: "bad outputs and alerts",
+			config: Config{
+				Devices: []DeviceConfig{{ID: "lab", Port: "/dev/ttyACM0"}},
+				Outputs: []OutputConfig{
+					{Type: OutputMQTT, QoS: 2, Devices: []string{"roof"}},
+					{Type: OutputStore, QueueDir: "./queue"},
+					{Type: "kafka"},
+					{Type: OutputAPI, ReadTokens: []string{"t"}, ControlTokens: []string{"t"}},
+				},
+				Alerts: []AlertRule{{Sensor: "tilt"}},
+			},
+			want: []string{
+				`outputs[0].devices: unknown device "roof"`,
+				"outputs[0].broker: is required",
+				"outputs[0].qos: 2 is not 0 or 1",
+				"outputs[1].queue_dir: is only for mqtt and influx",
+				"outputs[1].dir: is required",
+				`outputs[2].type: "kafka" is not mqtt, influx, store, metrics, api or daemon`,
+				"outputs[3]: tokens require cert and key (or insecure_tokens)",
+				"outputs[3]: a token is both in read_tokens and control_tokens",
+				"alerts[0].name: is required",
+				`alerts[0].sensor: "tilt" is not temperature, humidity, pressure, light or broadband`,
+				"alerts[0]: either above or below is required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("no error")
+			}
+
+			var want []string
+			for _, message := range tt.want {
+				field, text, _ := strings.Cut(message, ": ")
+				want = append(want, ConfigError(field, text).Error())
+			}
+			if got := strings.Split(err.Error(), "\n"); !reflect.DeepEqual(got, want) {
+				t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlpth1c.json")
+	if err := os.WriteFile(path, []byte(`{"devices": [{"id": "lab", "port": "/dev/ttyACM0", "rnage": 8}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), `unknown field "rnage"`) {
+		t.Errorf("LoadConfig: %v", err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	defer func(open func(string) (*DLPTH1C, error)) { openConfigDevice = open }(openConfigDevice)
+	openConfigDevice = func(portName string) (*DLPTH1C, error) {
+		if portName == "missing" {
+			return nil, errors.New("no such port")
+		}
+		return NewDLPTH1CFromPort(portName, newFakePort(sensorResponses)), nil
+	}
+
+	path := filepath.Join(t.TempDir(), "dlpth1c.json")
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"devices": [{"id": "lab", "port": "fake0"}], "alerts": [{"name": "hot", "sensor": "temperature", "above": 40}]}`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err := startConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { running.stop() }()
+
+	// invalid: the current config keeps running
+	write(`{"devices": [{"id": "lab", "port": "fake0", "range": 3}]}`)
+	reloadedConfig, reloaded, err := reloadConfig(path, config, running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloadedConfig != config || reloaded != running {
+		t.Error("an invalid config replaced the current one")
+	}
+
+	// valid: the new devices and alert rules are started
+	write(`{"devices": [{"id": "roof", "port": "fake1"}], "alerts": [{"name": "cold", "sensor": "temperature", "below": 0}]}`)
+	if config, running, err = reloadConfig(path, config, running); err != nil {
+		t.Fatal(err)
+	}
+	if id := running.devices[0].config.ID; id != "roof" {
+		t.Errorf("device = %s, want roof", id)
+	}
+	if running.alerts == nil || running.alerts.rules[0].Name != "cold" {
+		t.Errorf("alert rules are not reloaded")
+	}
+
+	// valid but can't be started: the previous config is restored
+	write(`{"devices": [{"id": "lab", "port": "missing"}]}`)
+	if config, running, err = reloadConfig(path, config, running); err != nil {
+		t.Fatal(err)
+	}
+	if id := running.devices[0].config.ID; id != "roof" || config.Devices[0].ID != "roof" {
+		t.Errorf("device = %s, want roof restored", id)
+	}
+}
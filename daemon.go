@@ -0,0 +1,332 @@
+// Define the daemon which shares the device over a Unix domain socket in this file
+package serial
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Default path of the socket the daemon listens on.
+// The directory is not writable by everyone, so no other user can put a socket of their own in its place.
+const DefaultSocketPath string = "/run/dlpth1c/dlpth1c.sock"
+
+// Permissions of the socket and of its directory when the daemon creates it.
+// Only the owner and the group given to ListenAndServe can connect.
+const (
+	socketMode    os.FileMode = 0660
+	socketDirMode os.FileMode = 0750
+)
+
+// Operations of DaemonRequest
+const (
+	DaemonRead     string = "read"
+	DaemonReadAll  string = "read_all"
+	DaemonRange    string = "range"
+	DaemonTransact string = "transact"
+	DaemonStats    string = "stats"
+	DaemonStream   string = "stream"
+)
+
+// DaemonRequest is a line of JSON sent to the daemon.
+// The response has the same ID.
+type DaemonRequest struct {
+	ID uint64 `json:"id"`
+	Op string `json:"op"`
+
+	// read: the name of the sensor (see SensorName)
+	Sensor string `json:"sensor,omitempty"`
+	// range: 2, 4, 8 or 16
+	G int `json:"g,omitempty"`
+	// transact: the raw command
+	Cmd []byte `json:"cmd,omitempty"`
+	// stream: the names of the sensors (every sensor if empty) and the decimation
+	Sensors    []string `json:"sensors,omitempty"`
+	Decimation int      `json:"decimation,omitempty"`
+}
+
+// DaemonResponse is a line of JSON sent by the daemon.
+// A stream keeps sending the responses with the ID of its request until the connection is closed.
+type DaemonResponse struct {
+	ID    uint64          `json:"id"`
+	Data  *TimeSeriesData `json:"data,omitempty"`
+	Raw   []byte          `json:"raw,omitempty"`
+	Stats *Stats          `json:"stats,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// Daemon owns the DLPTH1C and serves it to several processes on the host.
+// Reads and range changes go through the command queue of the DLPTH1C,
+// and streams are the subscriptions of the hub (./hub.go) fed by the sampling loop.
+type Daemon struct {
+	d   *DLPTH1C
+	hub *Hub
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+func NewDaemon(d *DLPTH1C, hub *Hub) *Daemon {
+	return &Daemon{
+		d:         d,
+		hub:       hub,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the Unix domain socket of the path and serves it.
+// The socket is readable and writable by the owner and the group (the group of the process if empty),
+// and its directory is created with the same group if it doesn't exist.
+// An existing directory must be owned by the process (or root) and not writable by the others.
+// A socket file left by a daemon that is not running anymore is removed.
+func (daemon *Daemon) ListenAndServe(path, group string) error {
+	gid := -1
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return err
+		}
+	}
+
+	if err := makeSocketDir(filepath.Dir(path), gid); err != nil {
+		return err
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return &net.OpError{Op: "listen", Net: "unix", Err: os.ErrExist}
+	}
+	os.Remove(path)
+
+	// only the owner can connect until the group and the mode are set
+	l, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(path, -1, gid); err != nil {
+		l.Close()
+		return err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		l.Close()
+		return err
+	}
+
+	return daemon.Serve(l)
+}
+
+// makeSocketDir creates the directory of the socket, which only the owner and the group can enter.
+// An existing directory is left as it is if nobody else can replace the socket in it.
+func makeSocketDir(dir string, gid int) error {
+	if info, err := os.Stat(dir); err == nil {
+		return checkSocketDir(dir, info)
+	}
+
+	if err := os.MkdirAll(dir, socketDirMode); err != nil {
+		return err
+	}
+	// MkdirAll is restricted by the umask
+	if err := os.Chmod(dir, socketDirMode); err != nil {
+		return err
+	}
+	return os.Chown(dir, -1, gid)
+}
+
+// Serve accepts the connections until the listener or the daemon is closed.
+func (daemon *Daemon) Serve(l net.Listener) error {
+	daemon.mu.Lock()
+	if daemon.closed {
+		daemon.mu.Unlock()
+		l.Close()
+		return ClosedError()
+	}
+	daemon.listeners[l] = struct{}{}
+	daemon.mu.Unlock()
+
+	defer func() {
+		daemon.mu.Lock()
+		delete(daemon.listeners, l)
+		daemon.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			daemon.mu.Lock()
+			closed := daemon.closed
+			daemon.mu.Unlock()
+			if closed {
+				return ClosedError()
+			}
+			return err
+		}
+
+		daemon.mu.Lock()
+		daemon.conns[conn] = struct{}{}
+		daemon.mu.Unlock()
+
+		go daemon.serveConn(conn)
+	}
+}
+
+// Close stops the listeners and closes every connection.
+// The DLPTH1C and the hub are left to the caller.
+func (daemon *Daemon) Close() error {
+	daemon.mu.Lock()
+	defer daemon.mu.Unlock()
+
+	daemon.closed = true
+	for l := range daemon.listeners {
+		l.Close()
+	}
+	for conn := range daemon.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// daemonConn writes the responses of one connection.
+// Streams write from their own goroutines.
+type daemonConn struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (c *daemonConn) send(response *DaemonResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.enc.Encode(response)
+}
+
+func (daemon *Daemon) serveConn(conn net.Conn) {
+	var subs []*Subscription
+	defer func() {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+
+		daemon.mu.Lock()
+		delete(daemon.conns, conn)
+		daemon.mu.Unlock()
+		conn.Close()
+	}()
+
+	c := &daemonConn{enc: json.NewEncoder(conn)}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var request DaemonRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			c.send(&DaemonResponse{Error: err.Error()})
+			continue
+		}
+
+		if request.Op == DaemonStream {
+			sub, err := daemon.subscribe(&request)
+			if err != nil {
+				c.send(&DaemonResponse{ID: request.ID, Error: err.Error()})
+				continue
+			}
+			subs = append(subs, sub)
+			go daemon.stream(c, request.ID, sub)
+			continue
+		}
+
+		response := daemon.handle(&request)
+		if err := c.send(response); err != nil {
+			return
+		}
+	}
+}
+
+func (daemon *Daemon) handle(request *DaemonRequest) *DaemonResponse {
+	response := &DaemonResponse{ID: request.ID}
+
+	var err error
+	switch request.Op {
+	case DaemonRead:
+		var cmd byte
+		if cmd, err = SensorCmd(request.Sensor); err == nil {
+			response.Data, err = daemon.d.Read(cmd)
+		}
+	case DaemonReadAll:
+		response.Data, err = daemon.d.ReadAll()
+	case DaemonRange:
+		err = daemon.d.SetRange(request.G)
+	case DaemonTransact:
+		response.Raw, err = daemon.d.Transact(request.Cmd, HighPriority)
+	case DaemonStats:
+		stats := daemon.d.Stats()
+		response.Stats = &stats
+	default:
+		err = InvalidCommandError()
+	}
+
+	if err != nil {
+		response.Error = err.Error()
+	}
+	return response
+}
+
+func (daemon *Daemon) subscribe(request *DaemonRequest) (*Subscription, error) {
+	if daemon.hub == nil {
+		return nil, InvalidCommandError()
+	}
+
+	// a slow client loses the oldest data rather than delaying the others
+	opts := SubscribeOptions{Decimation: request.Decimation, Policy: DropOldest}
+	for _, name := range request.Sensors {
+		cmd, err := SensorCmd(name)
+		if err != nil {
+			return nil, err
+		}
+		opts.Sensors = append(opts.Sensors, cmd)
+	}
+
+	return daemon.hub.Subscribe(opts), nil
+}
+
+func (daemon *Daemon) stream(c *daemonConn, id uint64, sub *Subscription) {
+	for timeSeriesData := range sub.C {
+		if err := c.send(&DaemonResponse{ID: id, Data: timeSeriesData}); err != nil {
+			sub.Unsubscribe()
+		}
+	}
+}
+
+// runDaemon serves the socket until the context is done.
+// The streams are fed by the data from the channel.
+func runDaemon(ctx context.Context, d *DLPTH1C, in <-chan *TimeSeriesData, path, group string) error {
+	hub := NewHub()
+	go hub.Run(ctx, in)
+
+	daemon := NewDaemon(d, hub)
+	defer daemon.Close()
+	go func() {
+		<-ctx.Done()
+		daemon.Close()
+	}()
+
+	log.Printf("serving %s on %s", d.portName, path)
+	err := daemon.ListenAndServe(path, group)
+	if errors.Is(err, ClosedError()) {
+		return nil
+	}
+	return err
+}
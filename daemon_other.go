@@ -0,0 +1,20 @@
+//go:build !unix
+
+package serial
+
+import (
+	"net"
+	"os"
+)
+
+// There is no umask, the socket is protected by its directory.
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
+
+func checkSocketDir(dir string, info os.FileInfo) error {
+	if !info.IsDir() {
+		return SocketDirError(dir, "not a directory")
+	}
+	return nil
+}
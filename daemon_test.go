@@ -0,0 +1,54 @@
+package serial
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDaemonSocketMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "dlpth1c.sock")
+
+	daemon := NewDaemon(NewDLPTH1CFromPort("fake", newFakePort(sensorResponses)), NewHub())
+	done := make(chan error, 1)
+	go func() { done <- daemon.ListenAndServe(path, "") }()
+
+	var conn net.Conn
+	var err error
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if mode := info.Mode().Perm(); mode != socketMode {
+		t.Errorf("socket mode = %v, want %v", mode, socketMode)
+	}
+	if info, err := os.Stat(filepath.Dir(path)); err != nil {
+		t.Fatal(err)
+	} else if mode := info.Mode().Perm(); mode != socketDirMode {
+		t.Errorf("directory mode = %v, want %v", mode, socketDirMode)
+	}
+
+	daemon.Close()
+	if err := <-done; err != ClosedError() {
+		t.Errorf("ListenAndServe: %v", err)
+	}
+}
+
+func TestDaemonUnknownGroup(t *testing.T) {
+	daemon := NewDaemon(NewDLPTH1CFromPort("fake", newFakePort(sensorResponses)), NewHub())
+	defer daemon.Close()
+
+	if err := daemon.ListenAndServe(filepath.Join(t.TempDir(), "dlpth1c.sock"), "no-such-group-dlpth1c"); err == nil {
+		t.Error("listening with an unknown group")
+	}
+}
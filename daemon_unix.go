@@ -0,0 +1,41 @@
+//go:build unix
+
+package serial
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes the sockets created under the restrictive umask, which is shared by the process
+var umaskMu sync.Mutex
+
+// listenUnix creates the socket readable and writable by the owner only,
+// so nobody else can connect before its group and mode are set.
+func listenUnix(path string) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+
+	umask := syscall.Umask(0177)
+	defer syscall.Umask(umask)
+
+	return net.Listen("unix", path)
+}
+
+// checkSocketDir rejects an existing directory where another user could replace the socket:
+// it must be owned by the process (or root) and writable by nobody else.
+func checkSocketDir(dir string, info os.FileInfo) error {
+	if !info.IsDir() {
+		return SocketDirError(dir, "not a directory")
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() && stat.Uid != 0 {
+		return SocketDirError(dir, "owned by another user")
+	}
+	// e.g. /tmp, where nobody can remove the files of the others
+	if info.Mode()&0022 != 0 && info.Mode()&os.ModeSticky == 0 {
+		return SocketDirError(dir, "writable by the group or others")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+//go:build unix
+
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlpth1c.sock")
+
+	l, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// nobody else can connect before the group and the mode are set
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("socket mode = %v, want %v", mode, os.FileMode(0600))
+	}
+}
+
+func TestMakeSocketDir(t *testing.T) {
+	tests := []struct {
+		name string
+		mode os.FileMode
+		file bool
+		ok   bool
+	}{
+		{name: "private", mode: 0700, ok: true},
+		{name: "readable by the others", mode: 0755, ok: true},
+		{name: "writable by the group", mode: 0770},
+		{name: "writable by the others", mode: 0777},
+		{name: "sticky", mode: 0777 | os.ModeSticky, ok: true},
+		{name: "file", mode: 0600, file: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "run")
+			if tt.file {
+				if err := os.WriteFile(dir, nil, tt.mode); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := os.Mkdir(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+			// the umask doesn't restrict Chmod
+			if err := os.Chmod(dir, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			err := makeSocketDir(dir, -1)
+			if (err == nil) != tt.ok {
+				t.Errorf("makeSocketDir: %v, want ok %v", err, tt.ok)
+			}
+
+			// the existing directory is left as it is
+			if info, err := os.Stat(dir); err != nil {
+				t.Fatal(err)
+			} else if mode := info.Mode() & (os.ModePerm | os.ModeSticky); mode != tt.mode {
+				t.Errorf("mode = %v, want %v", mode, tt.mode)
+			}
+		})
+	}
+}
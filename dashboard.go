@@ -0,0 +1,259 @@
+// Define live terminal dashboard in this file
+package serial
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Number of values in a sparkline
+const DashboardHistory int = 60
+
+// Escape sequences of the terminal
+const (
+	clearScreen = "\x1b[H\x1b[2J"
+	hideCursor  = "\x1b[?25l"
+	showCursor  = "\x1b[?25h"
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+type dashboard struct {
+	portName string
+	updated  time.Time
+	samples  int
+
+	// history of the scalar sensors, the oldest first
+	history map[byte][]float64
+
+	tilt       *TiltData
+	vibrations map[byte]*VibrationData
+	sound      *SoundData
+}
+
+func newDashboard(portName string) *dashboard {
+	return &dashboard{
+		portName:   portName,
+		history:    make(map[byte][]float64),
+		vibrations: make(map[byte]*VibrationData),
+	}
+}
+
+// runDashboard redraws the whole screen whenever data comes from the channel.
+func runDashboard(portName string, in <-chan *TimeSeriesData, out io.Writer) {
+	dash := newDashboard(portName)
+
+	fmt.Fprint(out, hideCursor)
+	defer fmt.Fprint(out, showCursor)
+
+	dash.render(out)
+	for timeSeriesData := range in {
+		dash.update(timeSeriesData)
+		dash.render(out)
+	}
+}
+
+func (dash *dashboard) update(timeSeriesData *TimeSeriesData) {
+	dash.updated = timeSeriesData.Time
+	dash.samples++
+
+	for cmd, data := range timeSeriesData.Data {
+		switch value := data.(type) {
+		case TemperatureData:
+			dash.push(cmd, float64(value))
+		case HumidityData:
+			dash.push(cmd, float64(value))
+		case PressureData:
+			dash.push(cmd, float64(value))
+		case LightData:
+			dash.push(cmd, float64(value))
+		case BroadbandData:
+			dash.push(cmd, float64(value))
+		case *TiltData:
+			if value != nil {
+				dash.tilt = value
+			}
+		case *VibrationData:
+			if value != nil {
+				dash.vibrations[cmd] = value
+			}
+		case *SoundData:
+			if value != nil {
+				dash.sound = value
+			}
+		}
+	}
+}
+
+func (dash *dashboard) push(cmd byte, value float64) {
+	// the error code is not a value
+	if value == ParseErrorCodeDLPTH1C {
+		return
+	}
+
+	history := append(dash.history[cmd], value)
+	if len(history) > DashboardHistory {
+		history = history[len(history)-DashboardHistory:]
+	}
+	dash.history[cmd] = history
+}
+
+func (dash *dashboard) render(w io.Writer) {
+	var b strings.Builder
+
+	b.WriteString(clearScreen)
+	fmt.Fprintf(&b, "DLP-TH1C %s    samples: %d    updated: %s\n\n",
+		dash.portName, dash.samples, dash.updated.Format("15:04:05"))
+
+	scalars := []struct {
+		cmd  byte
+		name string
+		unit string
+	}{
+		{TemperatureASCIICmd, "Temperature", "℃"},
+		{HumidityASCIICmd, "Humidity", "%"},
+		{PressureASCIICmd, "Pressure", "hPa"},
+		{LightASCIICmd, "Light", ""},
+		{BroadbandASCIICmd, "Broadband", ""},
+	}
+	for _, scalar := range scalars {
+		history := dash.history[scalar.cmd]
+		if len(history) == 0 {
+			fmt.Fprintf(&b, "%-12s %12s\n", scalar.name, "-")
+			continue
+		}
+		value := fmt.Sprintf("%.2f%s", history[len(history)-1], scalar.unit)
+		fmt.Fprintf(&b, "%-12s %12s  %s\n", scalar.name, value, sparkline(history))
+	}
+
+	b.WriteString("\nTilt\n")
+	b.WriteString(bubbleLevel(dash.tilt))
+
+	vibrations := []struct {
+		cmd  byte
+		name string
+	}{
+		{VibrationXASCIICmd, "Vibration X"},
+		{VibrationYASCIICmd, "Vibration Y"},
+		{VibrationZASCIICmd, "Vibration Z"},
+	}
+	for _, v := range vibrations {
+		fmt.Fprintf(&b, "\n%s\n", v.name)
+		if vibration := dash.vibrations[v.cmd]; vibration != nil {
+			b.WriteString(spectrum(vibration.Peak, vibration.Amp))
+		} else {
+			b.WriteString("  -\n")
+		}
+	}
+
+	b.WriteString("\nSound\n")
+	if dash.sound != nil {
+		b.WriteString(spectrum(dash.sound.Peak, dash.sound.Amp))
+	} else {
+		b.WriteString("  -\n")
+	}
+
+	io.WriteString(w, b.String())
+}
+
+// sparkline scales the values between their minimum and maximum.
+func sparkline(values []float64) string {
+	low, high := values[0], values[0]
+	for _, v := range values {
+		low = math.Min(low, v)
+		high = math.Max(high, v)
+	}
+
+	line := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if high > low {
+			level = int((v - low) / (high - low) * float64(len(sparks)-1))
+		}
+		line[i] = sparks[level]
+	}
+	return string(line)
+}
+
+// Size of the bubble level and the tilt angle at its edge
+const (
+	levelWidth  int     = 21
+	levelHeight int     = 9
+	levelDegree float64 = 45
+)
+
+// bubbleLevel draws the bubble by the angles of X and Y axes against Z axis.
+func bubbleLevel(tilt *TiltData) string {
+	if tilt == nil {
+		return "  -\n"
+	}
+
+	pitch := math.Atan2(float64(tilt.XAxis), float64(tilt.ZAxis)) * 180 / math.Pi
+	roll := math.Atan2(float64(tilt.YAxis), float64(tilt.ZAxis)) * 180 / math.Pi
+
+	position := func(degree float64, size int) int {
+		degree = math.Max(-levelDegree, math.Min(levelDegree, degree))
+		return int(math.Round((degree/levelDegree + 1) / 2 * float64(size-1)))
+	}
+	bubbleX := position(pitch, levelWidth)
+	bubbleY := position(roll, levelHeight)
+
+	var b strings.Builder
+	b.WriteString("  +" + strings.Repeat("-", levelWidth) + "+\n")
+	for y := 0; y < levelHeight; y++ {
+		b.WriteString("  |")
+		for x := 0; x < levelWidth; x++ {
+			switch {
+			case x == bubbleX && y == bubbleY:
+				b.WriteRune('O')
+			case x == levelWidth/2 && y == levelHeight/2:
+				b.WriteRune('+')
+			case x == levelWidth/2 || y == levelHeight/2:
+				b.WriteRune('·')
+			default:
+				b.WriteRune(' ')
+			}
+		}
+		b.WriteString("|")
+
+		switch y {
+		case 0:
+			fmt.Fprintf(&b, "  X: %d  Y: %d  Z: %d", tilt.XAxis, tilt.YAxis, tilt.ZAxis)
+		case 1:
+			fmt.Fprintf(&b, "  pitch: %.1f°  roll: %.1f°", pitch, roll)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("  +" + strings.Repeat("-", levelWidth) + "+\n")
+	return b.String()
+}
+
+// Width of the longest bar of the spectrum
+const spectrumWidth int = 40
+
+// spectrum draws the amplitude of 6 peaks as bars, scaled by the largest one.
+func spectrum(peak [6]int64, amp [6]float64) string {
+	largest := 0.0
+	for _, a := range amp {
+		if a > largest {
+			largest = a
+		}
+	}
+
+	var b strings.Builder
+	for i := range peak {
+		width := 0
+		if largest > 0 {
+			width = int(amp[i] / largest * float64(spectrumWidth))
+		}
+		// a negative (or broken) amplitude has no bar
+		if width < 0 || width > spectrumWidth {
+			width = 0
+		}
+		fmt.Fprintf(&b, "  %6dHz |%-*s %v\n", peak[i], spectrumWidth, strings.Repeat("█", width), amp[i])
+	}
+	return b.String()
+}
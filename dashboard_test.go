@@ -0,0 +1,38 @@
+package serial
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSpectrum(t *testing.T) {
+	peak := [6]int64{100, 200, 300, 400, 500, 600}
+
+	tests := []struct {
+		name string
+		amp  [6]float64
+		// length of each bar
+		want [6]int
+	}{
+		{"scaled by the largest", [6]float64{2, 1, 0.5, 0, 0, 0}, [6]int{40, 20, 10, 0, 0, 0}},
+		{"negative amplitude", [6]float64{1, -0.5, 0, 0, 0, 0}, [6]int{40, 0, 0, 0, 0, 0}},
+		{"all negative", [6]float64{-1, -2, -3, -4, -5, -6}, [6]int{}},
+		{"all zero", [6]float64{}, [6]int{}},
+		{"not a number", [6]float64{1, math.NaN(), 0, 0, 0, 0}, [6]int{40, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := strings.Split(strings.TrimSuffix(spectrum(peak, tt.amp), "\n"), "\n")
+			if len(lines) != len(peak) {
+				t.Fatalf("%d lines", len(lines))
+			}
+			for i, line := range lines {
+				if got := strings.Count(line, "█"); got != tt.want[i] {
+					t.Errorf("bar %d = %d, want %d", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
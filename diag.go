@@ -0,0 +1,121 @@
+// Define diag mode (soak test of the serial link) in this file
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Default number of rounds of the soak test.
+// Each round requests every sensor once.
+const DefaultDiagRounds int = 10
+
+// runDiag requests every sensor repeatedly and prints how much data was lost.
+// The argument is the number of rounds (e.g. "100") or the duration of the test (e.g. "30m").
+func runDiag(d *DLPTH1C, args ...string) error {
+	rounds := DefaultDiagRounds
+	var duration time.Duration
+
+	if len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil && n > 0 {
+			rounds = n
+		} else if dur, err := time.ParseDuration(args[0]); err == nil && dur > 0 {
+			rounds = 0
+			duration = dur
+		} else {
+			return InvalidCommandError()
+		}
+	}
+
+	start := time.Now()
+	requested := 0
+	interrupted := ""
+rounds:
+	for round := 1; ; round++ {
+		if rounds > 0 && round > rounds {
+			break
+		}
+		if rounds == 0 && time.Since(start) >= duration {
+			break
+		}
+
+		for _, cmd := range SensorASCIICmds {
+			_, _, _, err := d.sample(cmd)
+			if errors.Is(err, ClosedError()) {
+				// e.g. by a signal, the rounds so far are reported
+				interrupted = " (interrupted)"
+				break rounds
+			}
+			if err != nil {
+				log.Printf("%s: %v", SensorName(cmd), err)
+			}
+		}
+		requested++
+
+		fmt.Printf("round %d done (%v)\n", round, time.Since(start).Round(time.Second))
+	}
+
+	title := fmt.Sprintf("DATA LOSS REPORT: %d rounds in %v%s", requested, time.Since(start).Round(time.Second), interrupted)
+	printDiagReport(os.Stdout, d.Stats(), title)
+	return nil
+}
+
+func printDiagReport(w io.Writer, stats Stats, title string) {
+	fmt.Fprintf(w, "\n===============================================================\n")
+	fmt.Fprintf(w, "%s\n\n", title)
+	fmt.Fprintf(w, "%-12s %6s %6s %6s %6s %7s %9s %9s %9s\n",
+		"SENSOR", "REQ", "OK", "PARSE", "LOST", "LOSS", "MEAN", "P95", "MAX")
+
+	var totalOK, totalParse, totalLost uint64
+	for _, cmd := range SensorASCIICmds {
+		// every attempt is counted, including retries
+		requests := stats.Requests[cmd]
+		ok := stats.Samples[cmd]
+		parse := stats.ParseFailures[cmd]
+
+		// requests without any response (write error, read error or timeout)
+		var lost uint64
+		if requests > ok+parse {
+			lost = requests - ok - parse
+		}
+
+		loss := 0.0
+		if requests > 0 {
+			loss = float64(parse+lost) / float64(requests) * 100
+		}
+
+		latency, exist := stats.Latency[cmd]
+		if !exist {
+			latency = newLatencyHistogram()
+		}
+
+		fmt.Fprintf(w, "%-12s %6d %6d %6d %6d %6.1f%% %9v %9v %9v\n",
+			SensorName(cmd), requests, ok, parse, lost, loss,
+			latency.Mean().Round(time.Millisecond),
+			latency.Quantile(0.95).Round(time.Millisecond),
+			latency.Max.Round(time.Millisecond))
+
+		totalOK += ok
+		totalParse += parse
+		totalLost += lost
+	}
+
+	fmt.Fprintf(w, "\nsamples: %d, parse failures: %d, lost: %d\n", totalOK, totalParse, totalLost)
+	fmt.Fprintf(w, "commands sent: %d, bytes written: %d, bytes read: %d\n",
+		stats.CommandsSent, stats.BytesWritten, stats.BytesRead)
+	fmt.Fprintf(w, "bytes discarded: %d, timeouts: %d, retries: %d\n",
+		stats.BytesDiscarded, stats.Timeouts, stats.Retries)
+	fmt.Fprintf(w, "resyncs: %d, resync failures: %d\n", stats.Resyncs, stats.ResyncFailures)
+
+	var dropped uint64
+	for _, n := range stats.Dropped {
+		dropped += n
+	}
+	fmt.Fprintf(w, "dropped by the output buffer: %d\n", dropped)
+	fmt.Fprintf(w, "===============================================================\n\n")
+}
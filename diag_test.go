@@ -0,0 +1,80 @@
+package serial
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintDiagReport(t *testing.T) {
+	stats := Stats{
+		BytesRead:      1200,
+		BytesWritten:   23,
+		BytesDiscarded: 7,
+		CommandsSent:   23,
+		Timeouts:       2,
+		Retries:        3,
+		Resyncs:        1,
+		Requests:       map[byte]uint64{TemperatureASCIICmd: 10, HumidityASCIICmd: 10, LightASCIICmd: 3},
+		Samples:        map[byte]uint64{TemperatureASCIICmd: 7, HumidityASCIICmd: 10, LightASCIICmd: 2},
+		ParseFailures:  map[byte]uint64{TemperatureASCIICmd: 1, LightASCIICmd: 2},
+		Dropped:        map[byte]uint64{TemperatureASCIICmd: 4, HumidityASCIICmd: 1},
+		Latency:        map[byte]*LatencyHistogram{},
+	}
+	temperature := newLatencyHistogram()
+	for _, latency := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond, 300 * time.Millisecond} {
+		temperature.observe(latency)
+	}
+	stats.Latency[TemperatureASCIICmd] = temperature
+
+	var b bytes.Buffer
+	printDiagReport(&b, stats, "DATA LOSS REPORT: 10 rounds in 5s")
+	report := b.String()
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"title", "DATA LOSS REPORT: 10 rounds in 5s\n"},
+		// 1 parse failure and 2 requests without response of 10
+		{"loss", "temperature      10      7      1      2   30.0%     105ms     300ms     300ms\n"},
+		{"no loss", "humidity         10     10      0      0    0.0%        0s        0s        0s\n"},
+		// more responses than requests, so none is lost
+		{"more responses than requests", "light             3      2      2      0   66.7%        0s        0s        0s\n"},
+		{"no request", "pressure          0      0      0      0    0.0%        0s        0s        0s\n"},
+		{"totals", "samples: 19, parse failures: 3, lost: 2\n"},
+		{"bytes", "commands sent: 23, bytes written: 23, bytes read: 1200\n"},
+		{"errors", "bytes discarded: 7, timeouts: 2, retries: 3\n"},
+		{"resyncs", "resyncs: 1, resync failures: 0\n"},
+		{"dropped", "dropped by the output buffer: 5\n"},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(report, tt.line) {
+			t.Errorf("%s: the report doesn't have %q:\n%s", tt.name, tt.line, report)
+		}
+	}
+
+	// every sensor has a line
+	for _, cmd := range SensorASCIICmds {
+		if !strings.Contains(report, "\n"+SensorName(cmd)+" ") {
+			t.Errorf("%s is not in the report", SensorName(cmd))
+		}
+	}
+}
+
+func TestRunDiagInvalidArgs(t *testing.T) {
+	port := newFakePort(sensorResponses)
+	d := NewDLPTH1CFromPort("fake", port)
+	defer d.Close()
+
+	for _, arg := range []string{"0", "-1", "-5m", "ten"} {
+		if err := runDiag(d, arg); err == nil || err.Error() != InvalidCommandError().Error() {
+			t.Errorf("runDiag(%q): %v, want %v", arg, err, InvalidCommandError())
+		}
+	}
+	// nothing is requested
+	if got := port.commands(); got != "" {
+		t.Errorf("commands = %q, want none", got)
+	}
+}
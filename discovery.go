@@ -0,0 +1,107 @@
+// Define Home Assistant MQTT discovery in this file
+package serial
+
+import (
+	"encoding/json"
+	"path"
+)
+
+// Default prefix of discovery topics of Home Assistant
+const DefaultDiscoveryPrefix string = "homeassistant"
+
+// Entity of Home Assistant for a sensor (or an axis of tilt).
+// Home Assistant charts the entities which have a unit.
+type discoveryEntity struct {
+	cmd           byte
+	objectID      string
+	name          string
+	deviceClass   string
+	unit          string
+	valueTemplate string
+}
+
+var discoveryEntities = []discoveryEntity{
+	{TemperatureASCIICmd, "temperature", "Temperature", "temperature", "°C", "{{ value_json.value }}"},
+	{HumidityASCIICmd, "humidity", "Humidity", "humidity", "%", "{{ value_json.value }}"},
+	{PressureASCIICmd, "pressure", "Pressure", "atmospheric_pressure", "hPa", "{{ value_json.value }}"},
+	{LightASCIICmd, "light", "Light", "illuminance", "lx", "{{ value_json.value }}"},
+	{BroadbandASCIICmd, "broadband", "Broadband", "sound_pressure", "dB", "{{ value_json.value }}"},
+	{TiltASCIICmd, "tilt_x", "Tilt X", "", "", "{{ value_json.value.x }}"},
+	{TiltASCIICmd, "tilt_y", "Tilt Y", "", "", "{{ value_json.value.y }}"},
+	{TiltASCIICmd, "tilt_z", "Tilt Z", "", "", "{{ value_json.value.z }}"},
+}
+
+// Config payload of MQTT sensor of Home Assistant
+type discoveryConfig struct {
+	Name                string          `json:"name"`
+	UniqueID            string          `json:"unique_id"`
+	StateTopic          string          `json:"state_topic"`
+	ValueTemplate       string          `json:"value_template"`
+	DeviceClass         string          `json:"device_class,omitempty"`
+	UnitOfMeasurement   string          `json:"unit_of_measurement,omitempty"`
+	StateClass          string          `json:"state_class"`
+	AvailabilityTopic   string          `json:"availability_topic"`
+	PayloadAvailable    string          `json:"payload_available"`
+	PayloadNotAvailable string          `json:"payload_not_available"`
+	Device              discoveryDevice `json:"device"`
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// discoveryMessages makes retained config messages,
+// so the device and its entities appear in Home Assistant automatically.
+func (p *MQTTPublisher) discoveryMessages() ([]*mqttMessage, error) {
+	prefix := p.opts.DiscoveryPrefix
+	if prefix == "" {
+		prefix = DefaultDiscoveryPrefix
+	}
+
+	nodeID := "dlpth1c_" + topicLevel(p.device)
+	device := discoveryDevice{
+		Identifiers:  []string{nodeID},
+		Name:         "DLP-TH1C " + topicLevel(p.device),
+		Manufacturer: "DLP Design",
+		Model:        "DLP-TH1C",
+	}
+
+	messages := make([]*mqttMessage, 0, len(discoveryEntities))
+	for _, entity := range discoveryEntities {
+		template := p.opts.TopicTemplate
+		if sensorTemplate, exist := p.opts.SensorTopics[entity.cmd]; exist {
+			template = sensorTemplate
+		}
+
+		config := discoveryConfig{
+			Name:                entity.name,
+			UniqueID:            nodeID + "_" + entity.objectID,
+			StateTopic:          p.topic(template, entity.cmd),
+			ValueTemplate:       entity.valueTemplate,
+			DeviceClass:         entity.deviceClass,
+			UnitOfMeasurement:   entity.unit,
+			StateClass:          "measurement",
+			AvailabilityTopic:   p.topic(p.opts.StatusTemplate, 0),
+			PayloadAvailable:    MQTTOnline,
+			PayloadNotAvailable: MQTTOffline,
+			Device:              device,
+		}
+
+		b, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, &mqttMessage{
+			topic:   path.Join(prefix, "sensor", nodeID, entity.objectID, "config"),
+			payload: b,
+			qos:     1,
+			retain:  true,
+		})
+	}
+
+	return messages, nil
+}
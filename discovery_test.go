@@ -0,0 +1,100 @@
+package serial
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoveryMessages(t *testing.T) {
+	p := &MQTTPublisher{device: "/dev/ttyACM0", opts: MQTTOptions{
+		TopicTemplate:  DefaultMQTTTopicTemplate,
+		StatusTemplate: DefaultMQTTStatusTemplate,
+		SensorTopics:   map[byte]string{LightASCIICmd: "lab/{device}/lux"},
+	}}
+
+	messages, err := p.discoveryMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		topic       string
+		uniqueID    string
+		stateTopic  string
+		template    string
+		deviceClass string
+		unit        string
+	}{
+		{"homeassistant/sensor/dlpth1c_ttyACM0/temperature/config", "dlpth1c_ttyACM0_temperature", "dlpth1c/ttyACM0/temperature", "{{ value_json.value }}", "temperature", "°C"},
+		{"homeassistant/sensor/dlpth1c_ttyACM0/humidity/config", "dlpth1c_ttyACM0_humidity", "dlpth1c/ttyACM0/humidity", "{{ value_json.value }}", "humidity", "%"},
+		{"homeassistant/sensor/dlpth1c_ttyACM0/pressure/config", "dlpth1c_ttyACM0_pressure", "dlpth1c/ttyACM0/pressure", "{{ value_json.value }}", "atmospheric_pressure", "hPa"},
+		// the topic of the sensor is used
+		{"homeassistant/sensor/dlpth1c_ttyACM0/light/config", "dlpth1c_ttyACM0_light", "lab/ttyACM0/lux", "{{ value_json.value }}", "illuminance", "lx"},
+		{"homeassistant/sensor/dlpth1c_ttyACM0/broadband/config", "dlpth1c_ttyACM0_broadband", "dlpth1c/ttyACM0/broadband", "{{ value_json.value }}", "sound_pressure", "dB"},
+		{"homeassistant/sensor/dlpth1c_ttyACM0/tilt_x/config", "dlpth1c_ttyACM0_tilt_x", "dlpth1c/ttyACM0/tilt", "{{ value_json.value.x }}", "", ""},
+		{"homeassistant/sensor/dlpth1c_ttyACM0/tilt_y/config", "dlpth1c_ttyACM0_tilt_y", "dlpth1c/ttyACM0/tilt", "{{ value_json.value.y }}", "", ""},
+		{"homeassistant/sensor/dlpth1c_ttyACM0/tilt_z/config", "dlpth1c_ttyACM0_tilt_z", "dlpth1c/ttyACM0/tilt", "{{ value_json.value.z }}", "", ""},
+	}
+	if len(messages) != len(tests) {
+		t.Fatalf("%d messages, want %d", len(messages), len(tests))
+	}
+
+	device := discoveryDevice{
+		Identifiers:  []string{"dlpth1c_ttyACM0"},
+		Name:         "DLP-TH1C ttyACM0",
+		Manufacturer: "DLP Design",
+		Model:        "DLP-TH1C",
+	}
+	for i, tt := range tests {
+		message := messages[i]
+		if message.topic != tt.topic || message.qos != 1 || !message.retain {
+			t.Errorf("message %d = (%q, qos %d, retain %v), want (%q, qos 1, retained)", i, message.topic, message.qos, message.retain, tt.topic)
+		}
+
+		var config discoveryConfig
+		if err := json.Unmarshal(message.payload, &config); err != nil {
+			t.Fatal(err)
+		}
+		want := discoveryConfig{
+			Name:                config.Name,
+			UniqueID:            tt.uniqueID,
+			StateTopic:          tt.stateTopic,
+			ValueTemplate:       tt.template,
+			DeviceClass:         tt.deviceClass,
+			UnitOfMeasurement:   tt.unit,
+			StateClass:          "measurement",
+			AvailabilityTopic:   "dlpth1c/ttyACM0/status",
+			PayloadAvailable:    MQTTOnline,
+			PayloadNotAvailable: MQTTOffline,
+			Device:              device,
+		}
+		if !reflect.DeepEqual(config, want) {
+			t.Errorf("config of %s = %+v, want %+v", tt.topic, config, want)
+		}
+	}
+}
+
+func TestDiscoveryPrefix(t *testing.T) {
+	p := &MQTTPublisher{device: "lab/1", opts: MQTTOptions{
+		TopicTemplate:   DefaultMQTTTopicTemplate,
+		StatusTemplate:  "status/{device}",
+		DiscoveryPrefix: "ha",
+	}}
+
+	messages, err := p.discoveryMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config discoveryConfig
+	if err := json.Unmarshal(messages[0].payload, &config); err != nil {
+		t.Fatal(err)
+	}
+	if messages[0].topic != "ha/sensor/dlpth1c_1/temperature/config" {
+		t.Errorf("topic = %q", messages[0].topic)
+	}
+	if config.UniqueID != "dlpth1c_1_temperature" || config.StateTopic != "dlpth1c/1/temperature" || config.AvailabilityTopic != "status/1" {
+		t.Errorf("config = %+v", config)
+	}
+}
@@ -0,0 +1,568 @@
+// Define disk-backed store-and-forward queue in this file
+package serial
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default options of DiskQueue
+const (
+	DefaultQueueSegmentSize int64         = 4 << 20
+	DefaultQueueBatchSize   int           = 100
+	DefaultQueueRetryDelay  time.Duration = time.Second
+	DefaultQueueMaxDelay    time.Duration = time.Minute
+)
+
+const (
+	queueExtension  string = ".q"
+	queueCursorFile string = "cursor"
+)
+
+type DiskQueueOptions struct {
+	// MaxSize drops the oldest segments (even if not delivered) until the total size is under it (0 is unlimited).
+	// The segment being written is dropped too, if it alone is over MaxSize.
+	MaxSize int64
+	// SegmentSize is the size where a new segment file is started.
+	SegmentSize int64
+}
+
+// DiskQueue persists TimeSeriesData until they are delivered to a sink,
+// so nothing is lost while the uplink is down or the process restarts.
+// Records have the same format as Store (./store.go), in numbered segment files,
+// and the position of the first undelivered record is kept in the cursor file.
+type DiskQueue struct {
+	dir  string
+	opts DiskQueueOptions
+
+	mu sync.Mutex
+	// segment being written
+	writeSeq  uint64
+	writeFile *os.File
+	writeSize int64
+	// position of the first undelivered record
+	readSeq    uint64
+	readOffset int64
+
+	backlog      int
+	backlogBytes int64
+	dropped      int
+	// a segment was given up after a corrupted record, so the backlog is counted again by ack
+	recount bool
+
+	notify chan struct{}
+}
+
+// OpenDiskQueue opens (or creates) the queue in the directory.
+// Undelivered records of the previous run are delivered first.
+func OpenDiskQueue(dir string, opts DiskQueueOptions) (*DiskQueue, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultQueueSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	q := &DiskQueue{
+		dir:    dir,
+		opts:   opts,
+		notify: make(chan struct{}, 1),
+	}
+
+	seqs, err := q.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(seqs) > 0 {
+		// A new segment is started, in case the last one ends with a broken record.
+		q.readSeq = seqs[0]
+		q.writeSeq = seqs[len(seqs)-1] + 1
+	} else {
+		q.readSeq, q.writeSeq = 1, 1
+	}
+
+	if err := q.loadCursor(seqs); err != nil {
+		return nil, err
+	}
+
+	// the segments before the cursor have been delivered
+	for _, seq := range seqs {
+		if seq < q.readSeq {
+			os.Remove(q.segmentPath(seq))
+		}
+	}
+	if err := q.countBacklog(); err != nil {
+		return nil, err
+	}
+	if err := q.openWriteSegment(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *DiskQueue) segmentPath(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%016d%s", seq, queueExtension))
+}
+
+// segments lists the sequence numbers of the segment files in order.
+func (q *DiskQueue) segments() ([]uint64, error) {
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*"+queueExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := make([]uint64, 0, len(paths))
+	for _, p := range paths {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(p), queueExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// loadCursor reads the cursor file, which is ignored if its segment doesn't exist anymore.
+func (q *DiskQueue) loadCursor(seqs []uint64) error {
+	b, err := os.ReadFile(filepath.Join(q.dir, queueCursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(b) != 16 {
+		log.Printf("%s: %v", q.dir, CorruptedRecordError())
+		return nil
+	}
+
+	seq := binary.LittleEndian.Uint64(b)
+	offset := int64(binary.LittleEndian.Uint64(b[8:]))
+	for _, s := range seqs {
+		if s == seq {
+			q.readSeq, q.readOffset = seq, offset
+			return nil
+		}
+	}
+	return nil
+}
+
+// saveCursor writes the cursor atomically (by rename).
+func (q *DiskQueue) saveCursor() error {
+	b := binary.LittleEndian.AppendUint64(nil, q.readSeq)
+	b = binary.LittleEndian.AppendUint64(b, uint64(q.readOffset))
+
+	tmp := filepath.Join(q.dir, queueCursorFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(q.dir, queueCursorFile))
+}
+
+// countBacklog counts the undelivered records from the cursor.
+func (q *DiskQueue) countBacklog() error {
+	q.backlog, q.backlogBytes = 0, 0
+
+	for seq := q.readSeq; seq <= q.writeSeq; seq++ {
+		offset := int64(0)
+		if seq == q.readSeq {
+			offset = q.readOffset
+		}
+
+		_, err := q.scan(seq, offset, 0, func(_ []byte, size int64) bool {
+			q.backlog++
+			q.backlogBytes += size
+			return true
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *DiskQueue) openWriteSegment() error {
+	f, err := os.OpenFile(q.segmentPath(q.writeSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	q.writeFile = f
+	q.writeSize = info.Size()
+	return nil
+}
+
+// Push persists the data at the end of the queue.
+func (q *DiskQueue) Push(timeSeriesData *TimeSeriesData) error {
+	payload := appendTimeSeriesData(nil, timeSeriesData)
+
+	record := make([]byte, 0, recordHeaderSize+len(payload)+recordTrailerSize)
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(payload)))
+	record = append(record, payload...)
+	record = binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(payload))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// room is made before the write, so the new record is kept even if its segment has to go
+	if err := q.enforceMaxSize(int64(len(record))); err != nil {
+		return err
+	}
+
+	if q.writeSize >= q.opts.SegmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := q.writeFile.Write(record); err != nil {
+		// a part of the record would break the records after it
+		if truncateErr := q.writeFile.Truncate(q.writeSize); truncateErr != nil {
+			log.Printf("%s: %v", q.segmentPath(q.writeSeq), truncateErr)
+		}
+		return err
+	}
+	q.writeSize += int64(len(record))
+	q.backlog++
+	q.backlogBytes += int64(len(record))
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// rotate starts a new segment to write.
+func (q *DiskQueue) rotate() error {
+	q.writeFile.Close()
+	q.writeSeq++
+	return q.openWriteSegment()
+}
+
+// enforceMaxSize drops the oldest segments while the total size and the incoming bytes are over MaxSize.
+// The segment being written is dropped after a new one is started.
+func (q *DiskQueue) enforceMaxSize(incoming int64) error {
+	if q.opts.MaxSize <= 0 {
+		return nil
+	}
+
+	for q.diskSize()+incoming > q.opts.MaxSize {
+		if q.readSeq == q.writeSeq {
+			if q.writeSize == 0 {
+				return nil
+			}
+			if err := q.rotate(); err != nil {
+				return err
+			}
+		}
+
+		dropped, droppedBytes := 0, int64(0)
+		q.scan(q.readSeq, q.readOffset, 0, func(_ []byte, size int64) bool {
+			dropped++
+			droppedBytes += size
+			return true
+		})
+
+		if err := os.Remove(q.segmentPath(q.readSeq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		log.Printf("%s: %d undelivered record(s) dropped by max size", q.dir, dropped)
+
+		q.dropped += dropped
+		q.backlog -= dropped
+		q.backlogBytes -= droppedBytes
+		q.readSeq++
+		q.readOffset = 0
+		if err := q.saveCursor(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diskSize is the total size of the segments not deleted yet.
+func (q *DiskQueue) diskSize() int64 {
+	var total int64
+	for seq := q.readSeq; seq <= q.writeSeq; seq++ {
+		if info, err := os.Stat(q.segmentPath(seq)); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// scan calls the function for each record from the offset of the segment,
+// until it returns false or limit (if it is over 0) records are scanned.
+// The size given to the function is the size of the whole record.
+// A corrupted record ends the segment, and it is reported by corrupted.
+func (q *DiskQueue) scan(seq uint64, offset int64, limit int, fn func(payload []byte, size int64) bool) (corrupted bool, err error) {
+	f, err := os.Open(q.segmentPath(seq))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	r := bufio.NewReader(f)
+	for n := 0; limit <= 0 || n < limit; n++ {
+		payload, err := readRecord(r)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			log.Printf("%s: %v", q.segmentPath(seq), err)
+			return true, nil
+		}
+
+		size := int64(recordHeaderSize + len(payload) + recordTrailerSize)
+		if !fn(payload, size) {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// peek returns up to n undelivered records from the cursor, and the position after them.
+func (q *DiskQueue) peek(n int) (batch []*TimeSeriesData, seq uint64, offset int64, size int64, err error) {
+	seq, offset = q.readSeq, q.readOffset
+
+	for {
+		corrupted, err := q.scan(seq, offset, n-len(batch), func(payload []byte, recordSize int64) bool {
+			offset += recordSize
+			size += recordSize
+
+			timeSeriesData, err := decodeTimeSeriesData(payload)
+			if err != nil {
+				log.Printf("%s: %v", q.segmentPath(seq), err)
+				return true
+			}
+			batch = append(batch, timeSeriesData)
+			return true
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, 0, 0, 0, err
+		}
+
+		// Nothing after a corrupted record can be read, so the segment being written is given up
+		// and a new one is started. Otherwise the records pushed after it would never be delivered.
+		if corrupted && seq == q.writeSeq {
+			if err := q.rotate(); err != nil {
+				return nil, 0, 0, 0, err
+			}
+		}
+		if corrupted {
+			q.recount = true
+		}
+
+		// the rest of this segment is read, go on to the next one
+		if len(batch) < n && seq < q.writeSeq {
+			seq++
+			offset = 0
+			continue
+		}
+		return batch, seq, offset, size, nil
+	}
+}
+
+// ack moves the cursor after the delivered records, and deletes the segments passed.
+func (q *DiskQueue) ack(seq uint64, offset int64, delivered int, size int64) error {
+	for s := q.readSeq; s < seq; s++ {
+		if err := os.Remove(q.segmentPath(s)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	q.readSeq, q.readOffset = seq, offset
+	q.backlog -= delivered
+	q.backlogBytes -= size
+	if q.backlog < 0 {
+		q.backlog, q.backlogBytes = 0, 0
+	}
+
+	// the records after a corrupted one are lost
+	if q.recount {
+		backlog := q.backlog
+		if err := q.countBacklog(); err != nil {
+			return err
+		}
+		if lost := backlog - q.backlog; lost > 0 {
+			log.Printf("%s: %d undelivered record(s) lost after a corrupted record", q.dir, lost)
+			q.dropped += lost
+		}
+		q.recount = false
+	}
+	return q.saveCursor()
+}
+
+// Backlog returns the number and the size of undelivered records,
+// and the number of records dropped by MaxSize so far.
+func (q *DiskQueue) Backlog() (records int, bytes int64, dropped int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.backlog, q.backlogBytes, q.dropped
+}
+
+// Forward sends the records to the function in order, batchSize at once,
+// until the context is done. A failed batch is sent again (with backoff) until it succeeds,
+// so the order is kept and nothing is skipped, except a batch failed by PermanentSinkError.
+func (q *DiskQueue) Forward(ctx context.Context, batchSize int, send func([]*TimeSeriesData) error) error {
+	if batchSize <= 0 {
+		batchSize = DefaultQueueBatchSize
+	}
+	delay := DefaultQueueRetryDelay
+
+	for {
+		q.mu.Lock()
+		readSeq := q.readSeq
+		batch, seq, offset, size, err := q.peek(batchSize)
+		q.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		// nothing to deliver, unless a segment was passed (e.g. given up after a corrupted record)
+		if size == 0 && seq == readSeq {
+			select {
+			case <-q.notify:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		var permanent *permanentSinkError
+		if len(batch) > 0 {
+			if err := send(batch); errors.As(err, &permanent) {
+				log.Printf("%s: %v (%d data given up)", q.dir, err, len(batch))
+			} else if err != nil {
+				log.Printf("%s: %v (retry in %v)", q.dir, err, delay)
+
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				if delay < DefaultQueueMaxDelay {
+					delay *= 2
+				}
+				continue
+			}
+		}
+		delay = DefaultQueueRetryDelay
+
+		q.mu.Lock()
+		// the records could have been dropped by MaxSize meanwhile
+		if seq >= q.readSeq {
+			err = q.ack(seq, offset, len(batch), size)
+		}
+		q.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Write pushes the batch, so DiskQueue is a Sink (./sink.go) in front of a network sink.
+func (q *DiskQueue) Write(ctx context.Context, batch []*TimeSeriesData) error {
+	for _, timeSeriesData := range batch {
+		if err := q.Push(timeSeriesData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForwardTo forwards the records to the sink until the context is done.
+// If the sink is a Flusher, a batch is delivered only after Flush, not when Write returns.
+func (q *DiskQueue) ForwardTo(ctx context.Context, batchSize int, sink Sink) error {
+	return q.Forward(ctx, batchSize, func(batch []*TimeSeriesData) error {
+		if err := sink.Write(ctx, batch); err != nil {
+			return err
+		}
+		if flusher, ok := sink.(Flusher); ok {
+			return flusher.Flush(ctx)
+		}
+		return nil
+	})
+}
+
+// queuedSink pushes the data into a DiskQueue, and forwards them to the sink in background.
+type queuedSink struct {
+	queue  *DiskQueue
+	sink   Sink
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newQueuedSink opens the queue in the directory in front of the sink (e.g. of the mqtt and influx outputs).
+func newQueuedSink(dir string, opts DiskQueueOptions, batchSize int, sink Sink) (Sink, error) {
+	q, err := OpenDiskQueue(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	qs := &queuedSink{queue: q, sink: sink, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(qs.done)
+		if err := q.ForwardTo(ctx, batchSize, sink); err != nil && err != context.Canceled {
+			log.Printf("%s: %v", dir, err)
+		}
+	}()
+	return qs, nil
+}
+
+func (qs *queuedSink) Write(ctx context.Context, batch []*TimeSeriesData) error {
+	return qs.queue.Write(ctx, batch)
+}
+
+// Close waits for the backlog to be delivered up to ShutdownTimeout, and closes the queue and the sink.
+// The data not delivered stays in the queue for the next run.
+func (qs *queuedSink) Close() error {
+	for deadline := time.Now().Add(ShutdownTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if records, _, _ := qs.queue.Backlog(); records == 0 {
+			break
+		}
+	}
+	qs.cancel()
+	<-qs.done
+
+	err := qs.queue.Close()
+	if closeErr := qs.sink.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close closes the segment being written.
+func (q *DiskQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.writeFile.Close()
+}
@@ -0,0 +1,216 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flushingSink accepts every batch, but the batches are delivered only when Flush returns.
+type flushingSink struct {
+	mu      sync.Mutex
+	written []SensorData
+	flush   chan error
+}
+
+func (s *flushingSink) Write(ctx context.Context, batch []*TimeSeriesData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, timeSeriesData := range batch {
+		s.written = append(s.written, timeSeriesData.Data[TemperatureASCIICmd])
+	}
+	return nil
+}
+
+func (s *flushingSink) Flush(ctx context.Context) error {
+	select {
+	case err := <-s.flush:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *flushingSink) Close() error {
+	return nil
+}
+
+func waitBacklog(t *testing.T, q *DiskQueue, want int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if records, _, _ := q.Backlog(); records == want {
+			return
+		}
+	}
+	records, _, _ := q.Backlog()
+	t.Fatalf("backlog = %d, want %d", records, want)
+}
+
+func TestDiskQueueFlush(t *testing.T) {
+	q, err := OpenDiskQueue(t.TempDir(), DiskQueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	sink := &flushingSink{flush: make(chan error)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- q.ForwardTo(ctx, 10, sink) }()
+
+	if err := q.Push(storeData(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// written to the sink, but not delivered until the flush
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		sink.mu.Lock()
+		written := len(sink.written)
+		sink.mu.Unlock()
+		if written > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("nothing is written")
+		}
+	}
+	if records, _, _ := q.Backlog(); records != 1 {
+		t.Errorf("backlog before the flush = %d, want 1", records)
+	}
+
+	sink.flush <- nil
+	waitBacklog(t, q, 0)
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("ForwardTo: %v", err)
+	}
+}
+
+// forward delivers the queue until the backlog is empty, and returns the temperatures delivered.
+func forward(t *testing.T, q *DiskQueue, send func([]*TimeSeriesData) error) []SensorData {
+	t.Helper()
+
+	var mu sync.Mutex
+	delivered := []SensorData{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- q.Forward(ctx, 10, func(batch []*TimeSeriesData) error {
+			if err := send(batch); err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, timeSeriesData := range batch {
+				delivered = append(delivered, timeSeriesData.Data[TemperatureASCIICmd])
+			}
+			return nil
+		})
+	}()
+
+	waitBacklog(t, q, 0)
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	return delivered
+}
+
+func TestDiskQueueCorruptedWriteSegment(t *testing.T) {
+	q, err := OpenDiskQueue(t.TempDir(), DiskQueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	q.Push(storeData(1))
+	// garbage in the segment being written, so the record after it can't be read
+	q.writeFile.Write([]byte{40, 0, 0, 0, 1, 2, 3})
+	q.writeSize += 7
+	q.Push(storeData(2))
+
+	// the segment is given up, and the records pushed after it are delivered
+	if got, want := forward(t, q, func([]*TimeSeriesData) error { return nil }), []SensorData{TemperatureData(21)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+	q.Push(storeData(3))
+	if got, want := forward(t, q, func([]*TimeSeriesData) error { return nil }), []SensorData{TemperatureData(23)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestDiskQueueMaxSize(t *testing.T) {
+	recordSize := int64(recordHeaderSize + len(appendTimeSeriesData(nil, storeData(1))) + recordTrailerSize)
+
+	q, err := OpenDiskQueue(t.TempDir(), DiskQueueOptions{MaxSize: recordSize * 3 / 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// the segment being written is dropped, so the newest record fits
+	q.Push(storeData(1))
+	q.Push(storeData(2))
+	if records, _, dropped := q.Backlog(); records != 1 || dropped != 1 {
+		t.Errorf("backlog = %d, dropped = %d, want 1, 1", records, dropped)
+	}
+	if got, want := forward(t, q, func([]*TimeSeriesData) error { return nil }), []SensorData{TemperatureData(22)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestDiskQueuePermanentError(t *testing.T) {
+	q, err := OpenDiskQueue(t.TempDir(), DiskQueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	q.Push(storeData(1))
+	sent := 0
+	delivered := forward(t, q, func([]*TimeSeriesData) error {
+		sent++
+		return PermanentSinkError(errors.New("rejected"))
+	})
+
+	// given up without retry
+	if sent != 1 || len(delivered) != 0 {
+		t.Errorf("sent %d times, delivered %v", sent, delivered)
+	}
+}
+
+func TestDiskQueueFlushDropped(t *testing.T) {
+	q, err := OpenDiskQueue(t.TempDir(), DiskQueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// the sink lost a part of the batch, and the rest has been delivered
+	sink := &flushingSink{flush: make(chan error, 1)}
+	sink.flush <- PermanentSinkError(MQTTMessagesDroppedError(1))
+
+	q.Push(storeData(1))
+	q.Push(storeData(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- q.ForwardTo(ctx, 10, sink) }()
+	waitBacklog(t, q, 0)
+	cancel()
+	<-done
+
+	// given up, not written again
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if want := []SensorData{TemperatureData(21), TemperatureData(22)}; !reflect.DeepEqual(sink.written, want) {
+		t.Errorf("written %v, want %v once", sink.written, want)
+	}
+}
@@ -5,21 +5,68 @@
 package serial
 
 import (
+	"context"
+	"errors"
 	"io"
 	"log"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/jacobsa/go-serial/serial"
 )
 
+// DLPTH1C is safe for concurrent use.
+// Every request and its response are executed one by one by the command queue (./queue.go).
 type DLPTH1C struct {
 	portName string
 	vcp      io.ReadWriteCloser
+
+	// command queue
+	normal    chan *transaction
+	high      chan *transaction
+	done      chan struct{}
+	served    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	mu                 sync.Mutex
+	timeouts           map[byte]time.Duration
+	retryPolicies      map[byte]RetryPolicy
+	flushBeforeCommand bool
+	rawMode            bool
+
+	// buffers between the read functions and the output channels (see ./backpressure.go)
+	outputSize   int
+	outputPolicy OverflowPolicy
+	outputs      map[chan<- *TimeSeriesData]*outputBuffer
+
+	// clock and the time when DLPTH1C was created (see ./clock.go)
+	clock Clock
+	epoch time.Time
+
+	// counters of the serial link (see ./stats.go)
+	stats *stats
+
+	// exclusive lock of the port (see ./lock.go)
+	lock *portLock
 }
 
 func NewDLPTH1C(portName string) *DLPTH1C {
+	d, err := OpenDLPTH1C(portName)
+	if err != nil {
+		log.Fatalf("serial.Open: %v", err)
+	}
+
+	return d
+}
+
+// NewDLPTH1CFromPort uses the port which has already been opened,
+// e.g. RecordingPort or ReplayPort (./record.go).
+func NewDLPTH1CFromPort(portName string, port io.ReadWriteCloser) *DLPTH1C {
+	return newDLPTH1C(portName, port)
+}
+
+func openPort(portName string) (io.ReadWriteCloser, error) {
 	// Set up options.
 	options := serial.OpenOptions{
 		PortName:              portName,
@@ -31,609 +78,269 @@ func NewDLPTH1C(portName string) *DLPTH1C {
 	}
 
 	// Open the port.
-	port, err := serial.Open(options)
-	if err != nil {
-		log.Fatalf("serial.Open: %v", err)
-	}
-
-	return &DLPTH1C{portName: portName, vcp: port}
+	return serial.Open(options)
 }
 
-func (d *DLPTH1C) readAllAsync(out chan<- *TimeSeriesData) error {
-	const numParsingFuntion int = 10
-	const numMapWriteFunction int = 1
-	var wg sync.WaitGroup
-
-	for {
-		// Assign byte array that will be given the sensor data
-		b := make([]byte, 0)
-		// Assigns 1 byte array where bytes from the port will be stored
-		buff := make([]byte, 1)
-
-		// Assign return value
-		allData := map[byte]SensorData{}
-
-		// Get time
-		t := time.Now()
-
-		// request all value in ascii code
-		// ([]byte{'t','h','p','a','x','v','w','l','f','b',})
-		d.vcp.Write(
-			[]byte{
-				TemperatureASCIICmd,
-				HumidityASCIICmd,
-				PressureASCIICmd,
-				TiltASCIICmd,
-				VibrationXASCIICmd,
-				VibrationYASCIICmd,
-				VibrationZASCIICmd,
-				LightASCIICmd,
-				SoundASCIICmd,
-				BroadbandASCIICmd,
-			})
-
-		// Read from response
-		for {
-			n, err := d.vcp.Read(buff)
-			if err != nil {
-				if err == io.EOF || n == 0 {
-					break
-				}
-				log.Fatal(err)
-			}
-			b = append(b, buff...)
-		}
-
-		sep := strings.Split(string(b), "\n")
-		if len(sep) < 35 {
-			log.Print("Data Missing.")
-			log.Print("Try again.")
-			continue
-		}
+func newDLPTH1C(portName string, vcp io.ReadWriteCloser) *DLPTH1C {
+	d := &DLPTH1C{
+		portName:      portName,
+		vcp:           vcp,
+		normal:        make(chan *transaction),
+		high:          make(chan *transaction),
+		done:          make(chan struct{}),
+		served:        make(chan struct{}),
+		timeouts:      make(map[byte]time.Duration),
+		retryPolicies: make(map[byte]RetryPolicy),
+		outputs:       make(map[chan<- *TimeSeriesData]*outputBuffer),
+		clock:         systemClock{},
+		stats:         newStats(),
+	}
+	d.epoch = d.clock.Now()
 
-		// discard few lines at first for parsing
-		i := 0
-		for sep[i] == "" {
-			i++
-		}
+	go d.serve()
 
-		wg.Add(numParsingFuntion)
-		wg.Add(numMapWriteFunction)
-
-		// https://go.dev/doc/faq#atomic_maps
-		// Map write fuction to avoid "concurrent map writes error"
-		// "Map operations" not defined to be atomic,
-		// so it is decided that use channel to store data.
-		var mapWriteChan chan *MapWriteData = make(chan *MapWriteData)
-		go func() {
-			defer wg.Done()
-			defer close(mapWriteChan)
-
-			for i := 0; i < numParsingFuntion; i++ {
-				writeData := <-mapWriteChan
-				allData[writeData.Key] = writeData.Value
-			}
-		}()
-
-		// string parsing (temperature)
-		go func() {
-			defer wg.Done()
-
-			temperature, err := parseTemperature(sep[i])
-			if err != nil {
-				log.Print(err)
-			}
-			mapWriteChan <- &MapWriteData{TemperatureASCIICmd, temperature}
-		}()
-
-		// string parsing (humidity)
-		go func() {
-			defer wg.Done()
-
-			humidity, err := parseHumidity(sep[i+1])
-			if err != nil {
-				log.Print(err)
-			}
-			mapWriteChan <- &MapWriteData{HumidityASCIICmd, humidity}
-		}()
-
-		// string parsing (pressure)
-		go func() {
-			defer wg.Done()
-
-			pressure, err := parsePressure(sep[i+2])
-			if err != nil {
-				log.Print(err)
-			}
-			mapWriteChan <- &MapWriteData{PressureASCIICmd, pressure}
-		}()
-
-		// string parsing (tilt)
-		go func() {
-			defer wg.Done()
-
-			tilt, err := parseTilt(sep[i+3])
-			if err != nil {
-				log.Print(err)
-			}
-			mapWriteChan <- &MapWriteData{TiltASCIICmd, tilt}
-		}()
-
-		// string parsing (vibration X, Y, Z in order)
-		go func() {
-			defer wg.Done()
-
-			vibrationX, err := parseVibration(VibrationXASCIICmd, strings.Join(sep[i+4:i+11], "\n"))
-			if err != nil {
-				log.Print(err)
-			}
-
-			mapWriteChan <- &MapWriteData{VibrationXASCIICmd, vibrationX}
-		}()
-
-		go func() {
-			defer wg.Done()
-
-			vibrationY, err := parseVibration(VibrationYASCIICmd, strings.Join(sep[i+11:i+18], "\n"))
-			if err != nil {
-				log.Print(err)
-			}
-
-			mapWriteChan <- &MapWriteData{VibrationYASCIICmd, vibrationY}
-		}()
-
-		go func() {
-			defer wg.Done()
-
-			vibrationZ, err := parseVibration(VibrationZASCIICmd, strings.Join(sep[i+18:i+25], "\n"))
-			if err != nil {
-				log.Print(err)
-			}
-
-			mapWriteChan <- &MapWriteData{VibrationZASCIICmd, vibrationZ}
-		}()
-
-		// string parsing (light)
-		go func() {
-			defer wg.Done()
-
-			light, err := parseLight(sep[i+25])
-			if err != nil {
-				log.Print(err)
-			}
-
-			mapWriteChan <- &MapWriteData{LightASCIICmd, light}
-		}()
-
-		// string parsing (sound)
-		go func() {
-			defer wg.Done()
-
-			sound, err := parseSound(strings.Join(sep[i+26:i+33], "\n"))
-			if err != nil {
-				log.Print(err)
-			}
-
-			mapWriteChan <- &MapWriteData{SoundASCIICmd, sound}
-		}()
-
-		// string parsing (broadband)
-		go func() {
-			defer wg.Done()
-
-			broadband, err := parseBroadband(sep[i+33])
-			if err != nil {
-				log.Print(err)
-			}
-
-			mapWriteChan <- &MapWriteData{BroadbandASCIICmd, broadband}
-		}()
-
-		wg.Wait()
-		out <- &(TimeSeriesData{Time: t, Data: allData})
-	}
+	return d
 }
 
-func (d *DLPTH1C) readTemperatureAsync(out chan<- *TimeSeriesData) error {
+// readAllAsync requests every sensor one by one (in order of SensorASCIICmds),
+// so each response is parsed separately and has its own timing.
+func (d *DLPTH1C) readAllAsync(out chan<- *TimeSeriesData) error {
 	for {
-		// Assign byte array that will be given the sensor data
-		b := make([]byte, 0)
-		// Assigns 1 byte array where bytes from the port will be stored
-		buff := make([]byte, 1)
-
-		// request temperature value in ascii code
-		d.vcp.Write([]byte{TemperatureASCIICmd})
-		t := time.Now()
-
-		// Read from response
-		for {
-			n, err := d.vcp.Read(buff)
-			if err != nil {
-				if err == io.EOF || n == 0 {
-					break
-				}
-				log.Fatal(err)
-			}
-
-			b = append(b, buff...)
-		}
-
-		// string parsing
-		temperature, err := parseTemperature(string(b))
+		result, err := d.readAll()
 		if err != nil {
-			log.Fatal(err)
 			return err
 		}
 
-		// it goes out to the channel
-		result := new(TimeSeriesData)
-		result.Time = t
-		result.Data = make(map[byte]SensorData)
-		result.Data[TemperatureASCIICmd] = temperature
-		out <- result
+		if err := d.emit(context.Background(), out, result); err != nil {
+			return err
+		}
 	}
 }
 
-func (d *DLPTH1C) readHumidityAsync(out chan<- *TimeSeriesData) error {
-	for {
-		// Assign byte array that will be given the sensor data
-		b := make([]byte, 0)
-		// Assigns 1 byte array where bytes from the port will be stored
-		buff := make([]byte, 1)
-
-		// request temperature value in ascii code
-		d.vcp.Write([]byte{HumidityASCIICmd})
-		t := time.Now()
-
-		// Read from response
-		for {
-			n, err := d.vcp.Read(buff)
-			if err != nil {
-				if err == io.EOF || n == 0 {
-					break
-				}
-				log.Fatal(err)
-			}
-
-			b = append(b, buff...)
-		}
-
-		// string parsing
-		humidity, err := parseHumidity(string(b))
-		if err != nil {
-			return err
-		}
+// readAll requests every sensor once.
+func (d *DLPTH1C) readAll() (*TimeSeriesData, error) {
+	// Assign return value
+	result := &TimeSeriesData{
+		Data:   make(map[byte]SensorData, len(SensorASCIICmds)),
+		Timing: make(map[byte]*SampleTiming, len(SensorASCIICmds)),
+	}
 
-		// it goes out to the channel
-		result := new(TimeSeriesData)
-		result.Time = t
-		result.Data = make(map[byte]SensorData)
-		result.Data[HumidityASCIICmd] = humidity
-		out <- result
+	if d.rawModeEnabled() {
+		result.Raw = make(map[byte][]byte, len(SensorASCIICmds))
 	}
-}
 
-func (d *DLPTH1C) readPressureAsync(out chan<- *TimeSeriesData) error {
-	for {
-		// Assign byte array that will be given the sensor data
-		b := make([]byte, 0)
-		// Assigns 1 byte array where bytes from the port will be stored
-		buff := make([]byte, 1)
-
-		// request temperature value in ascii code
-		d.vcp.Write([]byte{PressureASCIICmd})
-		t := time.Now()
-
-		// Read from response
-		for {
-			n, err := d.vcp.Read(buff)
-			if err != nil {
-				if err == io.EOF || n == 0 {
-					break
-				}
-				log.Fatal(err)
-			}
-
-			b = append(b, buff...)
+	for _, cmd := range SensorASCIICmds {
+		data, timing, raw, err := d.sample(cmd)
+		if timing == nil || errors.Is(err, ClosedError()) {
+			// failed to get the response at all, or closed while retrying
+			return nil, err
 		}
-
-		// string parsing
-		pressure, err := parsePressure(string(b))
 		if err != nil {
-			return err
+			// parse error, the data has the error code (or it is left out, see parseResponse)
+			log.Print(err)
 		}
 
-		// it goes out to the channel
-		result := new(TimeSeriesData)
-		result.Time = t
-		result.Data = make(map[byte]SensorData)
-		result.Data[PressureASCIICmd] = pressure
-		out <- result
+		if data != nil {
+			result.Data[cmd] = data
+		}
+		result.Timing[cmd] = timing
+		if result.Raw != nil {
+			result.Raw[cmd] = raw
+		}
 	}
+
+	result.Time = result.Timing[SensorASCIICmds[0]].Sent
+	return result, nil
 }
 
-func (d *DLPTH1C) readTiltAsync(out chan<- *TimeSeriesData) error {
-	for {
-		// Assign byte array that will be given the sensor data
-		b := make([]byte, 0)
-		// Assigns 1 byte array where bytes from the port will be stored
-		buff := make([]byte, 1)
-
-		// request temperature value in ascii code
-		d.vcp.Write([]byte{TiltASCIICmd})
-		t := time.Now()
-
-		// Read from response
-		for {
-			n, err := d.vcp.Read(buff)
-			if err != nil {
-				if err == io.EOF || n == 0 {
-					break
-				}
-				log.Fatal(err)
-			}
-
-			b = append(b, buff...)
+// sample requests only one kind of data and parses the response.
+// It is retried by the retry policy of the command (see ./retry.go).
+// If the response is received but couldn't be parsed,
+// it returns the data that parsing function returned together with the error.
+// timing is nil only if the response was not received at all.
+// raw is the exact response of the last attempt.
+func (d *DLPTH1C) sample(cmd byte) (data SensorData, timing *SampleTiming, raw []byte, err error) {
+	policy := d.retryPolicyOf(cmd)
+
+	for attempt := 1; ; attempt++ {
+		var resynced bool
+		data, timing, raw, resynced, err = d.sampleOnce(cmd)
+		if err == nil || errors.Is(err, ClosedError()) || attempt >= policy.Attempts {
+			return data, timing, raw, err
 		}
 
-		// string parsing
-		tilt, err := parseTilt(string(b))
-		if err != nil {
-			return err
+		d.stats.recordRetry()
+		if err := policy.wait(d, resynced); err != nil {
+			return data, timing, raw, err
 		}
-
-		// it goes out to the channel
-		result := new(TimeSeriesData)
-		result.Time = t
-		result.Data = make(map[byte]SensorData)
-		result.Data[TiltASCIICmd] = tilt
-		out <- result
 	}
 }
 
-// this funcion requires certain command for specify axis
-// please check ./cmd.go
-func (d *DLPTH1C) readVibrationAsync(cmd byte, out chan<- *TimeSeriesData) error {
-	for {
-		// request vibration value in ascii code
-		// the command must be the one of 3 axis command
-		if cmd != VibrationXASCIICmd && cmd != VibrationYASCIICmd && cmd != VibrationZASCIICmd {
-			return InvalidCommandError()
+// sampleOnce requests the data once. resynced is true if the input has been
+// discarded by resync after the failure, so it doesn't have to be flushed again.
+func (d *DLPTH1C) sampleOnce(cmd byte) (data SensorData, timing *SampleTiming, raw []byte, resynced bool, err error) {
+	// request the value in ascii code
+	response := d.transact([]byte{cmd}, NormalPriority, false)
+	if response.err != nil {
+		if response.err == TimeoutError() {
+			d.resyncAfter(cmd, response.err)
+			resynced = true
 		}
+		return nil, nil, response.b, resynced, response.err
+	}
 
-		// Assign byte array that will be given the sensor data
-		b := make([]byte, 0)
-		// Assigns 1 byte array where bytes from the port will be stored
-		buff := make([]byte, 1)
-
-		// request temperature value in ascii code
-		d.vcp.Write([]byte{cmd})
-		t := time.Now()
-
-		// Read from response
-		for {
-			n, err := d.vcp.Read(buff)
-			if err != nil {
-				if err == io.EOF || n == 0 {
-					break
-				}
-				log.Fatal(err)
-			}
-
-			b = append(b, buff...)
-		}
+	timing = d.timing(response)
 
-		// string parsing
-		vibration, err := parseVibration(cmd, string(b))
-		if err != nil {
-			return err
-		}
-
-		// it goes out to the channel
+	// string parsing
+	data, err = parseResponse(cmd, string(response.b))
+	d.stats.recordParse(cmd, err)
 
-		result := new(TimeSeriesData)
-		result.Time = t
-		result.Data = make(map[byte]SensorData)
-		result.Data[cmd] = vibration
-		out <- result
+	// The response could be the leftover of the previous one,
+	// or the rest of it could be read as the next one.
+	if err != nil {
+		d.resyncAfter(cmd, err)
+		resynced = true
 	}
-}
 
-func (d *DLPTH1C) readLightAsync(out chan<- *TimeSeriesData) error {
-	for {
-		// Assign byte array that will be given the sensor data
-		b := make([]byte, 0)
-		// Assigns 1 byte array where bytes from the port will be stored
-		buff := make([]byte, 1)
-
-		// request temperature value in ascii code
-		d.vcp.Write([]byte{LightASCIICmd})
-		t := time.Now()
-
-		// Read from response
-		for {
-			n, err := d.vcp.Read(buff)
-			if err != nil {
-				if err == io.EOF || n == 0 {
-					break
-				}
-				log.Fatal(err)
-			}
-
-			b = append(b, buff...)
-		}
+	return data, timing, response.b, resynced, err
+}
 
-		// string parsing
-		light, err := parseLight(string(b))
-		if err != nil {
-			return err
-		}
+// readSensor requests only one kind of data.
+// The other read functions (and the scheduler) are built on top of this function.
+// Like readAll, a response which couldn't be parsed is returned with the error code
+// (or without the data), so its raw bytes are kept in raw mode.
+func (d *DLPTH1C) readSensor(cmd byte) (*TimeSeriesData, error) {
+	data, timing, raw, err := d.sample(cmd)
+	if timing == nil || errors.Is(err, ClosedError()) {
+		// failed to get the response at all, or closed while retrying
+		return nil, err
+	}
+	if err != nil {
+		log.Print(err)
+	}
 
-		// it goes out to the channel
-		result := new(TimeSeriesData)
-		result.Time = t
-		result.Data = make(map[byte]SensorData)
-		result.Data[LightASCIICmd] = light
-		out <- result
+	result := new(TimeSeriesData)
+	result.Time = timing.Sent
+	result.Data = make(map[byte]SensorData, 1)
+	if data != nil {
+		result.Data[cmd] = data
 	}
+	result.Timing = map[byte]*SampleTiming{cmd: timing}
+	if d.rawModeEnabled() {
+		result.Raw = map[byte][]byte{cmd: raw}
+	}
+	return result, nil
 }
 
-func (d *DLPTH1C) readSoundAsync(out chan<- *TimeSeriesData) error {
+// readSensorAsync keeps requesting one kind of data until an error occurs.
+func (d *DLPTH1C) readSensorAsync(cmd byte, out chan<- *TimeSeriesData) error {
 	for {
-		// Assign byte array that will be given the sensor data
-		b := make([]byte, 0)
-		// Assigns 1 byte array where bytes from the port will be stored
-		buff := make([]byte, 1)
-
-		// request temperature value in ascii code
-		d.vcp.Write([]byte{SoundASCIICmd})
-		t := time.Now()
-
-		// Read from response
-		for {
-			n, err := d.vcp.Read(buff)
-			if err != nil {
-				if err == io.EOF || n == 0 {
-					break
-				}
-				log.Fatal(err)
-			}
-
-			b = append(b, buff...)
-		}
-
-		// string parsing
-		sound, err := parseSound(string(b))
+		result, err := d.readSensor(cmd)
 		if err != nil {
 			return err
 		}
 
 		// it goes out to the channel
-		result := new(TimeSeriesData)
-		result.Time = t
-		result.Data = make(map[byte]SensorData)
-		result.Data[SoundASCIICmd] = sound
-		out <- result
+		if err := d.emit(context.Background(), out, result); err != nil {
+			return err
+		}
 	}
 }
 
-func (d *DLPTH1C) readBroadbandAsync(out chan<- *TimeSeriesData) error {
-	for {
-		// Assign byte array that will be given the sensor data
-		b := make([]byte, 0)
-		// Assigns 1 byte array where bytes from the port will be stored
-		buff := make([]byte, 1)
-
-		// request temperature value in ascii code
-		d.vcp.Write([]byte{BroadbandASCIICmd})
-		t := time.Now()
-
-		// Read from response
-		for {
-			n, err := d.vcp.Read(buff)
-			if err != nil {
-				if err == io.EOF || n == 0 {
-					break
-				}
-				log.Fatal(err)
-			}
-
-			b = append(b, buff...)
-		}
-
-		// string parsing
-		broadband, err := parseBroadband(string(b))
-		if err != nil {
-			return err
-		}
+func (d *DLPTH1C) readTemperatureAsync(out chan<- *TimeSeriesData) error {
+	return d.readSensorAsync(TemperatureASCIICmd, out)
+}
 
-		// it goes out to the channel
-		result := new(TimeSeriesData)
-		result.Time = t
-		result.Data = make(map[byte]SensorData)
-		result.Data[BroadbandASCIICmd] = broadband
-		out <- result
-	}
+func (d *DLPTH1C) readHumidityAsync(out chan<- *TimeSeriesData) error {
+	return d.readSensorAsync(HumidityASCIICmd, out)
 }
 
-func (d *DLPTH1C) set2G() error {
-	d.vcp.Write([]byte{Set2GASCIICmd})
+func (d *DLPTH1C) readPressureAsync(out chan<- *TimeSeriesData) error {
+	return d.readSensorAsync(PressureASCIICmd, out)
+}
 
-	// Assigns 1 byte array where bytes from the port will be stored
-	buff := make([]byte, 1)
+func (d *DLPTH1C) readTiltAsync(out chan<- *TimeSeriesData) error {
+	return d.readSensorAsync(TiltASCIICmd, out)
+}
 
-	// Clear buffer
-	for {
-		n, err := d.vcp.Read(buff)
-		if err != nil {
-			if err == io.EOF || n == 0 {
-				break
-			}
-			log.Fatal(err)
-		}
+// this funcion requires certain command for specify axis
+// please check ./cmd.go
+func (d *DLPTH1C) readVibrationAsync(cmd byte, out chan<- *TimeSeriesData) error {
+	// request vibration value in ascii code
+	// the command must be the one of 3 axis command
+	if cmd != VibrationXASCIICmd && cmd != VibrationYASCIICmd && cmd != VibrationZASCIICmd {
+		return InvalidCommandError()
 	}
 
-	return nil
+	return d.readSensorAsync(cmd, out)
 }
 
-func (d *DLPTH1C) set4G() error {
-	d.vcp.Write([]byte{Set4GASCIICmd})
+func (d *DLPTH1C) readLightAsync(out chan<- *TimeSeriesData) error {
+	return d.readSensorAsync(LightASCIICmd, out)
+}
 
-	// Assigns 1 byte array where bytes from the port will be stored
-	buff := make([]byte, 1)
+func (d *DLPTH1C) readSoundAsync(out chan<- *TimeSeriesData) error {
+	return d.readSensorAsync(SoundASCIICmd, out)
+}
 
-	// Clear buffer
-	for {
-		n, err := d.vcp.Read(buff)
-		if err != nil {
-			if err == io.EOF || n == 0 {
-				break
-			}
-			log.Fatal(err)
-		}
+func (d *DLPTH1C) readBroadbandAsync(out chan<- *TimeSeriesData) error {
+	return d.readSensorAsync(BroadbandASCIICmd, out)
+}
+
+// Read requests one kind of data once (see ./cmd.go for the commands).
+// It returns an error only if the response was not received,
+// and the data which couldn't be parsed has the error code (see parseResponse).
+func (d *DLPTH1C) Read(cmd byte) (*TimeSeriesData, error) {
+	if SensorName(cmd) == "" {
+		return nil, InvalidCommandError()
 	}
 
-	return nil
+	return d.readSensor(cmd)
 }
 
-func (d *DLPTH1C) set8G() error {
-	d.vcp.Write([]byte{Set8GASCIICmd})
-
-	// Assigns 1 byte array where bytes from the port will be stored
-	buff := make([]byte, 1)
+// ReadAll requests every sensor once.
+func (d *DLPTH1C) ReadAll() (*TimeSeriesData, error) {
+	return d.readAll()
+}
 
-	// Clear buffer
-	for {
-		n, err := d.vcp.Read(buff)
-		if err != nil {
-			if err == io.EOF || n == 0 {
-				break
-			}
-			log.Fatal(err)
-		}
+// SetRange changes the range of the accelerometer (2, 4, 8 or 16 G).
+func (d *DLPTH1C) SetRange(g int) error {
+	switch g {
+	case 2:
+		return d.set2G()
+	case 4:
+		return d.set4G()
+	case 8:
+		return d.set8G()
+	case 16:
+		return d.set16G()
 	}
 
-	return nil
+	return InvalidCommandError()
 }
 
-func (d *DLPTH1C) set16G() error {
-	d.vcp.Write([]byte{Set16GASCIICmd})
+func (d *DLPTH1C) set2G() error {
+	// The response is discarded (it only clears the buffer).
+	_, err := d.Transact([]byte{Set2GASCIICmd}, HighPriority)
+	return err
+}
 
-	// Assigns 1 byte array where bytes from the port will be stored
-	buff := make([]byte, 1)
+func (d *DLPTH1C) set4G() error {
+	// The response is discarded (it only clears the buffer).
+	_, err := d.Transact([]byte{Set4GASCIICmd}, HighPriority)
+	return err
+}
 
-	// Clear buffer
-	for {
-		n, err := d.vcp.Read(buff)
-		if err != nil {
-			if err == io.EOF || n == 0 {
-				break
-			}
-			log.Fatal(err)
-		}
-	}
+func (d *DLPTH1C) set8G() error {
+	// The response is discarded (it only clears the buffer).
+	_, err := d.Transact([]byte{Set8GASCIICmd}, HighPriority)
+	return err
+}
 
-	return nil
+func (d *DLPTH1C) set16G() error {
+	// The response is discarded (it only clears the buffer).
+	_, err := d.Transact([]byte{Set16GASCIICmd}, HighPriority)
+	return err
 }
 
 func bitwiseOR2Bytes(b []byte) (uint16, error) {
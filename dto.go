@@ -3,12 +3,14 @@ package serial
 
 import (
 	"fmt"
+	"io"
 	"time"
 )
 
 // All response data from sensor has to follow(implement) this interface.
 type SensorData interface {
-	print()
+	// print writes the data in a human readable form (e.g. to os.Stdout)
+	print(w io.Writer)
 }
 
 // Define new data type as itself to implement interface
@@ -19,62 +21,79 @@ type LightData int8
 type BroadbandData float64
 
 type TimeSeriesData struct {
+	// Time when the first request was sent
 	Time time.Time
+	// The data which couldn't be parsed is left out, or has ParseErrorCodeDLPTH1C (see parseResponse).
 	Data map[byte]SensorData
+	// Timing of each request, it has the keys of Data and also of the responses that couldn't be parsed.
+	Timing map[byte]*SampleTiming
+	// Exact bytes received for each request (only in raw mode, see SetRawMode).
+	// They can be parsed again by ParseRaw.
+	Raw map[byte][]byte
 }
 
-// Define type for multiple threads to use channel to access map data type
-type MapWriteData struct {
-	Key   byte
-	Value SensorData
+// Timing of one request and its response.
+// Wall clock times are for recording, and monotonic times are for measuring
+// (they are durations since the DLPTH1C was created, so they are not affected by wall clock changes).
+type SampleTiming struct {
+	Sent         time.Time     `json:"sent"`
+	Received     time.Time     `json:"received"`
+	SentMono     time.Duration `json:"sent_mono"`
+	ReceivedMono time.Duration `json:"received_mono"`
+}
+
+// Latency returns how long it took from the request to the end of the response.
+func (timing *SampleTiming) Latency() time.Duration {
+	return timing.ReceivedMono - timing.SentMono
 }
 
 type TiltData struct {
-	XAxis int64
-	YAxis int64
-	ZAxis int64
+	XAxis int64 `json:"x"`
+	YAxis int64 `json:"y"`
+	ZAxis int64 `json:"z"`
 }
 
 // It could be VibrationX, VibrationY, and also VibratoinY
+// Axis is left out of JSON, since it is the key of the data (see ./json.go)
 type VibrationData struct {
-	Axis byte
-	Peak [6]int64
-	Amp  [6]float64
+	Axis byte       `json:"-"`
+	Peak [6]int64   `json:"peak"`
+	Amp  [6]float64 `json:"amp"`
 }
 
 type SoundData struct {
-	Peak [6]int64
-	Amp  [6]float64
+	Peak [6]int64   `json:"peak"`
+	Amp  [6]float64 `json:"amp"`
 }
 
-func (temperatureData TemperatureData) print() {
-	fmt.Printf("Temperature: %+v(℃)\n", temperatureData)
+func (temperatureData TemperatureData) print(w io.Writer) {
+	fmt.Fprintf(w, "Temperature: %+v(℃)\n", temperatureData)
 }
 
-func (humidity HumidityData) print() {
-	fmt.Printf("Humidity: %+v(%%)\n", humidity)
+func (humidity HumidityData) print(w io.Writer) {
+	fmt.Fprintf(w, "Humidity: %+v(%%)\n", humidity)
 }
 
-func (pressure PressureData) print() {
-	fmt.Printf("Pressure: %+v(hPa)\n", pressure)
+func (pressure PressureData) print(w io.Writer) {
+	fmt.Fprintf(w, "Pressure: %+v(hPa)\n", pressure)
 }
 
-func (tiltData *TiltData) print() {
+func (tiltData *TiltData) print(w io.Writer) {
 	if tiltData == nil {
-		fmt.Printf("tiltData is nil\n")
+		fmt.Fprintf(w, "tiltData is nil\n")
 		return
 	}
 
-	fmt.Println("Tilt data below")
+	fmt.Fprintln(w, "Tilt data below")
 
-	fmt.Printf("XAxis: %+v\n", tiltData.XAxis)
-	fmt.Printf("YAxis: %+v\n", tiltData.YAxis)
-	fmt.Printf("ZAxis: %+v\n", tiltData.ZAxis)
+	fmt.Fprintf(w, "XAxis: %+v\n", tiltData.XAxis)
+	fmt.Fprintf(w, "YAxis: %+v\n", tiltData.YAxis)
+	fmt.Fprintf(w, "ZAxis: %+v\n", tiltData.ZAxis)
 }
 
-func (vibrationData *VibrationData) print() {
+func (vibrationData *VibrationData) print(w io.Writer) {
 	if vibrationData == nil {
-		fmt.Printf("vibrationData is nil\n")
+		fmt.Fprintf(w, "vibrationData is nil\n")
 		return
 	}
 
@@ -88,40 +107,40 @@ func (vibrationData *VibrationData) print() {
 		axis = "Z"
 	}
 
-	fmt.Printf("Vibration%+v data below\n", axis)
+	fmt.Fprintf(w, "Vibration%+v data below\n", axis)
 
-	fmt.Printf("Fund%+v: %+v(Hz)\t", axis, vibrationData.Peak[0])
-	fmt.Printf("Amp%+v: %+v\n", axis, vibrationData.Amp[0])
+	fmt.Fprintf(w, "Fund%+v: %+v(Hz)\t", axis, vibrationData.Peak[0])
+	fmt.Fprintf(w, "Amp%+v: %+v\n", axis, vibrationData.Amp[0])
 
 	for i := 1; i < 6; i++ {
-		fmt.Printf("Peak%+v%d: %+v(Hz)\t", axis, i+1, vibrationData.Peak[i])
-		fmt.Printf("Amp%+v: %+v\n", axis, vibrationData.Amp[i])
+		fmt.Fprintf(w, "Peak%+v%d: %+v(Hz)\t", axis, i+1, vibrationData.Peak[i])
+		fmt.Fprintf(w, "Amp%+v: %+v\n", axis, vibrationData.Amp[i])
 	}
 }
 
-func (lightData LightData) print() {
-	fmt.Printf("Light: %+v\n", lightData)
+func (lightData LightData) print(w io.Writer) {
+	fmt.Fprintf(w, "Light: %+v\n", lightData)
 }
 
-func (soundData *SoundData) print() {
+func (soundData *SoundData) print(w io.Writer) {
 	if soundData == nil {
-		fmt.Printf("soundData is nil\n")
+		fmt.Fprintf(w, "soundData is nil\n")
 		return
 	}
 
-	fmt.Println("Sound data below")
+	fmt.Fprintln(w, "Sound data below")
 
-	fmt.Printf("Fund: %+v(Hz)\t", soundData.Peak[0])
-	fmt.Printf("Amp: %+v\n", soundData.Amp[0])
+	fmt.Fprintf(w, "Fund: %+v(Hz)\t", soundData.Peak[0])
+	fmt.Fprintf(w, "Amp: %+v\n", soundData.Amp[0])
 
 	for i := 1; i < 6; i++ {
-		fmt.Printf("Peak%d: %+v(Hz)\t", i+1, soundData.Peak[i])
-		fmt.Printf("Amp: %+v\n", soundData.Amp[i])
+		fmt.Fprintf(w, "Peak%d: %+v(Hz)\t", i+1, soundData.Peak[i])
+		fmt.Fprintf(w, "Amp: %+v\n", soundData.Amp[i])
 	}
 }
 
-func (broadbanddata BroadbandData) print() {
-	fmt.Printf("Broadband: %+v\n", broadbanddata)
+func (broadbanddata BroadbandData) print(w io.Writer) {
+	fmt.Fprintf(w, "Broadband: %+v\n", broadbanddata)
 }
 
 // do not use anymore since https://github.com/w00cheol/serial/commit/15d0f2690c37e121818a7f6ab7a93cb38d895186
@@ -1,7 +1,10 @@
 // Define custom error in this file
 package serial
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 func InvalidByteLengthError() error {
 	return errors.New("Invalid byte length error")
@@ -14,3 +17,149 @@ func DataMissingError() error {
 func InvalidCommandError() error {
 	return errors.New("Invalid command error")
 }
+
+var (
+	errClosed  = errors.New("DLPTH1C closed error")
+	errTimeout = errors.New("Command timeout error")
+)
+
+// It can be compared with errors.Is
+func ClosedError() error {
+	return errClosed
+}
+
+// It can be compared with errors.Is
+func TimeoutError() error {
+	return errTimeout
+}
+
+func ResyncError() error {
+	return errors.New("Resync error")
+}
+
+func ReplayFinishedError() error {
+	return errors.New("Replay finished error")
+}
+
+func ReplayMismatchError() error {
+	return errors.New("Replay mismatch error")
+}
+
+// It is the error recorded in the session
+func ReplayedError(recorded string) error {
+	return errors.New(recorded)
+}
+
+func MQTTProtocolError() error {
+	return errors.New("MQTT protocol error")
+}
+
+// The return code of CONNACK
+func MQTTConnectionRefusedError(code byte) error {
+	return fmt.Errorf("MQTT connection refused error (return code %d)", code)
+}
+
+// Only QoS 0 and 1 are supported
+func MQTTInvalidQoSError(qos byte) error {
+	return fmt.Errorf("MQTT invalid QoS error: %d is not 0 or 1", qos)
+}
+
+// The number of the buffered messages which were never published
+func MQTTMessagesDroppedError(dropped int) error {
+	return fmt.Errorf("MQTT messages dropped error: %d message(s) were not published", dropped)
+}
+
+// The status code and the body of the response
+func InfluxWriteError(statusCode int, body string) error {
+	return fmt.Errorf("Influx write error (status %d): %s", statusCode, body)
+}
+
+// permanentSinkError is a failure of a sink which retrying can't fix.
+type permanentSinkError struct {
+	err error
+}
+
+func (e *permanentSinkError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentSinkError) Unwrap() error {
+	return e.err
+}
+
+// The error of a sink which is given up by the pipeline without retry (e.g. a batch rejected by 400).
+// It can be compared with errors.Is as the wrapped error
+func PermanentSinkError(err error) error {
+	return &permanentSinkError{err: err}
+}
+
+var errCorruptedRecord = errors.New("Corrupted record error")
+
+// It can be compared with errors.Is
+func CorruptedRecordError() error {
+	return errCorruptedRecord
+}
+
+// The directory of the socket is unsafe (./daemon.go)
+func SocketDirError(dir string, reason string) error {
+	return fmt.Errorf("Socket directory error (%s: %s)", dir, reason)
+}
+
+// The error message sent by the daemon (./daemon.go).
+// ClosedError and TimeoutError are restored, so they can be compared with errors.Is
+func DaemonError(message string) error {
+	switch message {
+	case errClosed.Error():
+		return errClosed
+	case errTimeout.Error():
+		return errTimeout
+	}
+	return errors.New(message)
+}
+
+func APINotFoundError() error {
+	return errors.New("API not found error")
+}
+
+func APIMethodNotAllowedError() error {
+	return errors.New("API method not allowed error")
+}
+
+func APIStreamingUnsupportedError() error {
+	return errors.New("API streaming unsupported error")
+}
+
+func APIUnauthorizedError() error {
+	return errors.New("API unauthorized error")
+}
+
+func APIForbiddenError() error {
+	return errors.New("API forbidden error")
+}
+
+func InvalidCertificateError() error {
+	return errors.New("Invalid certificate error")
+}
+
+// What is missing or wrong in the TLS and token settings of the API
+func APISecurityError(message string) error {
+	return fmt.Errorf("API security error: %s", message)
+}
+
+// The PID of the process which has locked the port (0 if it is unknown)
+func PortLockedError(portName string, pid int) error {
+	if pid == 0 {
+		return fmt.Errorf("Port locked error: %s is used by another process", portName)
+	}
+	return fmt.Errorf("Port locked error: %s is used by the process %d", portName, pid)
+}
+
+// The field (or the file) of the config and what is wrong with it
+func ConfigError(field string, message string) error {
+	return fmt.Errorf("Config error: %s: %s", field, message)
+}
+
+// The serial number and the number of the ports found by it
+func DeviceNotFoundError(serialNumber string, found int) error {
+	return fmt.Errorf("Device not found error: %d ports match %q", found, serialNumber)
+}
@@ -0,0 +1,239 @@
+// Define in-process pub/sub hub which shares one device stream with several consumers in this file
+package serial
+
+import (
+	"context"
+	"sync"
+)
+
+// Default buffer size of a subscription
+const DefaultSubscriptionBufferSize int = 100
+
+// Hub delivers every data published to it (usually from one DLPTH1C)
+// to each subscription, so several consumers can share one serial port.
+// Every subscription has its own buffer, so a slow consumer doesn't delay the others
+// unless it subscribed with BlockOnOverflow.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// SubscribeOptions filters the data delivered to the subscription.
+type SubscribeOptions struct {
+	// Sensors (commands) to be delivered. nil means every sensor.
+	Sensors []byte
+	// Decimation delivers one of every Decimation samples of each sensor. 0 or 1 means every sample.
+	Decimation int
+	// BufferSize and Policy of the buffer of this subscription (see ./backpressure.go).
+	// 0 means DefaultSubscriptionBufferSize.
+	BufferSize int
+	Policy     OverflowPolicy
+}
+
+// Subscription receives the data from C until it is unsubscribed or the hub is closed.
+type Subscription struct {
+	C <-chan *TimeSeriesData
+
+	hub    *Hub
+	opts   SubscribeOptions
+	buffer *outputBuffer
+	done   chan struct{}
+	once   sync.Once
+
+	mu      sync.Mutex
+	counts  map[byte]int
+	dropped map[byte]uint64
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe adds a subscription. The channel is closed after the data left in the buffer
+// when the hub is closed, and right away when it is unsubscribed.
+func (h *Hub) Subscribe(opts SubscribeOptions) *Subscription {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultSubscriptionBufferSize
+	}
+	if opts.Decimation < 1 {
+		opts.Decimation = 1
+	}
+
+	c := make(chan *TimeSeriesData)
+	sub := &Subscription{
+		C:       c,
+		hub:     h,
+		opts:    opts,
+		buffer:  newOutputBuffer(),
+		done:    make(chan struct{}),
+		counts:  make(map[byte]int),
+		dropped: make(map[byte]uint64),
+	}
+
+	go func() {
+		sub.buffer.forward(c, sub.done)
+		close(c)
+	}()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		sub.buffer.close()
+		return sub
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish delivers the data to every subscription.
+// It waits only for the subscriptions with BlockOnOverflow whose buffer is full.
+func (h *Hub) Publish(timeSeriesData *TimeSeriesData) {
+	h.mu.Lock()
+	subs := make([]*Subscription, 0, len(h.subs))
+	for sub := range h.subs {
+		subs = append(subs, sub)
+	}
+	h.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.deliver(timeSeriesData)
+	}
+}
+
+// Run publishes every data from the channel until it is closed or the context is done,
+// and closes the hub.
+func (h *Hub) Run(ctx context.Context, in <-chan *TimeSeriesData) error {
+	defer h.Close()
+
+	for {
+		select {
+		case timeSeriesData, ok := <-in:
+			if !ok {
+				return nil
+			}
+			h.Publish(timeSeriesData)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close closes the channel of every subscription after the data left in its buffer.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		sub.buffer.close()
+		delete(h.subs, sub)
+	}
+}
+
+// Unsubscribe stops the subscription and closes its channel.
+// The data left in its buffer are discarded.
+func (s *Subscription) Unsubscribe() {
+	s.hub.mu.Lock()
+	delete(s.hub.subs, s)
+	s.hub.mu.Unlock()
+
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+// Dropped returns the samples of each sensor lost by the policy of the buffer.
+func (s *Subscription) Dropped() map[byte]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := make(map[byte]uint64, len(s.dropped))
+	for cmd, n := range s.dropped {
+		dropped[cmd] = n
+	}
+	return dropped
+}
+
+func (s *Subscription) deliver(timeSeriesData *TimeSeriesData) {
+	filtered := s.filter(timeSeriesData)
+	if filtered == nil {
+		return
+	}
+
+	for !s.buffer.push(filtered, s.opts.BufferSize, s.opts.Policy, s.recordDrop) {
+		// BlockOnOverflow with full buffer
+		select {
+		case <-s.buffer.taken:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// filter returns the copy of the data which has only the sensors to be delivered,
+// or nil if nothing is left. The copy is owned by the subscription,
+// since CoalesceLatest overwrites the data in the buffer.
+func (s *Subscription) filter(timeSeriesData *TimeSeriesData) *TimeSeriesData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &TimeSeriesData{
+		Time: timeSeriesData.Time,
+		Data: make(map[byte]SensorData, len(timeSeriesData.Data)),
+	}
+
+	for cmd, data := range timeSeriesData.Data {
+		if !s.wants(cmd) {
+			continue
+		}
+
+		// decimation is counted by each sensor
+		s.counts[cmd]++
+		if (s.counts[cmd]-1)%s.opts.Decimation != 0 {
+			continue
+		}
+
+		result.Data[cmd] = data
+		if timing, exist := timeSeriesData.Timing[cmd]; exist {
+			if result.Timing == nil {
+				result.Timing = make(map[byte]*SampleTiming)
+			}
+			result.Timing[cmd] = timing
+		}
+		if raw, exist := timeSeriesData.Raw[cmd]; exist {
+			if result.Raw == nil {
+				result.Raw = make(map[byte][]byte)
+			}
+			result.Raw[cmd] = raw
+		}
+	}
+
+	if len(result.Data) == 0 {
+		return nil
+	}
+	return result
+}
+
+func (s *Subscription) wants(cmd byte) bool {
+	if s.opts.Sensors == nil {
+		return true
+	}
+
+	for _, sensor := range s.opts.Sensors {
+		if sensor == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscription) recordDrop(timeSeriesData *TimeSeriesData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for cmd := range timeSeriesData.Data {
+		s.dropped[cmd]++
+	}
+}
@@ -0,0 +1,86 @@
+package serial
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func receiveAll(t *testing.T, c <-chan *TimeSeriesData) []map[byte]SensorData {
+	t.Helper()
+
+	received := []map[byte]SensorData{}
+	for {
+		select {
+		case timeSeriesData, ok := <-c:
+			if !ok {
+				return received
+			}
+			received = append(received, timeSeriesData.Data)
+		case <-time.After(time.Second):
+			t.Fatal("the channel is not closed")
+		}
+	}
+}
+
+func TestHubFilter(t *testing.T) {
+	h := NewHub()
+	all := h.Subscribe(SubscribeOptions{})
+	temperature := h.Subscribe(SubscribeOptions{Sensors: []byte{TemperatureASCIICmd}, Decimation: 2})
+
+	for i := 1; i <= 4; i++ {
+		h.Publish(&TimeSeriesData{Data: map[byte]SensorData{
+			TemperatureASCIICmd: TemperatureData(i),
+			HumidityASCIICmd:    HumidityData(40),
+		}})
+	}
+	h.Close()
+
+	if got := receiveAll(t, all.C); len(got) != 4 {
+		t.Errorf("every sensor: %d data, want 4", len(got))
+	}
+	want := []map[byte]SensorData{{TemperatureASCIICmd: TemperatureData(1)}, {TemperatureASCIICmd: TemperatureData(3)}}
+	if got := receiveAll(t, temperature.C); !reflect.DeepEqual(got, want) {
+		t.Errorf("temperature: %v, want %v", got, want)
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	h := NewHub()
+	slow := h.Subscribe(SubscribeOptions{BufferSize: 2, Policy: DropOldest})
+	fast := h.Subscribe(SubscribeOptions{BufferSize: 2, Policy: BlockOnOverflow})
+
+	// the slow one doesn't receive, but Publish is never blocked by it
+	received := make(chan []map[byte]SensorData)
+	go func() { received <- receiveAll(t, fast.C) }()
+	for i := 1; i <= 5; i++ {
+		h.Publish(temperatureSample(float64(i)))
+	}
+
+	// the slow one has the newest 2 (the size counts the one being sent)
+	waitUntil(t, "dropping", func() bool { return slow.Dropped()[TemperatureASCIICmd] == 3 })
+	h.Close()
+
+	if got := <-received; len(got) != 5 {
+		t.Errorf("fast: %d data, want 5", len(got))
+	}
+	want := []map[byte]SensorData{{TemperatureASCIICmd: TemperatureData(4)}, {TemperatureASCIICmd: TemperatureData(5)}}
+	if got := receiveAll(t, slow.C); !reflect.DeepEqual(got, want) {
+		t.Errorf("slow: %v, want %v", got, want)
+	}
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	h := NewHub()
+	defer h.Close()
+
+	sub := h.Subscribe(SubscribeOptions{})
+	h.Publish(temperatureSample(1))
+	sub.Unsubscribe()
+
+	// the buffer is discarded, and nothing is delivered any more
+	h.Publish(temperatureSample(2))
+	if got := receiveAll(t, sub.C); len(got) > 1 {
+		t.Errorf("received %v after unsubscribe", got)
+	}
+}
@@ -0,0 +1,230 @@
+// Define InfluxDB line protocol and HTTP writer in this file
+package serial
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default batch of the pipeline to InfluxWriter (see influxPipelineOptions)
+const (
+	DefaultInfluxBatchSize     int           = 100
+	DefaultInfluxFlushInterval time.Duration = 10 * time.Second
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// AppendLineProtocol appends a line for each data in InfluxDB line protocol.
+// The measurement is the sensor (vibration has "axis" tag), and the timestamp is TimeSeriesData.Time in nanoseconds.
+func AppendLineProtocol(b []byte, device string, timeSeriesData *TimeSeriesData) []byte {
+	return appendLineProtocol(b, lineProtocolTags(device, nil), timeSeriesData)
+}
+
+// lineProtocolTags returns the tags of every line, the device and the extra tags in order of key.
+func lineProtocolTags(device string, extra map[string]string) string {
+	tags := ",device=" + tagEscaper.Replace(device)
+
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tags += "," + tagEscaper.Replace(key) + "=" + tagEscaper.Replace(extra[key])
+	}
+	return tags
+}
+
+func appendLineProtocol(b []byte, tags string, timeSeriesData *TimeSeriesData) []byte {
+	timestamp := strconv.FormatInt(timeSeriesData.Time.UnixNano(), 10)
+
+	for _, cmd := range SensorASCIICmds {
+		data, exist := timeSeriesData.Data[cmd]
+		if !exist || !isValidData(data) {
+			continue
+		}
+
+		var measurement, extraTags, fields string
+		switch value := data.(type) {
+		case TemperatureData:
+			measurement, fields = "temperature", "value="+formatFloatField(float64(value))
+		case HumidityData:
+			measurement, fields = "humidity", "value="+formatFloatField(float64(value))
+		case PressureData:
+			measurement, fields = "pressure", "value="+formatFloatField(float64(value))
+		case LightData:
+			measurement, fields = "light", "value="+strconv.FormatInt(int64(value), 10)+"i"
+		case BroadbandData:
+			measurement, fields = "broadband", "value="+formatFloatField(float64(value))
+		case *TiltData:
+			measurement = "tilt"
+			fields = fmt.Sprintf("x=%di,y=%di,z=%di", value.XAxis, value.YAxis, value.ZAxis)
+		case *VibrationData:
+			measurement = "vibration"
+			extraTags = ",axis=" + strings.TrimPrefix(SensorName(cmd), "vibration_")
+			fields = spectrumFields(value.Peak, value.Amp)
+		case *SoundData:
+			measurement = "sound"
+			fields = spectrumFields(value.Peak, value.Amp)
+		default:
+			continue
+		}
+
+		b = append(b, measurementEscaper.Replace(measurement)...)
+		b = append(b, tags...)
+		b = append(b, extraTags...)
+		b = append(b, ' ')
+		b = append(b, fields...)
+		b = append(b, ' ')
+		b = append(b, timestamp...)
+		b = append(b, '\n')
+	}
+
+	return b
+}
+
+func formatFloatField(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// spectrumFields makes fields of 6 peaks (peak1 ~ peak6 and amp1 ~ amp6).
+func spectrumFields(peak [6]int64, amp [6]float64) string {
+	fields := make([]string, 0, 12)
+	for i := range peak {
+		fields = append(fields,
+			fmt.Sprintf("peak%d=%di", i+1, peak[i]),
+			fmt.Sprintf("amp%d=%s", i+1, formatFloatField(amp[i])))
+	}
+	return strings.Join(fields, ",")
+}
+
+type InfluxOptions struct {
+	// URL of InfluxDB (e.g. "http://localhost:8086")
+	URL string
+	// Org, Bucket and Token are for InfluxDB 2.x.
+	// Database is for InfluxDB 1.x, and used only if Bucket is empty.
+	Org      string
+	Bucket   string
+	Token    string
+	Database string
+
+	// Tags added to every line besides the device (e.g. the labels of the device).
+	Tags map[string]string
+
+	Client *http.Client
+}
+
+// InfluxWriter writes the data of a device to InfluxDB.
+// It has no buffer and never retries by itself: each Write is one HTTP request,
+// and the batching and the retries are done by the pipeline (see influxPipelineOptions).
+type InfluxWriter struct {
+	device   string
+	opts     InfluxOptions
+	endpoint string
+	tags     string
+}
+
+func NewInfluxWriter(device string, opts InfluxOptions) *InfluxWriter {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &InfluxWriter{
+		device:   device,
+		opts:     opts,
+		endpoint: influxEndpoint(opts),
+		tags:     lineProtocolTags(device, opts.Tags),
+	}
+}
+
+func influxEndpoint(opts InfluxOptions) string {
+	base := strings.TrimSuffix(opts.URL, "/")
+	query := url.Values{}
+	query.Set("precision", "ns")
+
+	if opts.Bucket != "" {
+		query.Set("org", opts.Org)
+		query.Set("bucket", opts.Bucket)
+		return base + "/api/v2/write?" + query.Encode()
+	}
+
+	query.Set("db", opts.Database)
+	return base + "/write?" + query.Encode()
+}
+
+// Write posts the batch and returns when InfluxDB has accepted it, so InfluxWriter is a Sink (./sink.go).
+// A network error, 429 or 5xx can be retried by the pipeline,
+// but the other errors (e.g. 400 of a bad line or 401) are PermanentSinkError.
+func (w *InfluxWriter) Write(ctx context.Context, batch []*TimeSeriesData) error {
+	var lines []byte
+	for _, timeSeriesData := range batch {
+		lines = appendLineProtocol(lines, w.tags, timeSeriesData)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.endpoint, bytes.NewReader(lines))
+	if err != nil {
+		return PermanentSinkError(err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.opts.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.opts.Token)
+	}
+
+	resp, err := w.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = InfluxWriteError(resp.StatusCode, strings.TrimSpace(string(body)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5 {
+		return err
+	}
+	return PermanentSinkError(err)
+}
+
+// Close has nothing to do, every Write has been posted.
+func (w *InfluxWriter) Close() error {
+	return nil
+}
+
+// influxPipelineOptions batches the data for InfluxWriter, so a request has many lines.
+func influxPipelineOptions() PipelineOptions {
+	return PipelineOptions{BatchSize: DefaultInfluxBatchSize, BatchInterval: DefaultInfluxFlushInterval}
+}
+
+// runInflux writes every data from the channel.
+// If queueDir is given, the data go through a DiskQueue there, so they survive a restart while InfluxDB is unreachable.
+func runInflux(device string, in <-chan *TimeSeriesData, opts InfluxOptions, queueDir string) error {
+	writer := NewInfluxWriter(device, opts)
+	if queueDir == "" {
+		return NewPipeline(influxPipelineOptions(), writer).Run(context.Background(), in)
+	}
+
+	// the queue batches the data for InfluxDB instead of the pipeline
+	sink, err := newQueuedSink(queueDir, DiskQueueOptions{}, DefaultInfluxBatchSize, writer)
+	if err != nil {
+		return err
+	}
+	return NewPipeline(PipelineOptions{}, sink).Run(context.Background(), in)
+}
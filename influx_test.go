@@ -0,0 +1,146 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// influxServer answers the writes by the status codes (in order, and the last one is repeated).
+type influxServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	requests []*http.Request
+}
+
+func newInfluxServer(t *testing.T, statuses ...int) *influxServer {
+	s := &influxServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		status := s.statuses[0]
+		if len(s.statuses) > 1 {
+			s.statuses = s.statuses[1:]
+		}
+		s.bodies = append(s.bodies, string(body))
+		s.requests = append(s.requests, r)
+		s.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *influxServer) received() (bodies []string, requests []*http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.bodies, s.requests
+}
+
+func TestInfluxWriter(t *testing.T) {
+	server := newInfluxServer(t, http.StatusNoContent)
+	w := NewInfluxWriter("/dev/ttyACM0", InfluxOptions{
+		URL:    server.URL,
+		Org:    "o",
+		Bucket: "b",
+		Token:  "secret",
+		Tags:   map[string]string{"room": "lab 1"},
+	})
+
+	batch := []*TimeSeriesData{
+		{
+			Time: time.Unix(1, 0),
+			Data: map[byte]SensorData{
+				TemperatureASCIICmd: TemperatureData(23.45),
+				LightASCIICmd:       LightData(120),
+				HumidityASCIICmd:    HumidityData(ParseErrorCodeDLPTH1C),
+			},
+		},
+		{
+			Time: time.Unix(2, 0),
+			Data: map[byte]SensorData{TiltASCIICmd: &TiltData{XAxis: 10, YAxis: -3, ZAxis: 1000}},
+		},
+	}
+	if err := w.Write(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// one request for the batch, and the data not parsed is left out
+	bodies, requests := server.received()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(requests))
+	}
+	want := "temperature,device=/dev/ttyACM0,room=lab\\ 1 value=23.45 1000000000\n" +
+		"light,device=/dev/ttyACM0,room=lab\\ 1 value=120i 1000000000\n" +
+		"tilt,device=/dev/ttyACM0,room=lab\\ 1 x=10i,y=-3i,z=1000i 2000000000\n"
+	if bodies[0] != want {
+		t.Errorf("body =\n%s\nwant\n%s", bodies[0], want)
+	}
+	if got := requests[0].URL.String(); got != "/api/v2/write?bucket=b&org=o&precision=ns" {
+		t.Errorf("url = %s", got)
+	}
+	if got := requests[0].Header.Get("Authorization"); got != "Token secret" {
+		t.Errorf("authorization = %q", got)
+	}
+}
+
+func TestInfluxWriterRetry(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		wantRequests  int
+		wantDelivered uint64
+		wantFailed    uint64
+	}{
+		{"accepted", []int{http.StatusNoContent}, 1, 1, 0},
+		{"retried on 429 and 5xx", []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusNoContent}, 3, 1, 0},
+		{"given up after the retries", []int{http.StatusInternalServerError}, 3, 0, 1},
+		{"never retried on 4xx", []int{http.StatusBadRequest, http.StatusNoContent}, 1, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newInfluxServer(t, tt.statuses...)
+			w := NewInfluxWriter("fake", InfluxOptions{URL: server.URL, Database: "db"})
+
+			in := make(chan *TimeSeriesData, 1)
+			in <- &TimeSeriesData{Time: time.Unix(1, 0), Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(23.45)}}
+			close(in)
+
+			pipeline := NewPipeline(PipelineOptions{Retries: 2, RetryDelay: time.Millisecond}, w)
+			if err := pipeline.Run(context.Background(), in); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, requests := server.received(); len(requests) != tt.wantRequests {
+				t.Errorf("%d requests, want %d", len(requests), tt.wantRequests)
+			}
+			stats := pipeline.Stats()[0]
+			if stats.Delivered != tt.wantDelivered || stats.Failed != tt.wantFailed {
+				t.Errorf("delivered %d, failed %d, want %d, %d", stats.Delivered, stats.Failed, tt.wantDelivered, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestInfluxWriterPermanentError(t *testing.T) {
+	server := newInfluxServer(t, http.StatusUnauthorized)
+	w := NewInfluxWriter("fake", InfluxOptions{URL: server.URL, Database: "db"})
+
+	err := w.Write(context.Background(), []*TimeSeriesData{{Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(23.45)}}})
+	var permanent *permanentSinkError
+	if !errors.As(err, &permanent) || permanent.Error() != InfluxWriteError(http.StatusUnauthorized, "").Error() {
+		t.Errorf("err = %v, want permanent %v", err, InfluxWriteError(http.StatusUnauthorized, ""))
+	}
+}
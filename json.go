@@ -0,0 +1,136 @@
+// Define JSON encoding of the sensor data in this file
+package serial
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// JSON form of TimeSeriesData, keyed by SensorName instead of command
+type timeSeriesDataJSON struct {
+	Time   time.Time                  `json:"time"`
+	Data   map[string]json.RawMessage `json:"data"`
+	Timing map[string]*SampleTiming   `json:"timing,omitempty"`
+	Raw    map[string][]byte          `json:"raw,omitempty"`
+}
+
+func (timeSeriesData *TimeSeriesData) MarshalJSON() ([]byte, error) {
+	v := timeSeriesDataJSON{
+		Time: timeSeriesData.Time,
+		Data: make(map[string]json.RawMessage, len(timeSeriesData.Data)),
+	}
+
+	for cmd, data := range timeSeriesData.Data {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		v.Data[SensorName(cmd)] = b
+	}
+	if len(timeSeriesData.Timing) > 0 {
+		v.Timing = make(map[string]*SampleTiming, len(timeSeriesData.Timing))
+		for cmd, timing := range timeSeriesData.Timing {
+			v.Timing[SensorName(cmd)] = timing
+		}
+	}
+	if len(timeSeriesData.Raw) > 0 {
+		v.Raw = make(map[string][]byte, len(timeSeriesData.Raw))
+		for cmd, raw := range timeSeriesData.Raw {
+			v.Raw[SensorName(cmd)] = raw
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+func (timeSeriesData *TimeSeriesData) UnmarshalJSON(b []byte) error {
+	var v timeSeriesDataJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	timeSeriesData.Time = v.Time
+	timeSeriesData.Data = make(map[byte]SensorData, len(v.Data))
+	for name, raw := range v.Data {
+		cmd, err := SensorCmd(name)
+		if err != nil {
+			return err
+		}
+		data, err := unmarshalSensorData(cmd, raw)
+		if err != nil {
+			return err
+		}
+		// null is the data which couldn't be parsed
+		if data != nil {
+			timeSeriesData.Data[cmd] = data
+		}
+	}
+
+	timeSeriesData.Timing = nil
+	if len(v.Timing) > 0 {
+		timeSeriesData.Timing = make(map[byte]*SampleTiming, len(v.Timing))
+		for name, timing := range v.Timing {
+			cmd, err := SensorCmd(name)
+			if err != nil {
+				return err
+			}
+			timeSeriesData.Timing[cmd] = timing
+		}
+	}
+
+	timeSeriesData.Raw = nil
+	if len(v.Raw) > 0 {
+		timeSeriesData.Raw = make(map[byte][]byte, len(v.Raw))
+		for name, raw := range v.Raw {
+			cmd, err := SensorCmd(name)
+			if err != nil {
+				return err
+			}
+			timeSeriesData.Raw[cmd] = raw
+		}
+	}
+
+	return nil
+}
+
+// unmarshalSensorData decodes JSON into the data type of the command.
+func unmarshalSensorData(cmd byte, b []byte) (SensorData, error) {
+	switch cmd {
+	case TemperatureASCIICmd:
+		var data TemperatureData
+		return data, json.Unmarshal(b, &data)
+	case HumidityASCIICmd:
+		var data HumidityData
+		return data, json.Unmarshal(b, &data)
+	case PressureASCIICmd:
+		var data PressureData
+		return data, json.Unmarshal(b, &data)
+	case LightASCIICmd:
+		var data LightData
+		return data, json.Unmarshal(b, &data)
+	case BroadbandASCIICmd:
+		var data BroadbandData
+		return data, json.Unmarshal(b, &data)
+	case TiltASCIICmd:
+		var data *TiltData
+		if err := json.Unmarshal(b, &data); err != nil || data == nil {
+			return nil, err
+		}
+		return data, nil
+	case VibrationXASCIICmd, VibrationYASCIICmd, VibrationZASCIICmd:
+		var data *VibrationData
+		if err := json.Unmarshal(b, &data); err != nil || data == nil {
+			return nil, err
+		}
+		data.Axis = cmd
+		return data, nil
+	case SoundASCIICmd:
+		var data *SoundData
+		if err := json.Unmarshal(b, &data); err != nil || data == nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	return nil, InvalidCommandError()
+}
@@ -0,0 +1,71 @@
+// Define exclusive locking of the serial port in this file
+package serial
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+// Directory of UUCP style lock files (LCK..ttyACM0). Empty disables them.
+var lockDir string = "/var/lock"
+
+// InitLockDir changes the directory of the lock files,
+// e.g. "/run/lock" or a directory writable by the user.
+func InitLockDir(initLockDir string) {
+	lockDir = initLockDir
+}
+
+// portLock is released when the DLPTH1C is closed.
+// The flock on the port is released together with the port.
+type portLock struct {
+	// lock file created by this process
+	path string
+}
+
+func (l *portLock) release() {
+	if l == nil || l.path == "" {
+		return
+	}
+
+	if err := os.Remove(l.path); err != nil {
+		log.Printf("unlock %s: %v", l.path, err)
+	}
+}
+
+// OpenDLPTH1C opens the port with an exclusive lock, so another process
+// (e.g. a second instance of the tool) cannot open it at the same time.
+// It returns PortLockedError naming the owning PID if the port is already locked.
+func OpenDLPTH1C(portName string) (*DLPTH1C, error) {
+	port, lock, err := openLockedPort(portName)
+	if err != nil {
+		return nil, err
+	}
+
+	d := newDLPTH1C(portName, port)
+	d.lock = lock
+	return d, nil
+}
+
+// openLockedPort opens the port after taking the lock file, and then takes the flock on it.
+// The lock is released by the DLPTH1C which owns the port.
+func openLockedPort(portName string) (io.ReadWriteCloser, *portLock, error) {
+	lock, err := lockPort(portName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	port, err := openPort(portName)
+	if err != nil {
+		lock.release()
+		return nil, nil, err
+	}
+
+	if err := flockPort(portName, port, lock); err != nil {
+		port.Close()
+		lock.release()
+		return nil, nil, err
+	}
+
+	return port, lock, nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package serial
+
+import "io"
+
+// There are no lock files and flock, the port is opened exclusively by the OS.
+func lockPort(portName string) (*portLock, error) {
+	return &portLock{}, nil
+}
+
+func flockPort(portName string, port io.ReadWriteCloser, lock *portLock) error {
+	return nil
+}
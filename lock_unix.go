@@ -0,0 +1,158 @@
+//go:build unix
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Time given to another process to write its PID into the lock file it has just created
+var lockWriteWait time.Duration = 100 * time.Millisecond
+
+// lockPort creates the UUCP style lock file, which has the PID in 10 characters.
+// A lock file whose process doesn't exist anymore is stale and replaced.
+// A lock file without PID may be being written by another process, so it is read again after lockWriteWait,
+// and it is held by an unknown process if it still has no PID.
+// If the directory is missing or not writable, only flock is used.
+func lockPort(portName string) (*portLock, error) {
+	if lockDir == "" {
+		return &portLock{}, nil
+	}
+
+	path := filepath.Join(lockDir, "LCK.."+filepath.Base(portName))
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%10d\n", os.Getpid())
+			f.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return &portLock{path: path}, nil
+		}
+
+		if !errors.Is(err, fs.ErrExist) {
+			log.Printf("lock %s: %v (only flock is used)", path, err)
+			return &portLock{}, nil
+		}
+
+		pid, err := readLockPID(path)
+		if err == nil && pid == 0 {
+			time.Sleep(lockWriteWait)
+			pid, err = readLockPID(path)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			// released meanwhile
+			continue
+		}
+		if err != nil || pid == 0 {
+			log.Printf("lock %s has no PID (remove it if no process uses %s)", path, portName)
+			return nil, PortLockedError(portName, 0)
+		}
+		if processExists(pid) {
+			return nil, PortLockedError(portName, pid)
+		}
+
+		// stale lock file
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return nil, PortLockedError(portName, 0)
+}
+
+// readLockPID returns 0 if the lock file has no PID (e.g. it is empty or being written).
+// The PID is in ASCII (HDB UUCP), or in 4 bytes binary (old UUCP).
+func readLockPID(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && pid > 0 {
+		return pid, nil
+	}
+	if len(b) == 4 {
+		return int(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24), nil
+	}
+	return 0, nil
+}
+
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// flockPort takes the advisory lock on the port itself.
+// It also works with the processes which don't use the lock files.
+// The lock is the one created by lockPort for this process, so its lock file doesn't name the owner.
+func flockPort(portName string, port io.ReadWriteCloser, lock *portLock) error {
+	f, ok := port.(interface{ Fd() uintptr })
+	if !ok {
+		return nil
+	}
+
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		pid := 0
+		// the lock file has the PID of this process if lockPort created it
+		if lockDir != "" && lock.path == "" {
+			if pid, _ = readLockPID(filepath.Join(lockDir, "LCK.."+filepath.Base(portName))); pid > 0 && !processExists(pid) {
+				pid = 0
+			}
+		}
+		if pid == 0 {
+			pid = portHolder(portName, int(f.Fd()))
+		}
+		return PortLockedError(portName, pid)
+	}
+	return err
+}
+
+// portHolder finds the process which has the port open in /proc, except the descriptor of this process given.
+// It returns 0 if there is no /proc (not Linux) or the process is not visible to this user.
+func portHolder(portName string, ownFd int) int {
+	target, err := filepath.EvalSymlinks(portName)
+	if err != nil {
+		return 0
+	}
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if pid == os.Getpid() && fd.Name() == strconv.Itoa(ownFd) {
+				continue
+			}
+			if link, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && link == target {
+				return pid
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,148 @@
+//go:build unix
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFlockPortHolder(t *testing.T) {
+	dir := t.TempDir()
+	defer InitLockDir(lockDir)
+	InitLockDir(dir)
+
+	portName := filepath.Join(dir, "ttyFAKE0")
+	holder, err := os.Create(portName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	if err := flockPort(portName, holder, &portLock{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock file is written by this process, so PID 1 in it must not be reported
+	lockFile := filepath.Join(dir, "LCK..ttyFAKE0")
+	if err := os.WriteFile(lockFile, []byte(fmt.Sprintf("%10d\n", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := os.Open(portName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	err = flockPort(portName, port, &portLock{path: lockFile})
+	if err == nil {
+		t.Fatal("the port is locked twice")
+	}
+	if err.Error() == PortLockedError(portName, 1).Error() {
+		t.Errorf("%v: the PID of the own lock file is reported", err)
+	}
+	if _, statErr := os.Stat("/proc/self/fd"); statErr == nil {
+		// the holder is this process, through the other descriptor
+		if want := PortLockedError(portName, os.Getpid()).Error(); err.Error() != want {
+			t.Errorf("error = %v, want %v", err, want)
+		}
+	}
+
+	// the lock file of another process names it
+	err = flockPort(portName, port, &portLock{})
+	if want := PortLockedError(portName, 1).Error(); err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %v", err, want)
+	}
+}
+
+func TestLockPort(t *testing.T) {
+	defer InitLockDir(lockDir)
+	defer func(wait time.Duration) { lockWriteWait = wait }(lockWriteWait)
+	lockWriteWait = time.Millisecond
+
+	// a PID which no process has (above the maximum PID of Linux)
+	const stalePID = 1 << 30
+
+	tests := []struct {
+		name string
+		// content of the lock file before, none if nil
+		existing []byte
+		// 0 if the port is locked by this process
+		wantLockedBy int
+		wantErr      bool
+	}{
+		{name: "created"},
+		{name: "stale pid removed", existing: []byte(fmt.Sprintf("%10d\n", stalePID))},
+		{name: "stale binary pid removed", existing: []byte{0, 0, 0, 0x40}},
+		{name: "live pid rejected", existing: []byte(fmt.Sprintf("%10d\n", 1)), wantLockedBy: 1, wantErr: true},
+		// it may be being written by another process
+		{name: "empty held", existing: []byte{}, wantErr: true},
+		{name: "partly written held", existing: []byte("     "), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			InitLockDir(dir)
+			path := filepath.Join(dir, "LCK..ttyFAKE0")
+			if tt.existing != nil {
+				if err := os.WriteFile(path, tt.existing, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lock, err := lockPort("/dev/ttyFAKE0")
+			if tt.wantErr {
+				if want := PortLockedError("/dev/ttyFAKE0", tt.wantLockedBy).Error(); err == nil || err.Error() != want {
+					t.Errorf("error = %v, want %v", err, want)
+				}
+				// the lock file of the other process is kept
+				if b, err := os.ReadFile(path); err != nil || string(b) != string(tt.existing) {
+					t.Errorf("lock file = %q, %v", b, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if pid, err := readLockPID(path); err != nil || pid != os.Getpid() {
+				t.Errorf("lock file has %d (%v), want %d", pid, err, os.Getpid())
+			}
+
+			// released on close
+			lock.release()
+			if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("lock file after release: %v", err)
+			}
+		})
+	}
+}
+
+func TestLockPortWrittenMeanwhile(t *testing.T) {
+	defer InitLockDir(lockDir)
+	defer func(wait time.Duration) { lockWriteWait = wait }(lockWriteWait)
+	lockWriteWait = 50 * time.Millisecond
+
+	dir := t.TempDir()
+	InitLockDir(dir)
+	path := filepath.Join(dir, "LCK..ttyFAKE0")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the other process writes its PID while lockPort waits
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		os.WriteFile(path, []byte(fmt.Sprintf("%10d\n", 1)), 0644)
+	}()
+
+	if _, err := lockPort("/dev/ttyFAKE0"); err == nil || err.Error() != PortLockedError("/dev/ttyFAKE0", 1).Error() {
+		t.Errorf("error = %v, want the port locked by 1", err)
+	}
+}
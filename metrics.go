@@ -0,0 +1,297 @@
+// Define Prometheus metrics exporter in this file
+package serial
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default address of "metrics" mode
+const DefaultMetricsAddr string = ":9701"
+
+// Exporter keeps the latest data of each device
+// and serves them with the health of the serial link in Prometheus text format.
+type Exporter struct {
+	mu      sync.Mutex
+	devices map[string]*exportedDevice
+}
+
+type exportedDevice struct {
+	d           *DLPTH1C
+	latest      map[byte]SensorData
+	lastSuccess map[byte]time.Time
+}
+
+func NewExporter() *Exporter {
+	return &Exporter{devices: make(map[string]*exportedDevice)}
+}
+
+// Register adds the device, whose statistics are exported with the device label.
+func (e *Exporter) Register(device string, d *DLPTH1C) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.device(device).d = d
+}
+
+// Observe updates the latest data of the device.
+func (e *Exporter) Observe(device string, timeSeriesData *TimeSeriesData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	exported := e.device(device)
+	for cmd, data := range timeSeriesData.Data {
+		if !isValidData(data) {
+			continue
+		}
+
+		exported.latest[cmd] = data
+		if timing, exist := timeSeriesData.Timing[cmd]; exist && timing != nil {
+			exported.lastSuccess[cmd] = timing.Received
+		} else {
+			exported.lastSuccess[cmd] = timeSeriesData.Time
+		}
+	}
+}
+
+func (e *Exporter) device(device string) *exportedDevice {
+	exported, exist := e.devices[device]
+	if !exist {
+		exported = &exportedDevice{
+			latest:      make(map[byte]SensorData),
+			lastSuccess: make(map[byte]time.Time),
+		}
+		e.devices[device] = exported
+	}
+	return exported
+}
+
+// isValidData reports whether the data is not the result of parse error.
+func isValidData(data SensorData) bool {
+	switch value := data.(type) {
+	case TemperatureData:
+		return value != ParseErrorCodeDLPTH1C
+	case HumidityData:
+		return value != ParseErrorCodeDLPTH1C
+	case PressureData:
+		return value != ParseErrorCodeDLPTH1C
+	case LightData:
+		return value != ParseErrorCodeDLPTH1C
+	case BroadbandData:
+		return value != ParseErrorCodeDLPTH1C
+	case *TiltData:
+		return value != nil
+	case *VibrationData:
+		return value != nil
+	case *SoundData:
+		return value != nil
+	}
+	return false
+}
+
+// Only backslash, double quote and line feed are escaped in label values of the text format
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+// A metric family and its samples in the text format
+type metricFamily struct {
+	name    string
+	help    string
+	typ     string
+	samples []string
+}
+
+func (m *metricFamily) add(value float64, labels ...string) {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelValue(labels[i+1])))
+	}
+
+	m.samples = append(m.samples,
+		fmt.Sprintf("%s{%s} %s", m.name, strings.Join(pairs, ","), strconv.FormatFloat(value, 'g', -1, 64)))
+}
+
+func (m *metricFamily) write(w io.Writer) {
+	if len(m.samples) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+	for _, sample := range m.samples {
+		fmt.Fprintln(w, sample)
+	}
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WriteMetrics(w)
+}
+
+// WriteMetrics writes every metric in Prometheus text format.
+func (e *Exporter) WriteMetrics(w io.Writer) {
+	temperature := &metricFamily{name: "dlpth1c_temperature_celsius", help: "Temperature.", typ: "gauge"}
+	humidity := &metricFamily{name: "dlpth1c_humidity_percent", help: "Relative humidity.", typ: "gauge"}
+	pressure := &metricFamily{name: "dlpth1c_pressure_pascals", help: "Barometric pressure.", typ: "gauge"}
+	light := &metricFamily{name: "dlpth1c_light_level", help: "Light level.", typ: "gauge"}
+	broadband := &metricFamily{name: "dlpth1c_broadband", help: "Broadband sound level.", typ: "gauge"}
+	tilt := &metricFamily{name: "dlpth1c_tilt", help: "Tilt (accelerometer) by axis.", typ: "gauge"}
+	vibrationPeak := &metricFamily{name: "dlpth1c_vibration_peak_hertz", help: "Frequency of vibration peaks by axis.", typ: "gauge"}
+	vibrationAmp := &metricFamily{name: "dlpth1c_vibration_amplitude", help: "Amplitude of vibration peaks by axis.", typ: "gauge"}
+	soundPeak := &metricFamily{name: "dlpth1c_sound_peak_hertz", help: "Frequency of sound peaks.", typ: "gauge"}
+	soundAmp := &metricFamily{name: "dlpth1c_sound_amplitude", help: "Amplitude of sound peaks.", typ: "gauge"}
+
+	lastSuccess := &metricFamily{name: "dlpth1c_last_success_timestamp_seconds", help: "Time of the last successful read by sensor.", typ: "gauge"}
+	requests := &metricFamily{name: "dlpth1c_requests_total", help: "Requests sent by sensor, including retries.", typ: "counter"}
+	samples := &metricFamily{name: "dlpth1c_samples_total", help: "Responses parsed successfully by sensor.", typ: "counter"}
+	parseFailures := &metricFamily{name: "dlpth1c_parse_failures_total", help: "Responses that could not be parsed by sensor.", typ: "counter"}
+	dropped := &metricFamily{name: "dlpth1c_dropped_samples_total", help: "Samples lost by the overflow policy of the output buffer by sensor.", typ: "counter"}
+	bytesRead := &metricFamily{name: "dlpth1c_read_bytes_total", help: "Bytes read from the port.", typ: "counter"}
+	bytesWritten := &metricFamily{name: "dlpth1c_written_bytes_total", help: "Bytes written to the port.", typ: "counter"}
+	bytesDiscarded := &metricFamily{name: "dlpth1c_discarded_bytes_total", help: "Bytes discarded by flush.", typ: "counter"}
+	timeouts := &metricFamily{name: "dlpth1c_timeouts_total", help: "Commands timed out.", typ: "counter"}
+	retries := &metricFamily{name: "dlpth1c_retries_total", help: "Requests retried.", typ: "counter"}
+	resyncs := &metricFamily{name: "dlpth1c_resyncs_total", help: "Resynchronizations after misaligned responses.", typ: "counter"}
+	latency := &metricFamily{name: "dlpth1c_response_latency_seconds", help: "Latency from request to the end of response by sensor.", typ: "histogram"}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	devices := make([]string, 0, len(e.devices))
+	for device := range e.devices {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	for _, device := range devices {
+		exported := e.devices[device]
+
+		for _, cmd := range SensorASCIICmds {
+			sensor := SensorName(cmd)
+			if t, exist := exported.lastSuccess[cmd]; exist {
+				lastSuccess.add(float64(t.UnixNano())/1e9, "device", device, "sensor", sensor)
+			}
+
+			switch value := exported.latest[cmd].(type) {
+			case TemperatureData:
+				temperature.add(float64(value), "device", device)
+			case HumidityData:
+				humidity.add(float64(value), "device", device)
+			case PressureData:
+				// the sensor gives hPa, and metrics are in base units
+				pressure.add(float64(value)*100, "device", device)
+			case LightData:
+				light.add(float64(value), "device", device)
+			case BroadbandData:
+				broadband.add(float64(value), "device", device)
+			case *TiltData:
+				tilt.add(float64(value.XAxis), "device", device, "axis", "x")
+				tilt.add(float64(value.YAxis), "device", device, "axis", "y")
+				tilt.add(float64(value.ZAxis), "device", device, "axis", "z")
+			case *VibrationData:
+				axis := strings.TrimPrefix(sensor, "vibration_")
+				for i := range value.Peak {
+					peak := strconv.Itoa(i + 1)
+					vibrationPeak.add(float64(value.Peak[i]), "device", device, "axis", axis, "peak", peak)
+					vibrationAmp.add(value.Amp[i], "device", device, "axis", axis, "peak", peak)
+				}
+			case *SoundData:
+				for i := range value.Peak {
+					peak := strconv.Itoa(i + 1)
+					soundPeak.add(float64(value.Peak[i]), "device", device, "peak", peak)
+					soundAmp.add(value.Amp[i], "device", device, "peak", peak)
+				}
+			}
+		}
+
+		if exported.d == nil {
+			continue
+		}
+
+		stats := exported.d.Stats()
+		for _, cmd := range SensorASCIICmds {
+			sensor := SensorName(cmd)
+			requests.add(float64(stats.Requests[cmd]), "device", device, "sensor", sensor)
+			samples.add(float64(stats.Samples[cmd]), "device", device, "sensor", sensor)
+			parseFailures.add(float64(stats.ParseFailures[cmd]), "device", device, "sensor", sensor)
+			dropped.add(float64(stats.Dropped[cmd]), "device", device, "sensor", sensor)
+
+			h, exist := stats.Latency[cmd]
+			if !exist {
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range h.Bounds {
+				cumulative += h.Counts[i]
+				latency.addBucket(h, cumulative, bound.Seconds(), device, sensor)
+			}
+			latency.addBucket(h, h.Count, 0, device, sensor)
+		}
+		bytesRead.add(float64(stats.BytesRead), "device", device)
+		bytesWritten.add(float64(stats.BytesWritten), "device", device)
+		bytesDiscarded.add(float64(stats.BytesDiscarded), "device", device)
+		timeouts.add(float64(stats.Timeouts), "device", device)
+		retries.add(float64(stats.Retries), "device", device)
+		resyncs.add(float64(stats.Resyncs), "device", device)
+	}
+
+	for _, m := range []*metricFamily{
+		temperature, humidity, pressure, light, broadband, tilt,
+		vibrationPeak, vibrationAmp, soundPeak, soundAmp,
+		lastSuccess, requests, samples, parseFailures, dropped,
+		bytesRead, bytesWritten, bytesDiscarded, timeouts, retries, resyncs, latency,
+	} {
+		m.write(w)
+	}
+}
+
+// addBucket adds a bucket of the histogram.
+// The bound 0 means +Inf, and it is followed by _sum and _count.
+func (m *metricFamily) addBucket(h *LatencyHistogram, cumulative uint64, bound float64, device string, sensor string) {
+	labels := fmt.Sprintf(`device="%s",sensor="%s"`, labelValue(device), labelValue(sensor))
+	if bound > 0 {
+		m.samples = append(m.samples, fmt.Sprintf(`%s_bucket{%s,le="%s"} %d`,
+			m.name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative))
+		return
+	}
+
+	m.samples = append(m.samples,
+		fmt.Sprintf(`%s_bucket{%s,le="+Inf"} %d`, m.name, labels, cumulative),
+		fmt.Sprintf("%s_sum{%s} %s", m.name, labels, strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64)),
+		fmt.Sprintf("%s_count{%s} %d", m.name, labels, h.Count))
+}
+
+// runMetrics serves /metrics while reading all data.
+func runMetrics(d *DLPTH1C, in <-chan *TimeSeriesData, addr string) error {
+	exporter := NewExporter()
+	exporter.Register(d.portName, d)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- http.ListenAndServe(addr, mux)
+	}()
+
+	for {
+		select {
+		case timeSeriesData, ok := <-in:
+			if !ok {
+				return nil
+			}
+			exporter.Observe(d.portName, timeSeriesData)
+		case err := <-errs:
+			return err
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package serial
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		device string
+		data   map[byte]SensorData
+		want   []string
+	}{
+		{
+			name:   "base units",
+			device: "ttyACM0",
+			data: map[byte]SensorData{
+				TemperatureASCIICmd: TemperatureData(23.45),
+				PressureASCIICmd:    PressureData(1013.25),
+				TiltASCIICmd:        &TiltData{XAxis: 10, YAxis: -3, ZAxis: 1000},
+			},
+			want: []string{
+				`dlpth1c_temperature_celsius{device="ttyACM0"} 23.45`,
+				`dlpth1c_pressure_pascals{device="ttyACM0"} 101325`,
+				`dlpth1c_tilt{device="ttyACM0",axis="y"} -3`,
+			},
+		},
+		{
+			// only backslash, double quote and line feed are escaped
+			name:   "escaped label",
+			device: "lab \"2\"\\온도\n",
+			data:   map[byte]SensorData{HumidityASCIICmd: HumidityData(40.5)},
+			want:   []string{`dlpth1c_humidity_percent{device="lab \"2\"\\온도\n"} 40.5`},
+		},
+		{
+			name:   "error code is left out",
+			device: "ttyACM0",
+			data:   map[byte]SensorData{LightASCIICmd: LightData(ParseErrorCodeDLPTH1C)},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := NewExporter()
+			exporter.Observe(tt.device, &TimeSeriesData{Time: time.Unix(1700000000, 0), Data: tt.data})
+
+			var b bytes.Buffer
+			exporter.WriteMetrics(&b)
+
+			if tt.want == nil && b.Len() > 0 {
+				t.Errorf("metrics:\n%s", b.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(b.String(), want+"\n") {
+					t.Errorf("metrics don't have %s:\n%s", want, b.String())
+				}
+			}
+		})
+	}
+}
+
+func TestWriteMetricsLatency(t *testing.T) {
+	d := NewDLPTH1CFromPort("fake", newFakePort(sensorResponses))
+	defer d.Close()
+	if _, err := d.Read(LightASCIICmd); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter := NewExporter()
+	exporter.Register(`a"b`, d)
+
+	var b bytes.Buffer
+	exporter.WriteMetrics(&b)
+
+	for _, want := range []string{
+		`dlpth1c_response_latency_seconds_bucket{device="a\"b",sensor="light",le="0.01"} 1`,
+		`dlpth1c_response_latency_seconds_bucket{device="a\"b",sensor="light",le="+Inf"} 1`,
+		`dlpth1c_response_latency_seconds_count{device="a\"b",sensor="light"} 1`,
+		`dlpth1c_samples_total{device="a\"b",sensor="light"} 1`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("metrics don't have %s", want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Define minimal MQTT 3.1.1 client (only for publishing) in this file
+package serial
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MQTT control packet types (upper 4 bits of the fixed header)
+const (
+	mqttConnect    byte = 0x10
+	mqttConnack    byte = 0x20
+	mqttPublish    byte = 0x30
+	mqttPuback     byte = 0x40
+	mqttPingreq    byte = 0xC0
+	mqttPingresp   byte = 0xD0
+	mqttDisconnect byte = 0xE0
+)
+
+// Flags of CONNECT packet
+const (
+	mqttUsernameFlag     byte = 0x80
+	mqttPasswordFlag     byte = 0x40
+	mqttWillRetainFlag   byte = 0x20
+	mqttWillFlag         byte = 0x04
+	mqttCleanSessionFlag byte = 0x02
+)
+
+// A message to be published
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+}
+
+type mqttConnectOptions struct {
+	clientID  string
+	username  string
+	password  string
+	keepAlive time.Duration
+	will      *mqttMessage
+}
+
+// mqttConn is a connection to the broker after CONNACK.
+type mqttConn struct {
+	conn net.Conn
+
+	mu     sync.Mutex
+	nextID uint16
+	acks   map[uint16]chan struct{}
+
+	// closed when the connection is lost
+	lost chan struct{}
+	err  error
+}
+
+// mqttHandshake sends CONNECT and waits for CONNACK.
+func mqttHandshake(conn net.Conn, opts mqttConnectOptions, timeout time.Duration) (*mqttConn, error) {
+	var flags byte = mqttCleanSessionFlag
+	payload := mqttString(opts.clientID)
+
+	if opts.will != nil {
+		flags |= mqttWillFlag | opts.will.qos<<3
+		if opts.will.retain {
+			flags |= mqttWillRetainFlag
+		}
+		payload = append(payload, mqttString(opts.will.topic)...)
+		payload = append(payload, mqttBytes(opts.will.payload)...)
+	}
+	if opts.username != "" {
+		flags |= mqttUsernameFlag
+		payload = append(payload, mqttString(opts.username)...)
+	}
+	if opts.password != "" {
+		flags |= mqttPasswordFlag
+		payload = append(payload, mqttString(opts.password)...)
+	}
+
+	variable := append(mqttString("MQTT"), 4, flags)
+	variable = binary.BigEndian.AppendUint16(variable, uint16(opts.keepAlive/time.Second))
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write(mqttPacket(mqttConnect, append(variable, payload...))); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	header, body, err := mqttReadPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	if header&0xF0 != mqttConnack || len(body) != 2 {
+		return nil, MQTTProtocolError()
+	}
+	if body[1] != 0 {
+		return nil, MQTTConnectionRefusedError(body[1])
+	}
+
+	c := &mqttConn{
+		conn: conn,
+		acks: make(map[uint16]chan struct{}),
+		lost: make(chan struct{}),
+	}
+	go c.readLoop(r)
+	return c, nil
+}
+
+// readLoop receives acknowledgements until the connection is lost.
+func (c *mqttConn) readLoop(r *bufio.Reader) {
+	defer close(c.lost)
+
+	for {
+		header, body, err := mqttReadPacket(r)
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		if header&0xF0 == mqttPuback && len(body) >= 2 {
+			id := binary.BigEndian.Uint16(body)
+
+			c.mu.Lock()
+			if ack, exist := c.acks[id]; exist {
+				close(ack)
+				delete(c.acks, id)
+			}
+			c.mu.Unlock()
+		}
+		// PINGRESP and the others are ignored
+	}
+}
+
+// publish sends the message, and waits for PUBACK if QoS is 1.
+func (c *mqttConn) publish(message *mqttMessage, timeout time.Duration) error {
+	header := mqttPublish | message.qos<<1
+	if message.retain {
+		header |= 0x01
+	}
+
+	body := mqttString(message.topic)
+	var id uint16
+	var ack chan struct{}
+	if message.qos > 0 {
+		c.mu.Lock()
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID++
+		}
+		id = c.nextID
+		ack = make(chan struct{})
+		c.acks[id] = ack
+		c.mu.Unlock()
+
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, message.payload...)
+
+	if ack == nil {
+		return c.write(mqttPacket(header, body), timeout)
+	}
+	// the entry is left only if PUBACK never comes, so it is removed here
+	defer func() {
+		c.mu.Lock()
+		delete(c.acks, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(mqttPacket(header, body), timeout); err != nil {
+		return err
+	}
+
+	select {
+	case <-ack:
+		return nil
+	case <-c.lost:
+		return c.err
+	case <-time.After(timeout):
+		return TimeoutError()
+	}
+}
+
+func (c *mqttConn) ping(timeout time.Duration) error {
+	return c.write([]byte{mqttPingreq, 0}, timeout)
+}
+
+// disconnect closes the connection gracefully, so the broker doesn't publish the will.
+func (c *mqttConn) disconnect(timeout time.Duration) error {
+	c.write([]byte{mqttDisconnect, 0}, timeout)
+	return c.conn.Close()
+}
+
+func (c *mqttConn) write(b []byte, timeout time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// mqttPacket makes a packet of the fixed header and the rest.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+
+	// remaining length
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+
+	return append(packet, body...)
+}
+
+func mqttReadPacket(r *bufio.Reader) (header byte, body []byte, err error) {
+	header, err = r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i >= 4 {
+			return 0, nil, MQTTProtocolError()
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7F) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	body = make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func mqttString(s string) []byte {
+	return mqttBytes([]byte(s))
+}
+
+func mqttBytes(b []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)
+}
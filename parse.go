@@ -6,6 +6,45 @@ import (
 	"strings"
 )
 
+// parseResponse selects the parsing function by the command.
+// If the response couldn't be parsed, the data of tilt, vibration and sound is nil
+// (not a nil pointer, so it can be checked by data == nil),
+// and the data of the others has ParseErrorCodeDLPTH1C.
+func parseResponse(cmd byte, b string) (SensorData, error) {
+	switch cmd {
+	case TemperatureASCIICmd:
+		return parseTemperature(b)
+	case HumidityASCIICmd:
+		return parseHumidity(b)
+	case PressureASCIICmd:
+		return parsePressure(b)
+	case TiltASCIICmd:
+		tilt, err := parseTilt(b)
+		if tilt == nil {
+			return nil, err
+		}
+		return tilt, err
+	case VibrationXASCIICmd, VibrationYASCIICmd, VibrationZASCIICmd:
+		vibration, err := parseVibration(cmd, b)
+		if vibration == nil {
+			return nil, err
+		}
+		return vibration, err
+	case LightASCIICmd:
+		return parseLight(b)
+	case SoundASCIICmd:
+		sound, err := parseSound(b)
+		if sound == nil {
+			return nil, err
+		}
+		return sound, err
+	case BroadbandASCIICmd:
+		return parseBroadband(b)
+	}
+
+	return nil, InvalidCommandError()
+}
+
 func parseTemperature(b string) (TemperatureData, error) {
 	sep := strings.Split(b, "= ")
 	if len(sep) < 2 {
@@ -0,0 +1,85 @@
+package serial
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Responses of the DLP-TH1C in ASCII mode
+const (
+	temperatureResponse = "\nTemperature = 23.45\xb0C\r\n"
+	humidityResponse    = "Humidity = 40.5%\r\n"
+	pressureResponse    = "Pressure = 1013.25\r\n"
+	tiltResponse        = "X:10 Y:-3 Z:1000\r\n"
+	vibrationResponse   = "\nFund: 12Hz:0.5\r\nPeak2: 24Hz:0.4\r\nPeak3: 36Hz:0.3\r\nPeak4: 48Hz:0.2\r\nPeak5: 60Hz:0.1\r\nPeak6: 72Hz:0.05\r\n"
+	lightResponse       = "Light: 55\r\n"
+	soundResponse       = "\nFund: 100Hz:1.5\r\nPeak2: 200Hz:0.4\r\nPeak3: 300Hz:0.3\r\nPeak4: 400Hz:0.2\r\nPeak5: 500Hz:0.1\r\nPeak6: 600Hz:0.05\r\n"
+	broadbandResponse   = "Broadband: 0.75\r\n"
+	pingResponse        = "Q\r\n"
+)
+
+// sensorResponses answers every command like the sensor does
+var sensorResponses = map[byte]string{
+	TemperatureASCIICmd: temperatureResponse,
+	HumidityASCIICmd:    humidityResponse,
+	PressureASCIICmd:    pressureResponse,
+	TiltASCIICmd:        tiltResponse,
+	VibrationXASCIICmd:  vibrationResponse,
+	VibrationYASCIICmd:  vibrationResponse,
+	VibrationZASCIICmd:  vibrationResponse,
+	LightASCIICmd:       lightResponse,
+	SoundASCIICmd:       soundResponse,
+	BroadbandASCIICmd:   broadbandResponse,
+	PingASCIICmd:        pingResponse,
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      byte
+		response string
+		want     SensorData
+		wantErr  bool
+	}{
+		{"temperature", TemperatureASCIICmd, temperatureResponse, TemperatureData(23.45), false},
+		{"humidity", HumidityASCIICmd, humidityResponse, HumidityData(40.5), false},
+		{"pressure", PressureASCIICmd, pressureResponse, PressureData(1013.25), false},
+		{"tilt", TiltASCIICmd, tiltResponse, &TiltData{XAxis: 10, YAxis: -3, ZAxis: 1000}, false},
+		{"vibration", VibrationYASCIICmd, vibrationResponse, &VibrationData{
+			Axis: VibrationYASCIICmd,
+			Peak: [6]int64{12, 24, 36, 48, 60, 72},
+			Amp:  [6]float64{0.5, 0.4, 0.3, 0.2, 0.1, 0.05},
+		}, false},
+		{"light", LightASCIICmd, lightResponse, LightData(55), false},
+		{"sound", SoundASCIICmd, soundResponse, &SoundData{
+			Peak: [6]int64{100, 200, 300, 400, 500, 600},
+			Amp:  [6]float64{1.5, 0.4, 0.3, 0.2, 0.1, 0.05},
+		}, false},
+		{"broadband", BroadbandASCIICmd, broadbandResponse, BroadbandData(0.75), false},
+
+		// the scalar data has the error code
+		{"temperature missing", TemperatureASCIICmd, "Temperat", TemperatureData(ParseErrorCodeDLPTH1C), true},
+		{"humidity garbage", HumidityASCIICmd, "Humidity = x%\r\n", HumidityData(ParseErrorCodeDLPTH1C), true},
+		{"light out of range", LightASCIICmd, "Light: 300\r\n", LightData(ParseErrorCodeDLPTH1C), true},
+
+		// the others are nil, not a nil pointer
+		{"tilt missing", TiltASCIICmd, "X:10 Y:", nil, true},
+		{"vibration missing", VibrationXASCIICmd, "\nFund: 12Hz:0.5\r\n", nil, true},
+		{"sound garbage", SoundASCIICmd, "\nFund: xHz:1\r\n\n\n\n\n\n\n", nil, true},
+		{"misaligned", TiltASCIICmd, temperatureResponse, nil, true},
+
+		{"unknown command", PingASCIICmd, pingResponse, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseResponse(tt.cmd, tt.response)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("data = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,418 @@
+// Define MQTT publisher of the sensor data in this file
+package serial
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default options of MQTTPublisher
+const (
+	DefaultMQTTTopicTemplate  string        = "dlpth1c/{device}/{sensor}"
+	DefaultMQTTStatusTemplate string        = "dlpth1c/{device}/status"
+	DefaultMQTTKeepAlive      time.Duration = 60 * time.Second
+	DefaultMQTTTimeout        time.Duration = 10 * time.Second
+	DefaultMQTTBufferSize     int           = 10000
+)
+
+// Payloads of the status topic
+const (
+	MQTTOnline  string = "online"
+	MQTTOffline string = "offline"
+)
+
+type MQTTOptions struct {
+	// Broker is "host:port", "tcp://host:port" or "tls://host:port"
+	Broker    string
+	ClientID  string
+	Username  string
+	Password  string
+	TLSConfig *tls.Config
+
+	// TopicTemplate is the topic of each data, "{device}" and "{sensor}" are replaced.
+	// SensorTopics replaces the template for some sensors.
+	TopicTemplate string
+	SensorTopics  map[byte]string
+	QoS           byte
+	Retain        bool
+
+	// StatusTemplate is the topic of MQTTOnline and MQTTOffline (retained).
+	// MQTTOffline is also the last will, so it is published by the broker when the publisher is gone.
+	StatusTemplate string
+
+	KeepAlive time.Duration
+	Timeout   time.Duration
+
+	// BufferSize is the number of messages kept while the broker is unreachable.
+	// The oldest one is dropped when it is full.
+	BufferSize int
+
+	// HomeAssistant publishes discovery config whenever it connects (see ./discovery.go).
+	// DiscoveryPrefix is "homeassistant" by default.
+	HomeAssistant   bool
+	DiscoveryPrefix string
+
+	// Dial replaces the connection to the broker (e.g. net.Pipe to an in-process broker).
+	Dial func() (net.Conn, error)
+}
+
+// Payload of each data
+type MQTTPayload struct {
+	Device string          `json:"device"`
+	Sensor string          `json:"sensor"`
+	Time   time.Time       `json:"time"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// MQTTPublisher publishes the data of a device to the broker.
+// It keeps connecting to the broker in background, and buffers messages while it is unreachable.
+type MQTTPublisher struct {
+	device string
+	opts   MQTTOptions
+
+	mu      sync.Mutex
+	buffer  []*mqttMessage
+	dropped uint64
+	// the number of messages enqueued and published so far, for Flush
+	enqueued  uint64
+	published uint64
+	// closed and replaced whenever a message is published or dropped
+	progress chan struct{}
+
+	notify  chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewMQTTPublisher starts connecting to the broker. QoS 2 is not supported.
+func NewMQTTPublisher(device string, opts MQTTOptions) (*MQTTPublisher, error) {
+	if opts.QoS > 1 {
+		return nil, MQTTInvalidQoSError(opts.QoS)
+	}
+	if opts.TopicTemplate == "" {
+		opts.TopicTemplate = DefaultMQTTTopicTemplate
+	}
+	if opts.StatusTemplate == "" {
+		opts.StatusTemplate = DefaultMQTTStatusTemplate
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = DefaultMQTTKeepAlive
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultMQTTTimeout
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultMQTTBufferSize
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "dlpth1c-" + topicLevel(device)
+	}
+
+	p := &MQTTPublisher{
+		device:   device,
+		opts:     opts,
+		progress: make(chan struct{}),
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go p.run()
+
+	return p, nil
+}
+
+// topicLevel makes the device name (e.g. "/dev/ttyACM0") usable as one level of topic.
+func topicLevel(device string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(path.Base(device))
+}
+
+func (p *MQTTPublisher) topic(template string, cmd byte) string {
+	return strings.NewReplacer("{device}", topicLevel(p.device), "{sensor}", SensorName(cmd)).Replace(template)
+}
+
+// Publish queues a message for each data. It doesn't wait for the broker.
+func (p *MQTTPublisher) Publish(timeSeriesData *TimeSeriesData) error {
+	for _, cmd := range SensorASCIICmds {
+		data, exist := timeSeriesData.Data[cmd]
+		if !exist || !isValidData(data) {
+			continue
+		}
+
+		value, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		payload := MQTTPayload{
+			Device: p.device,
+			Sensor: SensorName(cmd),
+			Time:   timeSeriesData.Time,
+			Value:  value,
+		}
+		if timing := timeSeriesData.Timing[cmd]; timing != nil {
+			payload.Time = timing.Received
+		}
+
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		template := p.opts.TopicTemplate
+		if sensorTemplate, exist := p.opts.SensorTopics[cmd]; exist {
+			template = sensorTemplate
+		}
+		p.enqueue(&mqttMessage{topic: p.topic(template, cmd), payload: b, qos: p.opts.QoS, retain: p.opts.Retain})
+	}
+
+	return nil
+}
+
+// Write publishes the batch, so MQTTPublisher is a Sink (./sink.go).
+func (p *MQTTPublisher) Write(ctx context.Context, batch []*TimeSeriesData) error {
+	for _, timeSeriesData := range batch {
+		if err := p.Publish(timeSeriesData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *MQTTPublisher) enqueue(message *mqttMessage) {
+	p.mu.Lock()
+	if len(p.buffer) >= p.opts.BufferSize {
+		p.buffer = p.buffer[1:]
+		p.dropped++
+		p.progressed()
+	}
+	p.buffer = append(p.buffer, message)
+	p.enqueued++
+	p.mu.Unlock()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// progressed wakes up Flush. p.mu must be locked.
+func (p *MQTTPublisher) progressed() {
+	close(p.progress)
+	p.progress = make(chan struct{})
+}
+
+// Flush waits until the messages queued so far are published (and acknowledged with QoS 1),
+// so a DiskQueue (./diskqueue.go) forgets the data only after the broker has them.
+// It returns MQTTMessagesDroppedError as a PermanentSinkError if some of them were dropped from the full buffer:
+// the others have been published, so writing the batch again would publish them twice.
+func (p *MQTTPublisher) Flush(ctx context.Context) error {
+	p.mu.Lock()
+	target, dropped := p.enqueued, p.dropped
+	p.mu.Unlock()
+
+	for {
+		p.mu.Lock()
+		// the buffer is in order, so the messages before target are gone when this many are
+		done := p.published+p.dropped >= target
+		lost := p.dropped - dropped
+		progress := p.progress
+		p.mu.Unlock()
+
+		if done {
+			if lost > 0 {
+				return PermanentSinkError(MQTTMessagesDroppedError(int(lost)))
+			}
+			return nil
+		}
+
+		select {
+		case <-progress:
+		case <-p.stopped:
+			return ClosedError()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Backlog returns the number of messages not published yet, and the number of dropped ones.
+func (p *MQTTPublisher) Backlog() (buffered int, dropped uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.buffer), p.dropped
+}
+
+// Close publishes the buffered messages (if connected) and the offline status, then disconnects.
+// It returns MQTTMessagesDroppedError if some messages are still buffered (e.g. the broker is unreachable).
+func (p *MQTTPublisher) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+	})
+	<-p.stopped
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.buffer) > 0 {
+		return MQTTMessagesDroppedError(len(p.buffer))
+	}
+	return nil
+}
+
+func (p *MQTTPublisher) run() {
+	defer close(p.stopped)
+
+	backoff := time.Second
+	for {
+		conn, err := p.connect()
+		if err != nil {
+			log.Printf("mqtt %s: %v", p.opts.Broker, err)
+
+			select {
+			case <-time.After(backoff):
+			case <-p.done:
+				return
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		closing := p.serve(conn)
+		if closing {
+			status := &mqttMessage{topic: p.topic(p.opts.StatusTemplate, 0), payload: []byte(MQTTOffline), qos: 1, retain: true}
+			conn.publish(status, p.opts.Timeout)
+			conn.disconnect(p.opts.Timeout)
+			return
+		}
+		conn.conn.Close()
+	}
+}
+
+func (p *MQTTPublisher) connect() (*mqttConn, error) {
+	var conn net.Conn
+	var err error
+	if p.opts.Dial != nil {
+		conn, err = p.opts.Dial()
+	} else {
+		conn, err = p.dial()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := p.topic(p.opts.StatusTemplate, 0)
+	c, err := mqttHandshake(conn, mqttConnectOptions{
+		clientID:  p.opts.ClientID,
+		username:  p.opts.Username,
+		password:  p.opts.Password,
+		keepAlive: p.opts.KeepAlive,
+		will:      &mqttMessage{topic: status, payload: []byte(MQTTOffline), qos: 1, retain: true},
+	}, p.opts.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Discovery config goes first, so the entities exist before they become available
+	messages := []*mqttMessage{}
+	if p.opts.HomeAssistant {
+		if messages, err = p.discoveryMessages(); err != nil {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+	messages = append(messages, &mqttMessage{topic: status, payload: []byte(MQTTOnline), qos: 1, retain: true})
+
+	for _, message := range messages {
+		if err := c.publish(message, p.opts.Timeout); err != nil {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *MQTTPublisher) dial() (net.Conn, error) {
+	broker := p.opts.Broker
+	dialer := &net.Dialer{Timeout: p.opts.Timeout}
+
+	if strings.HasPrefix(broker, "tls://") || strings.HasPrefix(broker, "ssl://") {
+		return tls.DialWithDialer(dialer, "tcp", broker[len("tls://"):], p.opts.TLSConfig)
+	}
+	return dialer.Dial("tcp", strings.TrimPrefix(broker, "tcp://"))
+}
+
+// serve publishes the buffered messages in order until the connection is lost.
+// It returns true if the publisher is closing.
+func (p *MQTTPublisher) serve(conn *mqttConn) (closing bool) {
+	keepAlive := time.NewTicker(p.opts.KeepAlive / 2)
+	defer keepAlive.Stop()
+
+	for {
+		p.mu.Lock()
+		var message *mqttMessage
+		if len(p.buffer) > 0 {
+			message = p.buffer[0]
+		}
+		p.mu.Unlock()
+
+		if message != nil {
+			if err := conn.publish(message, p.opts.Timeout); err != nil {
+				log.Printf("mqtt %s: %v", p.opts.Broker, err)
+				return false
+			}
+
+			// the head could have been dropped meanwhile
+			p.mu.Lock()
+			if len(p.buffer) > 0 && p.buffer[0] == message {
+				p.buffer = p.buffer[1:]
+				p.published++
+				p.progressed()
+			}
+			p.mu.Unlock()
+			continue
+		}
+
+		select {
+		case <-p.notify:
+		case <-keepAlive.C:
+			if err := conn.ping(p.opts.Timeout); err != nil {
+				return false
+			}
+		case <-conn.lost:
+			return false
+		case <-p.done:
+			return true
+		}
+	}
+}
+
+// runMQTT publishes every data from the channel.
+// If queueDir is given, the data go through a DiskQueue there, so they survive a restart while the broker is unreachable.
+func runMQTT(device string, in <-chan *TimeSeriesData, broker string, homeAssistant bool, queueDir string) error {
+	publisher, err := NewMQTTPublisher(device, MQTTOptions{Broker: broker, QoS: 1, HomeAssistant: homeAssistant})
+	if err != nil {
+		return err
+	}
+
+	var sink Sink = publisher
+	if queueDir != "" {
+		if sink, err = newQueuedSink(queueDir, DiskQueueOptions{}, DefaultQueueBatchSize, publisher); err != nil {
+			publisher.Close()
+			return err
+		}
+	}
+	return NewPipeline(PipelineOptions{}, sink).Run(context.Background(), in)
+}
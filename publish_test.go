@@ -0,0 +1,238 @@
+package serial
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// brokerPacket is a packet received by fakeBroker, with the number of the connection.
+type brokerPacket struct {
+	conn    int
+	kind    byte
+	topic   string
+	payload []byte
+}
+
+// fakeBroker is an in-process MQTT broker which acknowledges every packet.
+// The first connection is lost when it receives a data (not a status) instead of acknowledging it.
+type fakeBroker struct {
+	ln      net.Listener
+	packets chan brokerPacket
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, packets: make(chan brokerPacket, 100)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(n, conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(n int, conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		header, body, err := mqttReadPacket(r)
+		if err != nil {
+			return
+		}
+		packet := brokerPacket{conn: n, kind: header & 0xF0}
+
+		switch packet.kind {
+		case mqttConnect:
+			conn.Write([]byte{mqttConnack, 2, 0, 0})
+
+		case mqttPublish:
+			length := int(binary.BigEndian.Uint16(body))
+			packet.topic = string(body[2 : 2+length])
+			body = body[2+length:]
+
+			var id []byte
+			if header&0x06 != 0 {
+				id, body = body[:2], body[2:]
+			}
+			packet.payload = body
+
+			if n == 0 && packet.topic != "dlpth1c/ttyACM0/status" {
+				b.packets <- packet
+				return
+			}
+			if id != nil {
+				conn.Write(mqttPacket(mqttPuback, id))
+			}
+
+		case mqttPingreq:
+			conn.Write([]byte{mqttPingresp, 0})
+		}
+		b.packets <- packet
+	}
+}
+
+// next returns the next packet other than PINGREQ.
+func (b *fakeBroker) next(t *testing.T) brokerPacket {
+	t.Helper()
+
+	for {
+		select {
+		case packet := <-b.packets:
+			if packet.kind == mqttPingreq {
+				continue
+			}
+			return packet
+		case <-time.After(5 * time.Second):
+			t.Fatal("no packet is received")
+			return brokerPacket{}
+		}
+	}
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	broker := newFakeBroker(t)
+
+	p, err := NewMQTTPublisher("/dev/ttyACM0", MQTTOptions{
+		Broker:  "tcp://" + broker.ln.Addr().String(),
+		QoS:     1,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := &TimeSeriesData{
+		Time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(23.45)},
+	}
+	if err := p.Publish(sample); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data is lost with the first connection, so it is published again after reconnecting
+	want := []struct {
+		conn    int
+		kind    byte
+		topic   string
+		payload string
+	}{
+		{0, mqttConnect, "", ""},
+		{0, mqttPublish, "dlpth1c/ttyACM0/status", MQTTOnline},
+		{0, mqttPublish, "dlpth1c/ttyACM0/temperature", ""},
+		{1, mqttConnect, "", ""},
+		{1, mqttPublish, "dlpth1c/ttyACM0/status", MQTTOnline},
+		{1, mqttPublish, "dlpth1c/ttyACM0/temperature", ""},
+	}
+
+	var last brokerPacket
+	for i, w := range want {
+		last = broker.next(t)
+		if last.conn != w.conn || last.kind != w.kind || last.topic != w.topic {
+			t.Fatalf("packet %d = (%d, %#x, %q), want (%d, %#x, %q)", i, last.conn, last.kind, last.topic, w.conn, w.kind, w.topic)
+		}
+		if w.payload != "" && string(last.payload) != w.payload {
+			t.Errorf("packet %d: payload = %q, want %q", i, last.payload, w.payload)
+		}
+	}
+
+	// acknowledged by the second connection
+	if err := p.Flush(context.Background()); err != nil {
+		t.Errorf("Flush: %v", err)
+	}
+
+	var payload MQTTPayload
+	if err := json.Unmarshal(last.payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Sensor != "temperature" || string(payload.Value) != "23.45" || !payload.Time.Equal(sample.Time) {
+		t.Errorf("payload = %+v", payload)
+	}
+
+	// the offline status is published before disconnecting, so the will is not
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if packet := broker.next(t); packet.topic != "dlpth1c/ttyACM0/status" || string(packet.payload) != MQTTOffline {
+		t.Errorf("packet = %+v, want the offline status", packet)
+	}
+	if packet := broker.next(t); packet.kind != mqttDisconnect {
+		t.Errorf("packet = %#x, want DISCONNECT", packet.kind)
+	}
+}
+
+func TestMQTTPublisherUnreachable(t *testing.T) {
+	// nothing listens on the address any more
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	p, err := NewMQTTPublisher("/dev/ttyACM0", MQTTOptions{Broker: ln.Addr().String(), QoS: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Publish(&TimeSeriesData{Data: map[byte]SensorData{TemperatureASCIICmd: TemperatureData(23.45)}})
+
+	// never acknowledged, so a DiskQueue keeps it
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Flush: err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := p.Close(); err == nil || err.Error() != MQTTMessagesDroppedError(1).Error() {
+		t.Errorf("Close: err = %v, want %v", err, MQTTMessagesDroppedError(1))
+	}
+}
+
+func TestMQTTPublisherFlushDropped(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	p, err := NewMQTTPublisher("/dev/ttyACM0", MQTTOptions{Broker: ln.Addr().String(), QoS: 1, BufferSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.Publish(temperatureSample(1))
+
+	// the message being flushed is dropped by the next one
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		p.Publish(temperatureSample(2))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = p.Flush(ctx)
+	var permanent *permanentSinkError
+	if !errors.As(err, &permanent) || errors.Unwrap(err).Error() != MQTTMessagesDroppedError(1).Error() {
+		t.Errorf("Flush: err = %v, want permanent %v", err, MQTTMessagesDroppedError(1))
+	}
+}
+
+func TestMQTTInvalidQoS(t *testing.T) {
+	if _, err := NewMQTTPublisher("/dev/ttyACM0", MQTTOptions{QoS: 2}); err == nil || err.Error() != MQTTInvalidQoSError(2).Error() {
+		t.Errorf("err = %v, want %v", err, MQTTInvalidQoSError(2))
+	}
+}
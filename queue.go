@@ -0,0 +1,234 @@
+// Define command queue that serializes request/response transactions in this file
+package serial
+
+import (
+	"io"
+	"time"
+)
+
+// Priority decides which transaction goes to the sensor first
+// when several goroutines are waiting for the same DLPTH1C.
+type Priority int
+
+const (
+	// NormalPriority is used by the read functions while streaming.
+	NormalPriority Priority = iota
+	// HighPriority is used by one-off commands (e.g. range change),
+	// so it doesn't have to wait behind the queued sensor requests.
+	HighPriority
+)
+
+// Default timeout for a single command.
+// Vibration and sound take a few seconds to be calculated by the sensor.
+const (
+	DefaultCommandTimeout  time.Duration = 5 * time.Second
+	SpectrumCommandTimeout time.Duration = 15 * time.Second
+)
+
+// A transaction is one request (written to vcp) and its whole response.
+type transaction struct {
+	cmd      []byte
+	timeout  time.Duration
+	response chan *transactionResult
+
+	// discard the input before writing the command
+	flush bool
+}
+
+type transactionResult struct {
+	b   []byte
+	err error
+
+	// when the request was written and when the last byte of the response was read
+	sent     time.Time
+	received time.Time
+}
+
+// Transact sends the command to the sensor and returns the raw response.
+// It is safe to be called by several goroutines at the same time,
+// since every transaction is executed one by one by the queue.
+func (d *DLPTH1C) Transact(cmd []byte, priority Priority) ([]byte, error) {
+	result := d.transact(cmd, priority, false)
+	return result.b, result.err
+}
+
+func (d *DLPTH1C) transact(cmd []byte, priority Priority, flush bool) *transactionResult {
+	t := &transaction{
+		cmd:      cmd,
+		timeout:  d.timeoutOf(cmd),
+		response: make(chan *transactionResult, 1),
+		flush:    flush,
+	}
+
+	queue := d.normal
+	if priority == HighPriority {
+		queue = d.high
+	}
+
+	select {
+	case queue <- t:
+	case <-d.done:
+		return &transactionResult{err: ClosedError()}
+	}
+
+	return <-t.response
+}
+
+// SetCommandTimeout changes the timeout of the command.
+// The timeout of a transaction that has several commands is the sum of each of them.
+func (d *DLPTH1C) SetCommandTimeout(cmd byte, timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.timeouts[cmd] = timeout
+}
+
+func (d *DLPTH1C) timeoutOf(cmd []byte) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// only reading (e.g. flush)
+	if len(cmd) == 0 {
+		return DefaultCommandTimeout
+	}
+
+	var timeout time.Duration
+	for _, c := range cmd {
+		if t, exist := d.timeouts[c]; exist {
+			timeout += t
+			continue
+		}
+
+		switch c {
+		case VibrationXASCIICmd, VibrationYASCIICmd, VibrationZASCIICmd, SoundASCIICmd:
+			timeout += SpectrumCommandTimeout
+		default:
+			timeout += DefaultCommandTimeout
+		}
+	}
+
+	return timeout
+}
+
+// serve executes the queued transactions until the DLPTH1C is closed.
+// High priority transactions are always taken first.
+func (d *DLPTH1C) serve() {
+	defer close(d.served)
+
+	for {
+		select {
+		case t := <-d.high:
+			d.execute(t)
+			continue
+		default:
+		}
+
+		select {
+		case t := <-d.high:
+			d.execute(t)
+		case t := <-d.normal:
+			d.execute(t)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *DLPTH1C) execute(t *transaction) {
+	result := new(transactionResult)
+	written := 0
+	defer func() {
+		d.stats.recordTransaction(t.cmd, written, result)
+		t.response <- result
+	}()
+
+	deadline := time.Now().Add(t.timeout)
+
+	// Discard the bytes left from the previous response (see ./sync.go)
+	// A transaction without command only reads, which is already a flush.
+	if len(t.cmd) > 0 && (t.flush || d.flushesBeforeCommand()) {
+		discarded, _, err := d.readResponse(deadline, false)
+		d.stats.recordDiscard(len(discarded))
+		if err != nil {
+			result.err = err
+			return
+		}
+		deadline = time.Now().Add(t.timeout)
+	}
+
+	if len(t.cmd) > 0 {
+		written, result.err = d.vcp.Write(t.cmd)
+		if result.err != nil {
+			return
+		}
+	}
+	result.sent = d.now()
+
+	result.b, result.received, result.err = d.readResponse(deadline, expectsResponse(t.cmd))
+	if result.received.IsZero() {
+		result.received = result.sent
+	}
+}
+
+// expectsResponse reports whether the sensor always replies to the command,
+// so its response is waited for until the timeout.
+// The other commands (e.g. the range) may be answered with nothing.
+func expectsResponse(cmd []byte) bool {
+	if len(cmd) == 0 {
+		return false
+	}
+
+	for _, c := range cmd {
+		if c != PingASCIICmd && SensorName(c) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// readResponse reads until the sensor stops sending (the port returns nothing for a while).
+// If wait is true, nothing from the port means the sensor has not started sending yet,
+// and it keeps reading until the first byte or the deadline.
+// It returns the bytes and the time when the last byte was read.
+func (d *DLPTH1C) readResponse(deadline time.Time, wait bool) (b []byte, received time.Time, err error) {
+	// Assign byte array that will be given the sensor data
+	b = make([]byte, 0)
+	// Assigns 1 byte array where bytes from the port will be stored
+	buff := make([]byte, 1)
+
+	// Read from response
+	for {
+		// The sensor is silent, or keeps sending something too late to be the response.
+		// It is checked before every read, whether the previous one returned something or not.
+		if time.Now().After(deadline) {
+			return b, received, TimeoutError()
+		}
+
+		n, err := d.vcp.Read(buff)
+		if n > 0 {
+			b = append(b, buff[:n]...)
+			received = d.now()
+		}
+		if err != nil && err != io.EOF {
+			return b, received, err
+		}
+
+		if n == 0 && (len(b) > 0 || !wait) {
+			return b, received, nil
+		}
+	}
+}
+
+// Close stops the queue, closes the port and releases its lock.
+// The transaction being executed is finished before the port is closed.
+func (d *DLPTH1C) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+		<-d.served
+
+		d.closeErr = d.vcp.Close()
+		d.lock.release()
+	})
+
+	return d.closeErr
+}
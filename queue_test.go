@@ -0,0 +1,158 @@
+package serial
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePort answers the commands written to it like the sensor does.
+// Each command has a list of responses which are used one by one, and the last one is repeated.
+// A command without response is not answered at all (the port stays silent).
+type fakePort struct {
+	mu        sync.Mutex
+	responses map[byte][]string
+	pending   []byte
+	written   []byte
+	// reads which returned nothing (the end of a response or a flush)
+	emptyReads int
+}
+
+func newFakePort(responses map[byte]string) *fakePort {
+	p := &fakePort{responses: make(map[byte][]string)}
+	for cmd, response := range responses {
+		p.responses[cmd] = []string{response}
+	}
+	return p
+}
+
+// then makes the command answered by the responses (in order) from now on.
+func (p *fakePort) then(cmd byte, responses ...string) *fakePort {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.responses[cmd] = responses
+	return p
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, cmd := range b {
+		p.written = append(p.written, cmd)
+
+		responses := p.responses[cmd]
+		if len(responses) == 0 {
+			continue
+		}
+		p.pending = append(p.pending, responses[0]...)
+		if len(responses) > 1 {
+			p.responses[cmd] = responses[1:]
+		}
+	}
+	return len(b), nil
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.pending) == 0 {
+		p.emptyReads++
+		return 0, io.EOF
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *fakePort) Close() error {
+	return nil
+}
+
+func (p *fakePort) commands() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return string(p.written)
+}
+
+func (p *fakePort) reads() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.emptyReads
+}
+
+func TestTransactTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     byte
+		answer  bool
+		want    string
+		wantErr error
+	}{
+		{"answered", TemperatureASCIICmd, true, temperatureResponse, nil},
+		// the sensor is silent, so it times out without any byte
+		{"silent sensor", TemperatureASCIICmd, false, "", TimeoutError()},
+		// the range commands may not be answered, so nothing is the response
+		{"no answer expected", Set8GASCIICmd, false, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := map[byte]string{}
+			if tt.answer {
+				responses[tt.cmd] = sensorResponses[tt.cmd]
+			}
+			d := NewDLPTH1CFromPort("fake", newFakePort(responses))
+			defer d.Close()
+			d.SetCommandTimeout(tt.cmd, 50*time.Millisecond)
+
+			start := time.Now()
+			b, err := d.Transact([]byte{tt.cmd}, NormalPriority)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if string(b) != tt.want {
+				t.Errorf("response = %q, want %q", b, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestTransactPriority(t *testing.T) {
+	port := newFakePort(sensorResponses)
+	d := NewDLPTH1CFromPort("fake", port)
+	defer d.Close()
+
+	// every transaction gets its own response, even if they are sent at the same time
+	var wg sync.WaitGroup
+	for _, cmd := range SensorASCIICmds {
+		cmd := cmd
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			b, err := d.Transact([]byte{cmd}, NormalPriority)
+			if err != nil {
+				t.Errorf("%s: %v", SensorName(cmd), err)
+			}
+			if string(b) != sensorResponses[cmd] {
+				t.Errorf("%s: response = %q", SensorName(cmd), b)
+			}
+		}()
+	}
+	wg.Wait()
+
+	d.Close()
+	if _, err := d.Transact([]byte{PingASCIICmd}, HighPriority); !errors.Is(err, ClosedError()) {
+		t.Errorf("after Close: err = %v, want %v", err, ClosedError())
+	}
+}
@@ -0,0 +1,42 @@
+// Define raw mode which keeps the exact responses in this file
+package serial
+
+// SetRawMode makes the read functions keep the exact bytes of each response
+// in TimeSeriesData.Raw, so they can be archived and parsed again later.
+func (d *DLPTH1C) SetRawMode(raw bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.rawMode = raw
+}
+
+func (d *DLPTH1C) rawModeEnabled() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.rawMode
+}
+
+// ParseRaw parses the raw responses of the TimeSeriesData again
+// and returns new TimeSeriesData that has the same Time, Timing and Raw.
+// The responses that still couldn't be parsed are left out, and the last error is returned.
+func ParseRaw(timeSeriesData *TimeSeriesData) (*TimeSeriesData, error) {
+	result := &TimeSeriesData{
+		Time:   timeSeriesData.Time,
+		Data:   make(map[byte]SensorData, len(timeSeriesData.Raw)),
+		Timing: timeSeriesData.Timing,
+		Raw:    timeSeriesData.Raw,
+	}
+
+	var lastErr error
+	for cmd, raw := range timeSeriesData.Raw {
+		data, err := parseResponse(cmd, string(raw))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		result.Data[cmd] = data
+	}
+
+	return result, lastErr
+}
@@ -0,0 +1,48 @@
+package serial
+
+import (
+	"testing"
+)
+
+func TestRawMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      byte
+		response string
+		// the data left after parsing and after ParseRaw
+		wantData bool
+	}{
+		{"parsed", TiltASCIICmd, tiltResponse, true},
+		// the raw response is kept even if it couldn't be parsed
+		{"not parsed", TiltASCIICmd, "X:10 Y:", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := newFakePort(sensorResponses).then(tt.cmd, tt.response)
+			d := NewDLPTH1CFromPort("fake", port)
+			defer d.Close()
+			d.SetRawMode(true)
+			d.SetRetryPolicy(tt.cmd, RetryPolicy{Attempts: 1})
+
+			result, err := d.Read(tt.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(result.Raw[tt.cmd]) != tt.response {
+				t.Errorf("raw = %q, want %q", result.Raw[tt.cmd], tt.response)
+			}
+			if _, exist := result.Data[tt.cmd]; exist != tt.wantData {
+				t.Errorf("data = %v, want data %v", result.Data[tt.cmd], tt.wantData)
+			}
+
+			parsed, err := ParseRaw(result)
+			if (err == nil) != tt.wantData {
+				t.Errorf("ParseRaw: err = %v", err)
+			}
+			if _, exist := parsed.Data[tt.cmd]; exist != tt.wantData {
+				t.Errorf("ParseRaw: data = %v, want data %v", parsed.Data[tt.cmd], tt.wantData)
+			}
+		})
+	}
+}
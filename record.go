@@ -0,0 +1,230 @@
+// Define recording and replay transports of serial sessions in this file
+package serial
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Operations of RecordedEvent
+const (
+	RecordedWrite string = "write"
+	RecordedRead  string = "read"
+)
+
+// RecordedEvent is one call of Write or Read on the port.
+// A recording is a file of RecordedEvent encoded as JSON, one per line.
+type RecordedEvent struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"`
+	Data []byte    `json:"data,omitempty"`
+	// EOF means the read returned nothing (the sensor stopped sending)
+	EOF bool `json:"eof,omitempty"`
+	// Err is the other error that the call returned
+	Err string `json:"err,omitempty"`
+}
+
+// RecordingPort wraps the port of DLPTH1C and logs every write and read with timestamps.
+type RecordingPort struct {
+	port      io.ReadWriteCloser
+	recording io.Writer
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecordingPort returns the port which writes the recording into the writer.
+// If the writer is also an io.Closer, it is closed together with the port.
+func NewRecordingPort(port io.ReadWriteCloser, recording io.Writer) *RecordingPort {
+	return &RecordingPort{
+		port:      port,
+		recording: recording,
+		enc:       json.NewEncoder(recording),
+	}
+}
+
+// RecordToFile opens the port of DLPTH1C with the same lock as OpenDLPTH1C, and records the session into the file.
+func RecordToFile(portName string, path string) (*DLPTH1C, error) {
+	port, lock, err := openLockedPort(portName)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		port.Close()
+		lock.release()
+		return nil, err
+	}
+
+	d := newDLPTH1C(portName, NewRecordingPort(port, f))
+	d.lock = lock
+	return d, nil
+}
+
+func (r *RecordingPort) Write(b []byte) (int, error) {
+	n, err := r.port.Write(b)
+	r.record(RecordedWrite, b[:n], err)
+	return n, err
+}
+
+func (r *RecordingPort) Read(b []byte) (int, error) {
+	n, err := r.port.Read(b)
+	r.record(RecordedRead, b[:n], err)
+	return n, err
+}
+
+func (r *RecordingPort) Close() error {
+	err := r.port.Close()
+
+	if closer, ok := r.recording.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (r *RecordingPort) record(op string, b []byte, err error) {
+	event := RecordedEvent{
+		Time: time.Now(),
+		Op:   op,
+		Data: append([]byte(nil), b...),
+	}
+	if err == io.EOF {
+		event.EOF = true
+	} else if err != nil {
+		event.Err = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The session goes on even if the recording fails
+	r.enc.Encode(event)
+}
+
+// ReplayPort feeds a recording back into DLPTH1C instead of the sensor.
+// The commands written by DLPTH1C must be the same as the recorded ones.
+// It is also a Clock that returns the recorded time of the last event,
+// so timestamps are reproduced exactly by d.SetClock(replayPort).
+type ReplayPort struct {
+	mu     sync.Mutex
+	events []RecordedEvent
+	next   int
+	now    time.Time
+}
+
+// NewReplayPort loads the whole recording.
+func NewReplayPort(recording io.Reader) (*ReplayPort, error) {
+	r := new(ReplayPort)
+
+	dec := json.NewDecoder(recording)
+	for {
+		var event RecordedEvent
+		if err := dec.Decode(&event); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		r.events = append(r.events, event)
+	}
+
+	if len(r.events) > 0 {
+		r.now = r.events[0].Time
+	}
+	return r, nil
+}
+
+// ReplayFile returns DLPTH1C that replays the recording file.
+func ReplayFile(path string) (*DLPTH1C, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	replay, err := NewReplayPort(f)
+	if err != nil {
+		return nil, err
+	}
+
+	d := NewDLPTH1CFromPort(path, replay)
+	d.SetClock(replay)
+	return d, nil
+}
+
+func (r *ReplayPort) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// reads which have not been replayed are skipped
+	for r.next < len(r.events) && r.events[r.next].Op != RecordedWrite {
+		r.next++
+	}
+	if r.next >= len(r.events) {
+		return 0, ReplayFinishedError()
+	}
+
+	event := r.events[r.next]
+	r.next++
+	r.now = event.Time
+
+	if !bytes.Equal(event.Data, b) {
+		return 0, ReplayMismatchError()
+	}
+	return len(b), event.error()
+}
+
+func (r *ReplayPort) Read(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next >= len(r.events) {
+		return 0, ReplayFinishedError()
+	}
+	// The recorded reads of the response are used up. The session kept reading
+	// only because the response had not ended before the timeout (see readResponse).
+	if r.events[r.next].Op != RecordedRead {
+		return 0, TimeoutError()
+	}
+
+	event := &r.events[r.next]
+	r.now = event.Time
+
+	// The buffer could be smaller than the recorded read
+	n := copy(b, event.Data)
+	event.Data = event.Data[n:]
+	if len(event.Data) > 0 {
+		return n, nil
+	}
+
+	r.next++
+	return n, event.error()
+}
+
+func (r *ReplayPort) Close() error {
+	return nil
+}
+
+// Now returns the recorded time of the last replayed event.
+func (r *ReplayPort) Now() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.now
+}
+
+func (event *RecordedEvent) error() error {
+	if event.EOF {
+		return io.EOF
+	}
+	if event.Err != "" {
+		return ReplayedError(event.Err)
+	}
+	return nil
+}
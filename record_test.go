@@ -0,0 +1,132 @@
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	// tilt fails once, so the recording has the resync and the retry
+	port := newFakePort(sensorResponses).then(TiltASCIICmd, "X:10 Y:", tiltResponse)
+
+	var recording bytes.Buffer
+	d := NewDLPTH1CFromPort("fake", NewRecordingPort(port, &recording))
+	d.SetRawMode(true)
+	live, err := d.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	replay := func() *TimeSeriesData {
+		replayPort, err := NewReplayPort(bytes.NewReader(recording.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewDLPTH1CFromPort("replay", replayPort)
+		defer d.Close()
+		d.SetClock(replayPort)
+		d.SetRawMode(true)
+
+		result, err := d.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the recording is used up
+		if _, err := d.ReadAll(); err == nil || err.Error() != ReplayFinishedError().Error() {
+			t.Errorf("after the recording: err = %v", err)
+		}
+		return result
+	}
+
+	first, second := replay(), replay()
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("replays differ:\n%+v\n%+v", first, second)
+	}
+	if !reflect.DeepEqual(first.Data, live.Data) {
+		t.Errorf("data = %+v, want %+v", first.Data, live.Data)
+	}
+	if !reflect.DeepEqual(first.Raw, live.Raw) {
+		t.Errorf("raw = %q, want %q", first.Raw, live.Raw)
+	}
+
+	// the times are the recorded ones
+	for cmd, timing := range first.Timing {
+		liveTiming := live.Timing[cmd]
+		if diff := timing.Sent.Sub(liveTiming.Sent); diff > 0 || diff < -time.Second {
+			t.Errorf("%s: sent %v, recorded %v", SensorName(cmd), timing.Sent, liveTiming.Sent)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name      string
+		recording []string
+		cmd       byte
+		want      string
+		wantErr   error
+	}{
+		{
+			name: "response",
+			recording: []string{
+				`{"time":"2026-01-01T00:00:00Z","op":"write","data":"dA=="}`,
+				`{"time":"2026-01-01T00:00:01Z","op":"read","data":"MjM="}`,
+				`{"time":"2026-01-01T00:00:02Z","op":"read","eof":true}`,
+			},
+			cmd:  TemperatureASCIICmd,
+			want: "23",
+		},
+		{
+			// the session read again only because the timeout had not passed
+			name: "timed out",
+			recording: []string{
+				`{"time":"2026-01-01T00:00:00Z","op":"write","data":"dA=="}`,
+				`{"time":"2026-01-01T00:00:01Z","op":"read","eof":true}`,
+				`{"time":"2026-01-01T00:00:02Z","op":"read","eof":true}`,
+				`{"time":"2026-01-01T00:00:05Z","op":"write","data":"Jw=="}`,
+			},
+			cmd:     TemperatureASCIICmd,
+			wantErr: TimeoutError(),
+		},
+		{
+			name: "different command",
+			recording: []string{
+				`{"time":"2026-01-01T00:00:00Z","op":"write","data":"dA=="}`,
+			},
+			cmd:     HumidityASCIICmd,
+			wantErr: ReplayMismatchError(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replayPort, err := NewReplayPort(strings.NewReader(strings.Join(tt.recording, "\n")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := NewDLPTH1CFromPort("replay", replayPort)
+			defer d.Close()
+			d.SetClock(replayPort)
+
+			b, err := d.Transact([]byte{tt.cmd}, NormalPriority)
+			if tt.wantErr != nil {
+				if err == nil || (!errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error()) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("response = %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
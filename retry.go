@@ -0,0 +1,65 @@
+// Define retry policy of the sensor requests in this file
+package serial
+
+import "time"
+
+// RetryPolicy decides how a failed request (no response, timeout or parse error) is retried.
+type RetryPolicy struct {
+	// Attempts is the number of requests including the first one.
+	// 1 (or less) means the request is never retried.
+	Attempts int
+	// Delay is the time to wait between attempts.
+	Delay time.Duration
+	// Flush discards the bytes left in the input between attempts,
+	// so they are not read as the start of the next response.
+	Flush bool
+}
+
+// It is used for the commands that have not been set by SetRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts: 3,
+	Delay:    100 * time.Millisecond,
+	Flush:    true,
+}
+
+// SetRetryPolicy changes the retry policy of the command.
+func (d *DLPTH1C) SetRetryPolicy(cmd byte, policy RetryPolicy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.retryPolicies[cmd] = policy
+}
+
+func (d *DLPTH1C) retryPolicyOf(cmd byte) RetryPolicy {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if policy, exist := d.retryPolicies[cmd]; exist {
+		return policy
+	}
+	return DefaultRetryPolicy
+}
+
+// wait is called between attempts.
+// The delay is measured by the clock of the DLPTH1C, and Close stops it.
+// The input is not flushed again if resync has already discarded it after the failure.
+func (policy RetryPolicy) wait(d *DLPTH1C, resynced bool) error {
+	if policy.Delay > 0 {
+		select {
+		case <-d.after(policy.Delay):
+		case <-d.done:
+			return ClosedError()
+		}
+	}
+
+	if policy.Flush && !resynced {
+		return d.flush()
+	}
+	return nil
+}
+
+// flush discards the input until the sensor stops sending.
+func (d *DLPTH1C) flush() error {
+	// A transaction without command only reads
+	return d.transact(nil, HighPriority, false).err
+}
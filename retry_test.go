@@ -0,0 +1,99 @@
+package serial
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		responses []string
+		want      SensorData
+		// requests of the command, and retries counted by the stats
+		wantRequests uint64
+		wantRetries  uint64
+	}{
+		{
+			name:         "first attempt",
+			policy:       RetryPolicy{Attempts: 3},
+			responses:    []string{temperatureResponse},
+			want:         TemperatureData(23.45),
+			wantRequests: 1,
+		},
+		{
+			name:         "recovered by retry",
+			policy:       RetryPolicy{Attempts: 3, Flush: true},
+			responses:    []string{"Temperat", temperatureResponse},
+			want:         TemperatureData(23.45),
+			wantRequests: 2,
+			wantRetries:  1,
+		},
+		{
+			name:         "attempts used up",
+			policy:       RetryPolicy{Attempts: 3},
+			responses:    []string{"Temperat"},
+			want:         TemperatureData(ParseErrorCodeDLPTH1C),
+			wantRequests: 3,
+			wantRetries:  2,
+		},
+		{
+			name:         "never retried",
+			policy:       RetryPolicy{Attempts: 1},
+			responses:    []string{"Temperat", temperatureResponse},
+			want:         TemperatureData(ParseErrorCodeDLPTH1C),
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := newFakePort(sensorResponses).then(TemperatureASCIICmd, tt.responses...)
+			d := NewDLPTH1CFromPort("fake", port)
+			defer d.Close()
+			d.SetRetryPolicy(TemperatureASCIICmd, tt.policy)
+
+			result, err := d.Read(TemperatureASCIICmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Data[TemperatureASCIICmd] != tt.want {
+				t.Errorf("data = %v, want %v", result.Data[TemperatureASCIICmd], tt.want)
+			}
+
+			stats := d.Stats()
+			if stats.Requests[TemperatureASCIICmd] != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", stats.Requests[TemperatureASCIICmd], tt.wantRequests)
+			}
+			if stats.Retries != tt.wantRetries {
+				t.Errorf("retries = %d, want %d", stats.Retries, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestRetryDelayStoppedByClose(t *testing.T) {
+	port := newFakePort(sensorResponses).then(TemperatureASCIICmd, "Temperat")
+	d := NewDLPTH1CFromPort("fake", port)
+	d.SetRetryPolicy(TemperatureASCIICmd, RetryPolicy{Attempts: 2, Delay: time.Hour})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.Read(TemperatureASCIICmd)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	d.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ClosedError()) {
+			t.Errorf("err = %v, want %v", err, ClosedError())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the delay is not stopped by Close")
+	}
+}
@@ -1,8 +1,15 @@
 package serial
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // set custom option value as false
@@ -13,6 +20,21 @@ func usage() {
 	fmt.Printf("\n===============================================================\n")
 	fmt.Printf("USAGE: run with COMMAND\n")
 	fmt.Printf("all:\t\t\t\tRead All Data\n")
+	fmt.Printf("diag [ROUNDS|DURATION]:\t\tRun Soak Test and Print Data Loss Report\n")
+	fmt.Printf("shell:\t\t\t\tSend Commands Interactively\n")
+	fmt.Printf("dash:\t\t\t\tShow Live Dashboard of All Data\n")
+	fmt.Printf("metrics [ADDR]:\t\t\tServe Prometheus Metrics (default %s)\n", DefaultMetricsAddr)
+	fmt.Printf("mqtt BROKER [ha]:\t\tPublish All Data to MQTT Broker (e.g. localhost:1883)\n")
+	fmt.Printf("\t\t\t\twith Home Assistant Discovery if \"ha\" is given\n")
+	fmt.Printf("influx URL ORG BUCKET:\t\tWrite All Data to InfluxDB (token from INFLUX_TOKEN)\n")
+	fmt.Printf("\t\t\t\t(mqtt and influx queue on disk if DLPTH1C_QUEUE_DIR is set)\n")
+	fmt.Printf("store DIR [MAXAGE]:\t\tStore All Data in Files (e.g. \"./data 720h\")\n")
+	fmt.Printf("api [ADDR] [DIR]:\t\tServe REST API and Stream (default %s)\n", DefaultAPIAddr)
+	fmt.Printf("\t\t\t\twith History if DIR to store data is given\n")
+	fmt.Printf("daemon [SOCKET] [GROUP]:\tShare the Sensor over Unix Socket (default %s)\n", DefaultSocketPath)
+	fmt.Printf("\t\t\t\twhich only the user and GROUP can connect to\n")
+	fmt.Printf("config FILE:\t\t\tRun Devices and Outputs of JSON Config File\n")
+	fmt.Printf("\t\t\t\t(SIGHUP reloads it)\n")
 	fmt.Printf("(COMBINE BELOW COMMANDS):\tRead Costomized Data but takes 30 secs\n")
 	fmt.Printf("t:\t\t\t\tRead Temperature Data Only\n")
 	fmt.Printf("h:\t\t\t\tRead Humidity Data Only\n")
@@ -31,13 +53,56 @@ func InitPort(initPort string) {
 	port = initPort
 }
 
-func RunWithCommand(cmd string) {
+// args are only used by the modes that need them (e.g. "diag 100").
+func RunWithCommand(cmd string, args ...string) {
+	// The config has its own devices
+	if cmd == "config" {
+		if len(args) == 0 {
+			usage()
+			return
+		}
+		if err := RunConfig(args[0]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// portName must be according to your environment.
 	// use "ll /dev/tty*" to see all the serial port.
 	d := NewDLPTH1C(port)
 
 	// Make sure to close it later.
-	defer d.vcp.Close()
+	// From here the errors are logged and returned, since log.Fatal would skip it and leave the lock file.
+	defer d.Close()
+
+	// SIGINT or SIGTERM closes the DLPTH1C, which finishes the transaction being executed.
+	// Then the read function returns and closes the channel, so the consumer can finish
+	// (e.g. flush its sinks) and RunWithCommand returns with the summary.
+	// The summary is printed only if a signal stopped it, not when cancel is deferred.
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	var interrupted atomic.Bool
+	defer func() {
+		if interrupted.Load() {
+			printSummary(d.Stats(), time.Since(start))
+		}
+	}()
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			// the second signal is not caught, in case the shutdown hangs
+			signal.Stop(signals)
+			log.Printf("%v: shutting down", sig)
+			interrupted.Store(true)
+			cancel()
+			d.Close()
+		case <-ctx.Done():
+		}
+	}()
 
 	// change the option to call these functions below.
 	// d.set2G()
@@ -46,53 +111,181 @@ func RunWithCommand(cmd string) {
 	// d.set16G()
 
 	// the type of chan must be same as the return type of the function what you call.
+	// It is closed by the read function (see produce).
 	var in chan *TimeSeriesData = make(chan *TimeSeriesData)
-	defer close(in)
 
 	if len(cmd) == 0 {
 		usage()
 		return
 
+	} else if cmd == "diag" {
+		// Run soak test
+		if err := runDiag(d, args...); err != nil && !errors.Is(err, ClosedError()) {
+			log.Print(err)
+		}
+		return
+
+	} else if cmd == "dash" {
+		// Read all and redraw the dashboard
+		produce(d, d.readAllAsync, in)
+		runDashboard(d.portName, in, os.Stdout)
+		return
+
+	} else if cmd == "metrics" {
+		// Read all and serve them on /metrics
+		addr := DefaultMetricsAddr
+		if len(args) > 0 {
+			addr = args[0]
+		}
+
+		produce(d, d.readAllAsync, in)
+		if err := runMetrics(d, in, addr); err != nil {
+			log.Print(err)
+		}
+		return
+
+	} else if cmd == "mqtt" {
+		// Read all and publish them
+		if len(args) == 0 {
+			usage()
+			return
+		}
+
+		homeAssistant := len(args) > 1 && args[1] == "ha"
+
+		produce(d, d.readAllAsync, in)
+		if err := runMQTT(d.portName, in, args[0], homeAssistant, os.Getenv("DLPTH1C_QUEUE_DIR")); err != nil {
+			log.Print(err)
+		}
+		return
+
+	} else if cmd == "influx" {
+		// Read all and write them
+		if len(args) < 3 {
+			usage()
+			return
+		}
+
+		produce(d, d.readAllAsync, in)
+		opts := InfluxOptions{URL: args[0], Org: args[1], Bucket: args[2], Token: os.Getenv("INFLUX_TOKEN")}
+		if err := runInflux(d.portName, in, opts, os.Getenv("DLPTH1C_QUEUE_DIR")); err != nil {
+			log.Print(err)
+		}
+		return
+
+	} else if cmd == "store" {
+		// Read all and store them
+		if len(args) == 0 {
+			usage()
+			return
+		}
+
+		var opts StoreOptions
+		if len(args) > 1 {
+			maxAge, err := time.ParseDuration(args[1])
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			opts.MaxAge = maxAge
+		}
+
+		produce(d, d.readAllAsync, in)
+		if err := runStore(d.portName, in, args[0], opts); err != nil {
+			log.Print(err)
+		}
+		return
+
+	} else if cmd == "api" {
+		// Read all and serve them over HTTP
+		addr := DefaultAPIAddr
+		if len(args) > 0 {
+			addr = args[0]
+		}
+		dir := ""
+		if len(args) > 1 {
+			dir = args[1]
+		}
+
+		produce(d, d.readAllAsync, in)
+		if err := runAPI(ctx, d, in, addr, dir); err != nil {
+			log.Print(err)
+		}
+		return
+
+	} else if cmd == "daemon" {
+		// Own the port and serve it to other processes
+		path := DefaultSocketPath
+		if len(args) > 0 {
+			path = args[0]
+		}
+		group := ""
+		if len(args) > 1 {
+			group = args[1]
+		}
+
+		produce(d, d.readAllAsync, in)
+		if err := runDaemon(ctx, d, in, path, group); err != nil {
+			log.Print(err)
+		}
+		return
+
+	} else if cmd == "shell" {
+		// Send commands by hand
+		// The shell is waiting for the input, so the signals are left to the default.
+		signal.Stop(signals)
+		if err := runShell(d, os.Stdin, os.Stdout); err != nil {
+			log.Print(err)
+		}
+		return
+
 	} else if len(cmd) > 10 {
-		log.Fatal("TOO MANY ARGUMENTS...")
+		log.Print("TOO MANY ARGUMENTS...")
+		return
 
 	} else {
 		if cmd == "all" {
 			// Read all
-			go d.readAllAsync(in)
+			produce(d, d.readAllAsync, in)
 
 		} else if len(cmd) == 1 {
 			// Select one function by the command that had been selected by user.
 			switch cmd {
 			case string(TemperatureASCIICmd):
-				go d.readTemperatureAsync(in)
+				produce(d, d.readTemperatureAsync, in)
 
 			case string(HumidityASCIICmd):
-				go d.readHumidityAsync(in)
+				produce(d, d.readHumidityAsync, in)
 
 			case string(PressureASCIICmd):
-				go d.readPressureAsync(in)
+				produce(d, d.readPressureAsync, in)
 
 			case string(TiltASCIICmd):
-				go d.readTiltAsync(in)
+				produce(d, d.readTiltAsync, in)
 
 			case string(VibrationXASCIICmd):
-				go d.readVibrationAsync(VibrationXASCIICmd, in)
+				produce(d, func(out chan<- *TimeSeriesData) error {
+					return d.readVibrationAsync(VibrationXASCIICmd, out)
+				}, in)
 
 			case string(VibrationYASCIICmd):
-				go d.readVibrationAsync(VibrationYASCIICmd, in)
+				produce(d, func(out chan<- *TimeSeriesData) error {
+					return d.readVibrationAsync(VibrationYASCIICmd, out)
+				}, in)
 
 			case string(VibrationZASCIICmd):
-				go d.readVibrationAsync(VibrationZASCIICmd, in)
+				produce(d, func(out chan<- *TimeSeriesData) error {
+					return d.readVibrationAsync(VibrationZASCIICmd, out)
+				}, in)
 
 			case string(LightASCIICmd):
-				go d.readLightAsync(in)
+				produce(d, d.readLightAsync, in)
 
 			case string(SoundASCIICmd):
-				go d.readSoundAsync(in)
+				produce(d, d.readSoundAsync, in)
 
 			case string(BroadbandASCIICmd):
-				go d.readBroadbandAsync(in)
+				produce(d, d.readBroadbandAsync, in)
 
 			default:
 				usage()
@@ -105,8 +298,15 @@ func RunWithCommand(cmd string) {
 			// But imagine how the custom option could be,
 			// The value of the sensor responds is too variable to expect every kind of format, exception, data loss as well.
 			// So it is decided to call readAllAsync(chan) function and just extract only the kind of data that user wants.
+			for _, c := range []byte(cmd) {
+				if SensorName(c) == "" {
+					log.Printf("%+v IS A WRONG ARGUMENT...", string(c))
+					return
+				}
+			}
+
 			option = true
-			go d.readAllAsync(in)
+			produce(d, d.readAllAsync, in)
 		}
 	}
 
@@ -116,11 +316,10 @@ func RunWithCommand(cmd string) {
 		for timeSeriesData := range in {
 			for _, c := range []byte(cmd) {
 				// data extracting
-				if _, exist := timeSeriesData.Data[c]; !exist {
-					log.Fatalf("%+v IS A WRONG ARGUMENT...", string(c))
+				// the data which couldn't be parsed is left out
+				if data, exist := timeSeriesData.Data[c]; exist {
+					data.print(os.Stdout)
 				}
-
-				timeSeriesData.Data[c].print()
 			}
 
 			fmt.Printf("Time: %+v\n\n", timeSeriesData.Time)
@@ -129,10 +328,41 @@ func RunWithCommand(cmd string) {
 		// It will be called when user executes "all" command or only 1 kind of data command
 		for timeSeriesData := range in {
 			for _, data := range timeSeriesData.Data {
-				data.print()
+				data.print(os.Stdout)
 			}
 
 			fmt.Printf("Time: %+v\n\n", timeSeriesData.Time)
 		}
 	}
 }
+
+// produce runs the read function in a goroutine, and closes the channel when it returns
+// (e.g. the DLPTH1C is closed by a signal), so the consumer can finish.
+// The data left in the output buffer (see SetOutputBuffer) are sent before it is closed.
+func produce(d *DLPTH1C, read func(out chan<- *TimeSeriesData) error, in chan<- *TimeSeriesData) {
+	go func() {
+		defer close(in)
+		defer d.flushOutput(in)
+
+		if err := read(in); err != nil && !errors.Is(err, ClosedError()) {
+			log.Print(err)
+		}
+	}()
+}
+
+// printSummary prints the samples collected and the errors encountered until the shutdown.
+func printSummary(stats Stats, elapsed time.Duration) {
+	var samples, parseFailures, dropped uint64
+	for _, cmd := range SensorASCIICmds {
+		samples += stats.Samples[cmd]
+		parseFailures += stats.ParseFailures[cmd]
+		dropped += stats.Dropped[cmd]
+	}
+
+	fmt.Printf("\n===============================================================\n")
+	fmt.Printf("SUMMARY: %v\n\n", elapsed.Round(time.Second))
+	fmt.Printf("samples: %d, parse failures: %d, dropped: %d\n", samples, parseFailures, dropped)
+	fmt.Printf("timeouts: %d, retries: %d, resyncs: %d (failed %d)\n",
+		stats.Timeouts, stats.Retries, stats.Resyncs, stats.ResyncFailures)
+	fmt.Printf("===============================================================\n\n")
+}
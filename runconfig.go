@@ -0,0 +1,424 @@
+// Define the runner of the configuration file in this file
+package serial
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// openConfigDevice opens the devices of the config (replaced by a fake port in the tests)
+var openConfigDevice func(portName string) (*DLPTH1C, error) = OpenDLPTH1C
+
+// Time to wait for the HTTP servers to finish the requests when they are stopped
+const ShutdownTimeout time.Duration = 5 * time.Second
+
+// RunConfig runs the devices, the outputs and the alert rules of the config file (see LoadConfig).
+// SIGHUP reloads the file: the current config is kept if the new one is invalid,
+// and it is restored if the new one could not be started (e.g. a port is missing).
+// SIGINT or SIGTERM stops it gracefully, like RunWithCommand.
+func RunConfig(path string) error {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	running, err := startConfig(config)
+	if err != nil {
+		return err
+	}
+
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			// the second signal is not caught, in case the shutdown hangs
+			signal.Stop(signals)
+			log.Printf("%v: shutting down", sig)
+			running.stop()
+			return nil
+		}
+
+		log.Printf("%v: reloading %s", sig, path)
+		if config, running, err = reloadConfig(path, config, running); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reloadConfig replaces the running config by the file, and returns the config which is running then.
+// It returns an error only if neither the new nor the previous config could be started.
+func reloadConfig(path string, config *Config, running *configRuntime) (*Config, *configRuntime, error) {
+	reloaded, err := LoadConfig(path)
+	if err != nil {
+		log.Printf("reload: %v (the current config is kept)", err)
+		return config, running, nil
+	}
+
+	running.stop()
+	if running, err = startConfig(reloaded); err != nil {
+		log.Printf("reload: %v (the previous config is restored)", err)
+		if running, err = startConfig(config); err != nil {
+			return nil, nil, err
+		}
+		return config, running, nil
+	}
+	return reloaded, running, nil
+}
+
+// configRuntime is everything started by a config.
+type configRuntime struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	start  time.Time
+
+	devices []*configDevice
+	// nil if the config has no alert rules
+	alerts *AlertEngine
+
+	// the consumers of the hubs, which finish after the devices are closed
+	wg sync.WaitGroup
+	// called after the consumers finished, in reverse order (e.g. stop the servers)
+	closers []func()
+}
+
+type configDevice struct {
+	config DeviceConfig
+	d      *DLPTH1C
+	hub    *Hub
+}
+
+func startConfig(config *Config) (*configRuntime, error) {
+	rt := &configRuntime{start: time.Now()}
+	rt.ctx, rt.cancel = context.WithCancel(context.Background())
+
+	for _, deviceConfig := range config.Devices {
+		device, err := rt.startDevice(deviceConfig)
+		if err != nil {
+			rt.stop()
+			return nil, err
+		}
+		rt.devices = append(rt.devices, device)
+	}
+
+	// the stores come first, so the api can serve the history from them
+	stores := make(map[string]*Store)
+	for _, output := range config.Outputs {
+		if output.Type != OutputStore {
+			continue
+		}
+		if err := rt.startStore(output, stores); err != nil {
+			rt.stop()
+			return nil, err
+		}
+	}
+	for _, output := range config.Outputs {
+		if output.Type == OutputStore {
+			continue
+		}
+		if err := rt.startOutput(output, stores); err != nil {
+			rt.stop()
+			return nil, err
+		}
+	}
+
+	if len(config.Alerts) > 0 {
+		rt.alerts = NewAlertEngine(config.Alerts)
+		for _, device := range rt.devices {
+			id := device.config.ID
+			rt.consume(device, func(timeSeriesData *TimeSeriesData) {
+				rt.alerts.Observe(id, timeSeriesData)
+			})
+		}
+	}
+
+	return rt, nil
+}
+
+func (rt *configRuntime) startDevice(config DeviceConfig) (*configDevice, error) {
+	portName, err := config.PortName()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := openConfigDevice(portName)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Range != 0 {
+		if err := d.SetRange(config.Range); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+	policy, _ := overflowPolicy(config.Overflow)
+	d.SetOutputBuffer(config.Buffer, policy)
+
+	device := &configDevice{config: config, d: d, hub: NewHub()}
+
+	in := make(chan *TimeSeriesData)
+	go device.hub.Run(context.Background(), in)
+
+	if config.Mode == SampleSchedule {
+		scheduler := NewScheduler(d)
+		for name, interval := range config.Sampling {
+			cmd, _ := SensorCmd(name)
+			scheduler.Every(cmd, time.Duration(interval))
+		}
+		produce(d, func(out chan<- *TimeSeriesData) error {
+			err := scheduler.Run(rt.ctx, out)
+			if rt.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}, in)
+	} else {
+		produce(d, d.readAllAsync, in)
+	}
+
+	return device, nil
+}
+
+// selected returns the devices of the output.
+func (rt *configRuntime) selected(output OutputConfig) []*configDevice {
+	if len(output.Devices) == 0 {
+		return rt.devices
+	}
+
+	var devices []*configDevice
+	for _, device := range rt.devices {
+		for _, id := range output.Devices {
+			if device.config.ID == id {
+				devices = append(devices, device)
+			}
+		}
+	}
+	return devices
+}
+
+func (rt *configRuntime) startStore(output OutputConfig, stores map[string]*Store) error {
+	store, err := OpenStore(output.Dir, StoreOptions{MaxAge: time.Duration(output.MaxAge), MaxSize: output.MaxSize})
+	if err != nil {
+		return err
+	}
+	rt.closers = append(rt.closers, func() { store.Close() })
+
+	for _, device := range rt.selected(output) {
+		id := device.config.ID
+		if _, exist := stores[id]; !exist {
+			stores[id] = store
+		}
+
+		// the store is shared by the devices, so it is closed by the closer
+		rt.pipe(device, PipelineOptions{}, SinkFunc(func(ctx context.Context, batch []*TimeSeriesData) error {
+			for _, timeSeriesData := range batch {
+				if err := store.Append(id, timeSeriesData); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	return nil
+}
+
+func (rt *configRuntime) startOutput(output OutputConfig, stores map[string]*Store) error {
+	devices := rt.selected(output)
+
+	switch output.Type {
+	case OutputMQTT:
+		for _, device := range devices {
+			publisher, err := NewMQTTPublisher(device.config.ID, MQTTOptions{
+				Broker:        output.Broker,
+				Username:      output.Username,
+				Password:      output.Password,
+				QoS:           output.QoS,
+				Retain:        output.Retain,
+				HomeAssistant: output.HomeAssistant,
+			})
+			if err != nil {
+				return err
+			}
+			if err := rt.pipeQueued(device, output, PipelineOptions{}, DefaultQueueBatchSize, publisher); err != nil {
+				publisher.Close()
+				return err
+			}
+		}
+
+	case OutputInflux:
+		for _, device := range devices {
+			writer := NewInfluxWriter(device.config.ID, InfluxOptions{
+				URL:      output.URL,
+				Org:      output.Org,
+				Bucket:   output.Bucket,
+				Token:    output.Token,
+				Database: output.Database,
+				Tags:     device.config.Labels,
+			})
+			if err := rt.pipeQueued(device, output, influxPipelineOptions(), DefaultInfluxBatchSize, writer); err != nil {
+				return err
+			}
+		}
+
+	case OutputMetrics:
+		exporter := NewExporter()
+		for _, device := range devices {
+			id := device.config.ID
+			exporter.Register(id, device.d)
+			rt.consume(device, func(timeSeriesData *TimeSeriesData) {
+				exporter.Observe(id, timeSeriesData)
+			})
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", exporter)
+		addr := output.Addr
+		if addr == "" {
+			addr = DefaultMetricsAddr
+		}
+		return rt.serve(&http.Server{Addr: addr, Handler: mux})
+
+	case OutputAPI:
+		api := NewAPIServer()
+		for _, device := range devices {
+			api.Register(device.config.ID, device.d, device.hub, stores[device.config.ID])
+		}
+
+		sec := APISecurity{
+			CertFile:            output.Cert,
+			KeyFile:             output.Key,
+			ClientCAFile:        output.ClientCA,
+			Tokens:              make(map[string]Scope),
+			AllowInsecureTokens: output.InsecureTokens,
+		}
+		if err := sec.addTokens(output.ReadTokens, output.ControlTokens); err != nil {
+			return err
+		}
+
+		addr := output.Addr
+		if addr == "" {
+			addr = DefaultAPIAddr
+		}
+		server, err := sec.NewServer(addr, api)
+		if err != nil {
+			return err
+		}
+		return rt.serve(server)
+
+	case OutputDaemon:
+		daemon := NewDaemon(devices[0].d, devices[0].hub)
+		socket := output.Socket
+		if socket == "" {
+			socket = DefaultSocketPath
+		}
+
+		go func() {
+			if err := daemon.ListenAndServe(socket, output.SocketGroup); err != nil && err != ClosedError() {
+				log.Printf("daemon %s: %v", socket, err)
+			}
+		}()
+		rt.closers = append(rt.closers, func() { daemon.Close() })
+	}
+
+	return nil
+}
+
+// pipe writes the data of the device to the sink until the device is stopped.
+func (rt *configRuntime) pipe(device *configDevice, opts PipelineOptions, sink Sink) {
+	// a slow sink loses the oldest data rather than delaying the others
+	sub := device.hub.Subscribe(SubscribeOptions{Policy: DropOldest})
+
+	rt.wg.Add(1)
+	go func() {
+		defer rt.wg.Done()
+		NewPipeline(opts, sink).Run(context.Background(), sub.C)
+	}()
+}
+
+// pipeQueued is pipe through a DiskQueue of the device in the queue directory of the output (if it is given).
+// The queue batches the data for the sink (batchSize), instead of the pipeline.
+func (rt *configRuntime) pipeQueued(device *configDevice, output OutputConfig, opts PipelineOptions, batchSize int, sink Sink) error {
+	if output.QueueDir == "" {
+		rt.pipe(device, opts, sink)
+		return nil
+	}
+
+	dir := filepath.Join(output.QueueDir, unsafeFileName.ReplaceAllString(device.config.ID, "_"))
+	queued, err := newQueuedSink(dir, DiskQueueOptions{MaxSize: output.QueueMaxSize}, batchSize, sink)
+	if err != nil {
+		return err
+	}
+	rt.pipe(device, PipelineOptions{}, queued)
+	return nil
+}
+
+// consume calls the function with every data of the device until the device is stopped.
+func (rt *configRuntime) consume(device *configDevice, f func(*TimeSeriesData)) {
+	sub := device.hub.Subscribe(SubscribeOptions{Policy: DropOldest})
+
+	rt.wg.Add(1)
+	go func() {
+		defer rt.wg.Done()
+		for timeSeriesData := range sub.C {
+			f(timeSeriesData)
+		}
+	}()
+}
+
+// serve listens now, so an address in use is an error of the config.
+func (rt *configRuntime) serve(server *http.Server) error {
+	l, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(l, "", "")
+		} else {
+			err = server.Serve(l)
+		}
+		if err != http.ErrServerClosed {
+			log.Printf("serve %s: %v", server.Addr, err)
+		}
+	}()
+
+	rt.closers = append(rt.closers, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	return nil
+}
+
+// stop closes the devices (the transactions being executed are finished),
+// waits for the outputs to flush, and prints the summary of each device.
+func (rt *configRuntime) stop() {
+	rt.cancel()
+	for _, device := range rt.devices {
+		device.d.Close()
+	}
+
+	rt.wg.Wait()
+	for i := len(rt.closers) - 1; i >= 0; i-- {
+		rt.closers[i]()
+	}
+
+	for _, device := range rt.devices {
+		log.Printf("%s (%s) stopped", device.config.ID, device.d.portName)
+		printSummary(device.d.Stats(), time.Since(rt.start))
+	}
+}
@@ -0,0 +1,150 @@
+// Define sampling scheduler in this file
+package serial
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Scheduler requests each sensor at its own fixed cadence.
+// Ticks are aligned to wall-clock boundaries of the interval
+// (e.g. every 60 secs means hh:mm:00), and the next tick is always calculated
+// from the schedule rather than from the end of the previous read, so it doesn't drift.
+// All sensors share the command queue of the DLPTH1C, so a slow read (e.g. vibration)
+// may delay the others. Ticks that could not be kept are skipped and reported as missed.
+type Scheduler struct {
+	d *DLPTH1C
+
+	// OnMissed is called when ticks of the sensor are skipped.
+	// It logs them if it is nil.
+	OnMissed func(cmd byte, missed int)
+
+	mu        sync.Mutex
+	intervals map[byte]time.Duration
+	missed    map[byte]int
+}
+
+func NewScheduler(d *DLPTH1C) *Scheduler {
+	return &Scheduler{
+		d:         d,
+		intervals: make(map[byte]time.Duration),
+		missed:    make(map[byte]int),
+	}
+}
+
+// Every sets the interval of the sensor requested by the command.
+// Calling it again for the same command replaces the interval.
+func (s *Scheduler) Every(cmd byte, interval time.Duration) error {
+	if SensorName(cmd) == "" || interval <= 0 {
+		return InvalidCommandError()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.intervals[cmd] = interval
+	return nil
+}
+
+// MissedTicks returns the number of skipped ticks of each sensor so far.
+func (s *Scheduler) MissedTicks() map[byte]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	missed := make(map[byte]int, len(s.missed))
+	for cmd, n := range s.missed {
+		missed[cmd] = n
+	}
+	return missed
+}
+
+// Run samples every sensor that has been set by Every,
+// until the context is done or the DLPTH1C is closed.
+// It returns after the data left in the output buffer are sent to the channel.
+func (s *Scheduler) Run(ctx context.Context, out chan<- *TimeSeriesData) error {
+	s.mu.Lock()
+	intervals := make(map[byte]time.Duration, len(s.intervals))
+	for cmd, interval := range s.intervals {
+		intervals[cmd] = interval
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(intervals))
+
+	for cmd, interval := range intervals {
+		wg.Add(1)
+		go func(cmd byte, interval time.Duration) {
+			defer wg.Done()
+
+			if err := s.run(ctx, cmd, interval, out); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(cmd, interval)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	// the caller can close the channel after Run returns
+	s.d.flushOutput(out)
+
+	// the first error is the reason why the others stopped
+	if err, ok := <-errs; ok {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (s *Scheduler) run(ctx context.Context, cmd byte, interval time.Duration, out chan<- *TimeSeriesData) error {
+	// first tick is the next boundary of the interval
+	// The time and the timers come from the clock of the DLPTH1C (see ./clock.go).
+	next := s.d.now().Truncate(interval).Add(interval)
+
+	for {
+		select {
+		case <-s.d.after(next.Sub(s.d.now())):
+		case <-ctx.Done():
+			return nil
+		}
+
+		result, err := s.d.readSensor(cmd)
+		if errors.Is(err, ClosedError()) {
+			return err
+		}
+		if err != nil {
+			// one failed read doesn't stop the schedule
+			log.Printf("%s: %v", SensorName(cmd), err)
+		} else if err := s.d.emit(ctx, out, result); err != nil {
+			return err
+		}
+
+		next = next.Add(interval)
+
+		// skip the ticks which have already passed
+		if now := s.d.now(); !now.Before(next) {
+			missed := int(now.Sub(next)/interval) + 1
+			next = next.Add(time.Duration(missed) * interval)
+			s.reportMissed(cmd, missed)
+		}
+	}
+}
+
+func (s *Scheduler) reportMissed(cmd byte, missed int) {
+	s.mu.Lock()
+	s.missed[cmd] += missed
+	s.mu.Unlock()
+
+	if s.OnMissed != nil {
+		s.OnMissed(cmd, missed)
+		return
+	}
+	log.Printf("%s: %d tick(s) missed", SensorName(cmd), missed)
+}
@@ -0,0 +1,147 @@
+package serial
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a TimerClock which only moves by Advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+// Advance moves the clock and fires the timers which are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	var waiting []fakeTimer
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			waiting = append(waiting, timer)
+			continue
+		}
+		timer.c <- c.now
+	}
+	c.timers = waiting
+}
+
+// waitTimers waits until n timers are waiting for the clock.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		waiting := len(c.timers)
+		c.mu.Unlock()
+
+		if waiting >= n {
+			return
+		}
+	}
+	t.Fatalf("%d timer(s) are not waiting", n)
+}
+
+func TestScheduler(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, int(500*time.Millisecond), time.UTC)
+	second := func(sec float64) time.Time {
+		return start.Truncate(time.Minute).Add(time.Duration(sec * float64(time.Second)))
+	}
+
+	tests := []struct {
+		name string
+		// advance the clock by these steps, and expect one sample after each
+		steps      []time.Duration
+		wantTimes  []time.Time
+		wantMissed int
+	}{
+		{
+			name:      "aligned to the boundaries",
+			steps:     []time.Duration{500 * time.Millisecond, time.Second, time.Second},
+			wantTimes: []time.Time{second(1), second(2), second(3)},
+		},
+		{
+			name:       "late ticks are skipped",
+			steps:      []time.Duration{500 * time.Millisecond, 2500 * time.Millisecond, 500 * time.Millisecond},
+			wantTimes:  []time.Time{second(1), second(3.5), second(4)},
+			wantMissed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(start)
+			d := NewDLPTH1CFromPort("fake", newFakePort(sensorResponses))
+			defer d.Close()
+			d.SetClock(clock)
+
+			s := NewScheduler(d)
+			s.OnMissed = func(cmd byte, missed int) {}
+			s.Every(TemperatureASCIICmd, time.Second)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			out := make(chan *TimeSeriesData)
+			done := make(chan error, 1)
+			go func() { done <- s.Run(ctx, out) }()
+
+			for i, step := range tt.steps {
+				clock.waitTimers(t, 1)
+				clock.Advance(step)
+
+				select {
+				case result := <-out:
+					if !result.Time.Equal(tt.wantTimes[i]) {
+						t.Errorf("sample %d at %v, want %v", i, result.Time, tt.wantTimes[i])
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("sample %d is not taken", i)
+				}
+			}
+
+			if missed := s.MissedTicks()[TemperatureASCIICmd]; missed != tt.wantMissed {
+				t.Errorf("missed = %d, want %d", missed, tt.wantMissed)
+			}
+
+			cancel()
+			if err := <-done; err != context.Canceled {
+				t.Errorf("Run: %v", err)
+			}
+		})
+	}
+}
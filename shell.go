@@ -0,0 +1,229 @@
+// Define interactive shell mode for sending commands by hand in this file
+package serial
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Named commands of the shell which request sensor data
+var shellSensorCmds = map[string]byte{
+	"temp":      TemperatureASCIICmd,
+	"humidity":  HumidityASCIICmd,
+	"pressure":  PressureASCIICmd,
+	"tilt":      TiltASCIICmd,
+	"vibx":      VibrationXASCIICmd,
+	"viby":      VibrationYASCIICmd,
+	"vibz":      VibrationZASCIICmd,
+	"light":     LightASCIICmd,
+	"sound":     SoundASCIICmd,
+	"broadband": BroadbandASCIICmd,
+}
+
+// Arguments of "range" command
+var shellRangeCmds = map[string]byte{
+	"2g":  Set2GASCIICmd,
+	"4g":  Set4GASCIICmd,
+	"8g":  Set8GASCIICmd,
+	"16g": Set16GASCIICmd,
+}
+
+func shellUsage(w io.Writer) {
+	fmt.Fprintf(w, "temp, humidity, pressure, tilt, vibx, viby, vibz, light, sound, broadband\n")
+	fmt.Fprintf(w, "\t\t\tRequest the data and print it parsed\n")
+	fmt.Fprintf(w, "ping\t\t\tPing the sensor\n")
+	fmt.Fprintf(w, "?\t\t\tPrint help of the sensor\n")
+	fmt.Fprintf(w, "range 2g|4g|8g|16g\tChange the range of the accelerometer\n")
+	fmt.Fprintf(w, "send HEX...\t\tSend raw bytes (e.g. \"send 74\" or \"send 0x74 0x68\")\n")
+	fmt.Fprintf(w, "hex on|off\t\tPrint hex dump of every response\n")
+	fmt.Fprintf(w, "resync\t\t\tDiscard the input and ping until the sensor replies cleanly\n")
+	fmt.Fprintf(w, "stats\t\t\tPrint statistics of the serial link\n")
+	fmt.Fprintf(w, "history, !N, !!\t\tPrint history, run N-th or last command again\n")
+	fmt.Fprintf(w, "help\t\t\tPrint this help\n")
+	fmt.Fprintf(w, "quit\t\t\tExit\n")
+}
+
+type shell struct {
+	d       *DLPTH1C
+	out     io.Writer
+	history []string
+	hexDump bool
+}
+
+// runShell reads commands line by line until "quit" or the end of input.
+func runShell(d *DLPTH1C, in io.Reader, out io.Writer) error {
+	sh := &shell{d: d, out: out}
+	scanner := bufio.NewScanner(in)
+
+	fmt.Fprintf(out, "DLP-TH1C shell on %s (type \"help\")\n", d.portName)
+	for {
+		fmt.Fprintf(out, "dlpth1c> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// history expansion
+		if strings.HasPrefix(line, "!") {
+			expanded, err := sh.expand(line)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				continue
+			}
+			line = expanded
+			fmt.Fprintln(out, line)
+		}
+		if line != "history" {
+			sh.history = append(sh.history, line)
+		}
+
+		quit, err := sh.execute(line)
+		if errors.Is(err, ClosedError()) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+func (sh *shell) expand(line string) (string, error) {
+	if len(sh.history) == 0 {
+		return "", errors.New("history is empty")
+	}
+	if line == "!!" {
+		return sh.history[len(sh.history)-1], nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(sh.history) {
+		return "", fmt.Errorf("%s: no such command in history", line)
+	}
+	return sh.history[n-1], nil
+}
+
+func (sh *shell) execute(line string) (quit bool, err error) {
+	fields := strings.Fields(line)
+	name, args := fields[0], fields[1:]
+
+	if cmd, exist := shellSensorCmds[name]; exist {
+		return false, sh.read(cmd)
+	}
+
+	switch name {
+	case "quit", "exit":
+		return true, nil
+
+	case "help":
+		shellUsage(sh.out)
+
+	case "history":
+		for i, h := range sh.history {
+			fmt.Fprintf(sh.out, "%4d  %s\n", i+1, h)
+		}
+
+	case "ping":
+		return false, sh.send([]byte{PingASCIICmd}, sh.hexDump)
+
+	case "?":
+		return false, sh.send([]byte{HelpASCIICmd}, sh.hexDump)
+
+	case "range":
+		if len(args) != 1 {
+			return false, InvalidCommandError()
+		}
+		cmd, exist := shellRangeCmds[strings.ToLower(args[0])]
+		if !exist {
+			return false, InvalidCommandError()
+		}
+		return false, sh.send([]byte{cmd}, sh.hexDump)
+
+	case "send":
+		b, err := parseHexBytes(args)
+		if err != nil {
+			return false, err
+		}
+		// raw bytes are always dumped
+		return false, sh.send(b, true)
+
+	case "hex":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return false, InvalidCommandError()
+		}
+		sh.hexDump = args[0] == "on"
+
+	case "resync":
+		if err := sh.d.Resync(); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(sh.out, "synchronized")
+
+	case "stats":
+		printDiagReport(sh.out, sh.d.Stats(), "STATISTICS")
+
+	default:
+		return false, fmt.Errorf("%s: unknown command (type \"help\")", name)
+	}
+
+	return false, nil
+}
+
+// read requests the sensor data once (without retry, to see what the sensor does)
+// and prints it parsed.
+func (sh *shell) read(cmd byte) error {
+	data, timing, raw, _, err := sh.d.sampleOnce(cmd)
+	if sh.hexDump || (err != nil && len(raw) > 0) {
+		fmt.Fprint(sh.out, hex.Dump(raw))
+	}
+	if err != nil {
+		return err
+	}
+
+	data.print(sh.out)
+	fmt.Fprintf(sh.out, "Latency: %v\n", timing.Latency())
+	return nil
+}
+
+func (sh *shell) send(b []byte, hexDump bool) error {
+	response, err := sh.d.Transact(b, HighPriority)
+	if hexDump {
+		fmt.Fprint(sh.out, hex.Dump(response))
+	} else {
+		fmt.Fprint(sh.out, string(response))
+		if !strings.HasSuffix(string(response), "\n") {
+			fmt.Fprintln(sh.out)
+		}
+	}
+	return err
+}
+
+// parseHexBytes parses the arguments like "74", "0x74" or "7468".
+func parseHexBytes(args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, InvalidCommandError()
+	}
+
+	b := make([]byte, 0, len(args))
+	for _, arg := range args {
+		arg = strings.TrimPrefix(strings.ToLower(arg), "0x")
+		decoded, err := hex.DecodeString(arg)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, decoded...)
+	}
+	return b, nil
+}
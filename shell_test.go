@@ -0,0 +1,48 @@
+package serial
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestShell(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		// not in the output
+		unwanted string
+	}{
+		{"read", "temp\n", []string{"Temperature: 23.45(℃)", "Latency: "}, ""},
+		{"tilt", "tilt\n", []string{"Tilt data below", "ZAxis: 1000"}, ""},
+		{"stats", "temp\nstats\n", []string{"STATISTICS", "temperature"}, ""},
+		{"ping", "ping\n", []string{"Q\r\n"}, ""},
+		{"send", "send 68\n", []string{"Humidity = 40.5%"}, ""},
+		{"history", "temp\n!!\nhistory\n", []string{"   1  temp\n   2  temp\n"}, ""},
+		{"unknown", "foo\n", []string{"foo: unknown command"}, ""},
+		{"quit", "quit\ntemp\n", nil, "Temperature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDLPTH1CFromPort("fake", newFakePort(sensorResponses))
+			defer d.Close()
+
+			var out bytes.Buffer
+			if err := runShell(d, strings.NewReader(tt.input), &out); err != nil {
+				t.Fatal(err)
+			}
+
+			// everything goes to the output of the shell
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output doesn't have %q:\n%s", want, out.String())
+				}
+			}
+			if tt.unwanted != "" && strings.Contains(out.String(), tt.unwanted) {
+				t.Errorf("output has %q:\n%s", tt.unwanted, out.String())
+			}
+		})
+	}
+}
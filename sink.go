@@ -0,0 +1,252 @@
+// Define Sink interface and the pipeline which fans out the data to sinks in this file
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Sink receives the data from the pipeline in batches.
+// Write is called by one goroutine at a time, and Close after the last Write.
+type Sink interface {
+	Write(ctx context.Context, batch []*TimeSeriesData) error
+	Close() error
+}
+
+// Flusher is a Sink whose Write returns before the data is delivered (e.g. MQTTPublisher buffers the messages).
+// Flush waits until everything written so far has been delivered.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// SinkFunc makes a function a Sink that has nothing to close.
+type SinkFunc func(ctx context.Context, batch []*TimeSeriesData) error
+
+func (f SinkFunc) Write(ctx context.Context, batch []*TimeSeriesData) error {
+	return f(ctx, batch)
+}
+
+func (f SinkFunc) Close() error {
+	return nil
+}
+
+// Default options of Pipeline
+const (
+	DefaultSinkBatchSize     int           = 1
+	DefaultSinkBatchInterval time.Duration = time.Second
+	DefaultSinkBufferSize    int           = 1000
+	DefaultSinkRetries       int           = 3
+	DefaultSinkRetryDelay    time.Duration = time.Second
+	DefaultSinkMaxRetryDelay time.Duration = 30 * time.Second
+)
+
+type PipelineOptions struct {
+	// A batch is written when it has BatchSize data, or BatchInterval after its first data.
+	BatchSize     int
+	BatchInterval time.Duration
+	// BufferSize is the number of data waiting for each sink.
+	// When a sink is too slow and its buffer is full, its oldest data is dropped,
+	// so a slow sink never blocks the sampling or the other sinks.
+	BufferSize int
+	// Retries of a failed batch, with exponential backoff from RetryDelay up to MaxRetryDelay.
+	// 0 means DefaultSinkRetries, and negative means no retry.
+	Retries       int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+}
+
+// Counters of a sink
+type SinkStats struct {
+	Name      string
+	Delivered uint64
+	Dropped   uint64
+	Failed    uint64
+}
+
+// Pipeline fans out the stream of data to several sinks concurrently.
+type Pipeline struct {
+	opts  PipelineOptions
+	sinks []*pipelineSink
+}
+
+type pipelineSink struct {
+	name  string
+	sink  Sink
+	queue chan *TimeSeriesData
+
+	mu    sync.Mutex
+	stats SinkStats
+}
+
+func NewPipeline(opts PipelineOptions, sinks ...Sink) *Pipeline {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultSinkBatchSize
+	}
+	if opts.BatchInterval <= 0 {
+		opts.BatchInterval = DefaultSinkBatchInterval
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultSinkBufferSize
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	} else if opts.Retries == 0 {
+		opts.Retries = DefaultSinkRetries
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = DefaultSinkRetryDelay
+	}
+	if opts.MaxRetryDelay <= 0 {
+		opts.MaxRetryDelay = DefaultSinkMaxRetryDelay
+	}
+
+	p := &Pipeline{opts: opts}
+	for i, sink := range sinks {
+		name := fmt.Sprintf("%d:%T", i, sink)
+		p.sinks = append(p.sinks, &pipelineSink{
+			name:  name,
+			sink:  sink,
+			queue: make(chan *TimeSeriesData, opts.BufferSize),
+			stats: SinkStats{Name: name},
+		})
+	}
+	return p
+}
+
+// Run sends every data from the channel to all sinks, until the channel is closed or the context is done.
+// Then the data left in the buffers are written (if the context is not done) and the sinks are closed.
+func (p *Pipeline) Run(ctx context.Context, in <-chan *TimeSeriesData) error {
+	var wg sync.WaitGroup
+	for _, s := range p.sinks {
+		wg.Add(1)
+		go func(s *pipelineSink) {
+			defer wg.Done()
+			p.serve(ctx, s)
+		}(s)
+	}
+
+	err := p.fanOut(ctx, in)
+	for _, s := range p.sinks {
+		close(s.queue)
+	}
+	wg.Wait()
+
+	for _, s := range p.sinks {
+		if closeErr := s.sink.Close(); closeErr != nil {
+			log.Printf("sink %s: %v", s.name, closeErr)
+		}
+	}
+	return err
+}
+
+func (p *Pipeline) fanOut(ctx context.Context, in <-chan *TimeSeriesData) error {
+	for {
+		select {
+		case timeSeriesData, ok := <-in:
+			if !ok {
+				return nil
+			}
+			for _, s := range p.sinks {
+				s.push(timeSeriesData)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// push never blocks, it drops the oldest data if the buffer is full.
+func (s *pipelineSink) push(timeSeriesData *TimeSeriesData) {
+	for {
+		select {
+		case s.queue <- timeSeriesData:
+			return
+		default:
+		}
+
+		select {
+		case <-s.queue:
+			s.mu.Lock()
+			s.stats.Dropped++
+			s.mu.Unlock()
+		default:
+		}
+	}
+}
+
+// serve collects batches from the buffer and writes them to the sink.
+func (p *Pipeline) serve(ctx context.Context, s *pipelineSink) {
+	for {
+		// wait for the first data of a batch
+		first, ok := <-s.queue
+		if !ok {
+			return
+		}
+		batch := []*TimeSeriesData{first}
+
+		timer := time.NewTimer(p.opts.BatchInterval)
+	collect:
+		for len(batch) < p.opts.BatchSize {
+			select {
+			case timeSeriesData, ok := <-s.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, timeSeriesData)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		p.write(ctx, s, batch)
+	}
+}
+
+// write retries the batch with backoff, and gives it up after the retries or on PermanentSinkError.
+func (p *Pipeline) write(ctx context.Context, s *pipelineSink, batch []*TimeSeriesData) {
+	delay := p.opts.RetryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := s.sink.Write(ctx, batch)
+		if err == nil {
+			s.mu.Lock()
+			s.stats.Delivered += uint64(len(batch))
+			s.mu.Unlock()
+			return
+		}
+
+		var permanent *permanentSinkError
+		if attempt >= p.opts.Retries || ctx.Err() != nil || errors.As(err, &permanent) {
+			log.Printf("sink %s: %v (%d data given up)", s.name, err, len(batch))
+			s.mu.Lock()
+			s.stats.Failed += uint64(len(batch))
+			s.mu.Unlock()
+			return
+		}
+
+		log.Printf("sink %s: %v (retry in %v)", s.name, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		if delay *= 2; delay > p.opts.MaxRetryDelay {
+			delay = p.opts.MaxRetryDelay
+		}
+	}
+}
+
+// Stats returns the counters of each sink, in the order given to NewPipeline.
+func (p *Pipeline) Stats() []SinkStats {
+	stats := make([]SinkStats, 0, len(p.sinks))
+	for _, s := range p.sinks {
+		s.mu.Lock()
+		stats = append(stats, s.stats)
+		s.mu.Unlock()
+	}
+	return stats
+}
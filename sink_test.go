@@ -0,0 +1,229 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink records the batches written, and fails the first writes with the errors given.
+type recordingSink struct {
+	mu      sync.Mutex
+	errs    []error
+	batches [][]SensorData
+	times   []time.Time
+}
+
+func (s *recordingSink) Write(ctx context.Context, batch []*TimeSeriesData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.times = append(s.times, time.Now())
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+
+	temperatures := []SensorData{}
+	for _, timeSeriesData := range batch {
+		temperatures = append(temperatures, timeSeriesData.Data[TemperatureASCIICmd])
+	}
+	s.batches = append(s.batches, temperatures)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func (s *recordingSink) written() [][]SensorData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([][]SensorData(nil), s.batches...)
+}
+
+// runPipeline sends the data to the pipeline and waits until it has written everything.
+func runPipeline(t *testing.T, p *Pipeline, data ...*TimeSeriesData) {
+	t.Helper()
+
+	in := make(chan *TimeSeriesData)
+	done := make(chan error, 1)
+	go func() { done <- p.Run(context.Background(), in) }()
+	for _, timeSeriesData := range data {
+		in <- timeSeriesData
+	}
+	close(in)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipelineBatchSize(t *testing.T) {
+	sink := &recordingSink{}
+	// the interval never ends a batch
+	p := NewPipeline(PipelineOptions{BatchSize: 3, BatchInterval: time.Hour}, sink)
+
+	runPipeline(t, p, temperatureSample(1), temperatureSample(2), temperatureSample(3), temperatureSample(4), temperatureSample(5))
+
+	// the last batch is written when the channel is closed
+	want := [][]SensorData{
+		{TemperatureData(1), TemperatureData(2), TemperatureData(3)},
+		{TemperatureData(4), TemperatureData(5)},
+	}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestPipelineBatchInterval(t *testing.T) {
+	sink := &recordingSink{}
+	p := NewPipeline(PipelineOptions{BatchSize: 100, BatchInterval: 10 * time.Millisecond}, sink)
+
+	in := make(chan *TimeSeriesData)
+	done := make(chan error, 1)
+	go func() { done <- p.Run(context.Background(), in) }()
+	defer func() {
+		close(in)
+		<-done
+	}()
+
+	in <- temperatureSample(1)
+	in <- temperatureSample(2)
+
+	// written by the interval while the channel is still open
+	waitUntil(t, "writing the batch", func() bool { return len(sink.written()) == 1 })
+	want := [][]SensorData{{TemperatureData(1), TemperatureData(2)}}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestPipelineRetry(t *testing.T) {
+	failure := errors.New("unreachable")
+
+	tests := []struct {
+		name    string
+		retries int
+		errs    []error
+		// number of writes
+		wantWrites int
+		want       SinkStats
+	}{
+		{
+			name:       "delivered after retries",
+			retries:    3,
+			errs:       []error{failure, failure},
+			wantWrites: 3,
+			want:       SinkStats{Delivered: 1},
+		},
+		{
+			name:       "failed after retries",
+			retries:    2,
+			errs:       []error{failure, failure, failure, failure},
+			wantWrites: 3,
+			want:       SinkStats{Failed: 1},
+		},
+		{
+			name:       "no retry",
+			retries:    -1,
+			errs:       []error{failure},
+			wantWrites: 1,
+			want:       SinkStats{Failed: 1},
+		},
+		{
+			name:       "permanent error",
+			retries:    3,
+			errs:       []error{PermanentSinkError(failure)},
+			wantWrites: 1,
+			want:       SinkStats{Failed: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &recordingSink{errs: tt.errs}
+			p := NewPipeline(PipelineOptions{Retries: tt.retries, RetryDelay: 5 * time.Millisecond, MaxRetryDelay: 10 * time.Millisecond}, sink)
+
+			runPipeline(t, p, temperatureSample(1))
+
+			if len(sink.times) != tt.wantWrites {
+				t.Errorf("written %d times, want %d", len(sink.times), tt.wantWrites)
+			}
+			// the delay is doubled up to MaxRetryDelay
+			for i := 1; i < len(sink.times); i++ {
+				want := 5 * time.Millisecond << (i - 1)
+				if want > 10*time.Millisecond {
+					want = 10 * time.Millisecond
+				}
+				if delay := sink.times[i].Sub(sink.times[i-1]); delay < want {
+					t.Errorf("retry %d after %v, want %v", i, delay, want)
+				}
+			}
+
+			tt.want.Name = p.Stats()[0].Name
+			if stats := p.Stats()[0]; stats != tt.want {
+				t.Errorf("stats = %+v, want %+v", stats, tt.want)
+			}
+		})
+	}
+}
+
+// blockingSink blocks in Write until it is released.
+type blockingSink struct {
+	recordingSink
+	entered  chan struct{}
+	released chan struct{}
+}
+
+func (s *blockingSink) Write(ctx context.Context, batch []*TimeSeriesData) error {
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+	<-s.released
+	return s.recordingSink.Write(ctx, batch)
+}
+
+func TestPipelineSlowSink(t *testing.T) {
+	slow := &blockingSink{entered: make(chan struct{}, 1), released: make(chan struct{})}
+	fast := &recordingSink{}
+	p := NewPipeline(PipelineOptions{BufferSize: 2}, slow, fast)
+
+	in := make(chan *TimeSeriesData)
+	done := make(chan error, 1)
+	go func() { done <- p.Run(context.Background(), in) }()
+
+	// the slow sink is writing the first data, and the fast one receives the rest meanwhile
+	in <- temperatureSample(1)
+	<-slow.entered
+	for i := 2; i <= 10; i++ {
+		in <- temperatureSample(float64(i))
+		waitUntil(t, "the fast sink", func() bool { return len(fast.written()) == i })
+	}
+
+	close(slow.released)
+	close(in)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the oldest are dropped, and the newest 2 are kept in the buffer
+	want := [][]SensorData{{TemperatureData(1)}, {TemperatureData(9)}, {TemperatureData(10)}}
+	if got := slow.written(); !reflect.DeepEqual(got, want) {
+		t.Errorf("slow sink = %v, want %v", got, want)
+	}
+
+	stats := p.Stats()
+	wantStats := []SinkStats{
+		{Name: "0:*serial.blockingSink", Delivered: 3, Dropped: 7},
+		{Name: "1:*serial.recordingSink", Delivered: 10},
+	}
+	if !reflect.DeepEqual(stats, wantStats) {
+		t.Errorf("stats = %+v, want %+v", stats, wantStats)
+	}
+}
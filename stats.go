@@ -0,0 +1,229 @@
+// Define statistics of the serial link in this file
+package serial
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the latency histogram buckets
+var LatencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	20 * time.Second,
+}
+
+// Stats is a snapshot of the counters of a DLPTH1C.
+// Maps are keyed by the (first) command of each transaction.
+type Stats struct {
+	BytesRead      uint64
+	BytesWritten   uint64
+	BytesDiscarded uint64
+	CommandsSent   uint64
+	Timeouts       uint64
+	Retries        uint64
+	Resyncs        uint64
+	ResyncFailures uint64
+	Requests       map[byte]uint64
+	Samples        map[byte]uint64
+	ParseFailures  map[byte]uint64
+	Dropped        map[byte]uint64 // lost by the overflow policy (see ./backpressure.go)
+	Latency        map[byte]*LatencyHistogram
+}
+
+// LatencyHistogram counts response latencies by LatencyBuckets.
+// Counts has one more element than Bounds for the latencies over the last bound.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+func newLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{
+		Bounds: LatencyBuckets,
+		Counts: make([]uint64, len(LatencyBuckets)+1),
+	}
+}
+
+func (h *LatencyHistogram) observe(latency time.Duration) {
+	i := 0
+	for i < len(h.Bounds) && latency > h.Bounds[i] {
+		i++
+	}
+
+	h.Counts[i]++
+	h.Count++
+	h.Sum += latency
+	if latency > h.Max {
+		h.Max = latency
+	}
+}
+
+// Mean returns the average latency.
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket where the quantile q (0 ~ 1) is in.
+// It returns Max if the quantile is over the last bound.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	// the nearest rank, e.g. the 95th of 100 latencies and the 2nd of 2
+	rank := uint64(math.Ceil(q * float64(h.Count)))
+	var cumulative uint64
+	for i, count := range h.Counts {
+		cumulative += count
+		if count == 0 || cumulative < rank {
+			continue
+		}
+
+		if i < len(h.Bounds) && h.Bounds[i] < h.Max {
+			return h.Bounds[i]
+		}
+		break
+	}
+	return h.Max
+}
+
+func (h *LatencyHistogram) copy() *LatencyHistogram {
+	c := *h
+	c.Counts = append([]uint64(nil), h.Counts...)
+	return &c
+}
+
+// stats is updated by the command queue and the read functions
+type stats struct {
+	mu sync.Mutex
+	s  Stats
+}
+
+func newStats() *stats {
+	return &stats{
+		s: Stats{
+			Requests:      make(map[byte]uint64),
+			Samples:       make(map[byte]uint64),
+			ParseFailures: make(map[byte]uint64),
+			Dropped:       make(map[byte]uint64),
+			Latency:       make(map[byte]*LatencyHistogram),
+		},
+	}
+}
+
+func (st *stats) recordTransaction(cmd []byte, written int, result *transactionResult) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.s.BytesWritten += uint64(written)
+	st.s.BytesRead += uint64(len(result.b))
+	st.s.CommandsSent += uint64(len(cmd))
+
+	if result.err == TimeoutError() {
+		st.s.Timeouts++
+	}
+	if len(cmd) == 0 {
+		return
+	}
+
+	st.s.Requests[cmd[0]]++
+	if result.err != nil {
+		return
+	}
+
+	h, exist := st.s.Latency[cmd[0]]
+	if !exist {
+		h = newLatencyHistogram()
+		st.s.Latency[cmd[0]] = h
+	}
+	h.observe(result.received.Sub(result.sent))
+}
+
+func (st *stats) recordRetry() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.s.Retries++
+}
+
+func (st *stats) recordDiscard(n int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.s.BytesDiscarded += uint64(n)
+}
+
+func (st *stats) recordResync(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.s.Resyncs++
+	if err != nil {
+		st.s.ResyncFailures++
+	}
+}
+
+func (st *stats) recordParse(cmd byte, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err != nil {
+		st.s.ParseFailures[cmd]++
+		return
+	}
+	st.s.Samples[cmd]++
+}
+
+func (st *stats) recordDrop(timeSeriesData *TimeSeriesData) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for cmd := range timeSeriesData.Data {
+		st.s.Dropped[cmd]++
+	}
+}
+
+// Stats returns the snapshot of counters since the DLPTH1C was created.
+func (d *DLPTH1C) Stats() Stats {
+	d.stats.mu.Lock()
+	defer d.stats.mu.Unlock()
+
+	snapshot := d.stats.s
+	snapshot.Requests = make(map[byte]uint64, len(d.stats.s.Requests))
+	for cmd, n := range d.stats.s.Requests {
+		snapshot.Requests[cmd] = n
+	}
+	snapshot.Samples = make(map[byte]uint64, len(d.stats.s.Samples))
+	for cmd, n := range d.stats.s.Samples {
+		snapshot.Samples[cmd] = n
+	}
+	snapshot.ParseFailures = make(map[byte]uint64, len(d.stats.s.ParseFailures))
+	for cmd, n := range d.stats.s.ParseFailures {
+		snapshot.ParseFailures[cmd] = n
+	}
+	snapshot.Dropped = make(map[byte]uint64, len(d.stats.s.Dropped))
+	for cmd, n := range d.stats.s.Dropped {
+		snapshot.Dropped[cmd] = n
+	}
+	snapshot.Latency = make(map[byte]*LatencyHistogram, len(d.stats.s.Latency))
+	for cmd, h := range d.stats.s.Latency {
+		snapshot.Latency[cmd] = h.copy()
+	}
+
+	return snapshot
+}
@@ -0,0 +1,164 @@
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		// the number of latencies in each bucket which is not empty
+		wantCounts map[int]uint64
+		wantMean   time.Duration
+		// quantile to its latency
+		wantQuantiles map[float64]time.Duration
+	}{
+		{
+			name:          "empty",
+			wantMean:      0,
+			wantQuantiles: map[float64]time.Duration{0: 0, 0.5: 0, 1: 0},
+		},
+		{
+			// the bound of the bucket is over every latency, so Max is more precise
+			name:          "under the bound",
+			latencies:     []time.Duration{5 * time.Millisecond},
+			wantCounts:    map[int]uint64{0: 1},
+			wantMean:      5 * time.Millisecond,
+			wantQuantiles: map[float64]time.Duration{0: 5 * time.Millisecond, 0.95: 5 * time.Millisecond, 1: 5 * time.Millisecond},
+		},
+		{
+			// a latency equal to the bound is in its bucket
+			name:          "on the bound",
+			latencies:     []time.Duration{10 * time.Millisecond, 50 * time.Millisecond},
+			wantCounts:    map[int]uint64{0: 1, 1: 1},
+			wantMean:      30 * time.Millisecond,
+			wantQuantiles: map[float64]time.Duration{0.5: 10 * time.Millisecond, 1: 50 * time.Millisecond},
+		},
+		{
+			// the last bucket has no bound
+			name:          "over the last bound",
+			latencies:     []time.Duration{time.Second, 30 * time.Second},
+			wantCounts:    map[int]uint64{5: 1, len(LatencyBuckets): 1},
+			wantMean:      15500 * time.Millisecond,
+			wantQuantiles: map[float64]time.Duration{0.5: time.Second, 0.95: 30 * time.Second, 1: 30 * time.Second},
+		},
+		{
+			name: "spread",
+			latencies: append(append(repeatLatency(5*time.Millisecond, 94), repeatLatency(300*time.Millisecond, 5)...),
+				3*time.Second),
+			wantCounts: map[int]uint64{0: 94, 4: 5, 7: 1},
+			wantMean:   49700 * time.Microsecond,
+			wantQuantiles: map[float64]time.Duration{
+				0:    10 * time.Millisecond,
+				0.5:  10 * time.Millisecond,
+				0.95: 500 * time.Millisecond,
+				0.99: 500 * time.Millisecond,
+				1:    3 * time.Second,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newLatencyHistogram()
+			for _, latency := range tt.latencies {
+				h.observe(latency)
+			}
+
+			if h.Count != uint64(len(tt.latencies)) {
+				t.Errorf("Count = %d, want %d", h.Count, len(tt.latencies))
+			}
+			for i, count := range h.Counts {
+				if count != tt.wantCounts[i] {
+					t.Errorf("Counts[%d] = %d, want %d", i, count, tt.wantCounts[i])
+				}
+			}
+			if mean := h.Mean(); mean != tt.wantMean {
+				t.Errorf("Mean() = %v, want %v", mean, tt.wantMean)
+			}
+			for q, want := range tt.wantQuantiles {
+				if got := h.Quantile(q); got != want {
+					t.Errorf("Quantile(%v) = %v, want %v", q, got, want)
+				}
+			}
+		})
+	}
+}
+
+func repeatLatency(latency time.Duration, n int) []time.Duration {
+	latencies := make([]time.Duration, n)
+	for i := range latencies {
+		latencies[i] = latency
+	}
+	return latencies
+}
+
+func TestStats(t *testing.T) {
+	responses := map[byte]string{
+		TemperatureASCIICmd: temperatureResponse,
+		HumidityASCIICmd:    humidityResponse,
+	}
+	d := NewDLPTH1CFromPort("fake", newFakePort(responses))
+	defer d.Close()
+	// the pressure is not answered
+	d.SetCommandTimeout(PressureASCIICmd, 20*time.Millisecond)
+
+	for _, cmd := range []byte{TemperatureASCIICmd, TemperatureASCIICmd, HumidityASCIICmd} {
+		if _, err := d.Read(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Transact([]byte{PressureASCIICmd}, NormalPriority); err != TimeoutError() {
+		t.Fatalf("Transact: %v, want %v", err, TimeoutError())
+	}
+
+	stats := d.Stats()
+	tests := []struct {
+		name string
+		got  uint64
+		want uint64
+	}{
+		{"commands sent", stats.CommandsSent, 4},
+		{"bytes written", stats.BytesWritten, 4},
+		{"bytes read", stats.BytesRead, uint64(2*len(temperatureResponse) + len(humidityResponse))},
+		{"timeouts", stats.Timeouts, 1},
+		{"temperature requests", stats.Requests[TemperatureASCIICmd], 2},
+		{"temperature samples", stats.Samples[TemperatureASCIICmd], 2},
+		{"humidity requests", stats.Requests[HumidityASCIICmd], 1},
+		{"humidity samples", stats.Samples[HumidityASCIICmd], 1},
+		// the request without the response has no latency
+		{"pressure requests", stats.Requests[PressureASCIICmd], 1},
+		{"pressure samples", stats.Samples[PressureASCIICmd], 0},
+		{"temperature latencies", stats.Latency[TemperatureASCIICmd].Count, 2},
+		{"humidity latencies", stats.Latency[HumidityASCIICmd].Count, 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+	if _, exist := stats.Latency[PressureASCIICmd]; exist {
+		t.Error("pressure has a latency")
+	}
+
+	// the snapshot is not changed by the DLPTH1C, and doesn't change it
+	stats.Requests[TemperatureASCIICmd] = 100
+	stats.Samples[TemperatureASCIICmd] = 100
+	stats.Latency[TemperatureASCIICmd].Counts[0] = 100
+	if _, err := d.Read(TemperatureASCIICmd); err != nil {
+		t.Fatal(err)
+	}
+
+	after := d.Stats()
+	if after.Requests[TemperatureASCIICmd] != 3 || after.Samples[TemperatureASCIICmd] != 3 {
+		t.Errorf("temperature requests = %d, samples = %d, want 3", after.Requests[TemperatureASCIICmd], after.Samples[TemperatureASCIICmd])
+	}
+	if h := after.Latency[TemperatureASCIICmd]; h.Count != 3 || h.Counts[0] > 3 {
+		t.Errorf("temperature latency = %+v, want 3 latencies", h)
+	}
+	if stats.Requests[TemperatureASCIICmd] != 100 || stats.Latency[TemperatureASCIICmd].Count != 2 {
+		t.Error("the snapshot is changed")
+	}
+}
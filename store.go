@@ -0,0 +1,491 @@
+// Define embedded append-only time-series store in this file
+package serial
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Segment file of a day (UTC), e.g. "20261018.seg"
+const (
+	segmentLayout    string = "20060102"
+	segmentExtension string = ".seg"
+)
+
+// Size of the header (length) and the trailer (CRC) of a record
+const (
+	recordHeaderSize  int = 4
+	recordTrailerSize int = 4
+	// A record bigger than this is considered corrupted
+	maxRecordSize int = 1 << 16
+)
+
+type StoreOptions struct {
+	// MaxAge removes the segments older than it (0 keeps them forever).
+	MaxAge time.Duration
+	// MaxSize removes the oldest segments until the total size is under it (0 is unlimited).
+	// The segment being appended is removed too, if it alone is over MaxSize.
+	MaxSize int64
+}
+
+// Store keeps TimeSeriesData of devices in files, so they survive restarts.
+// Each device has a directory of segment files per day, and each record is
+//
+//	length (4 bytes, little endian) | encoded TimeSeriesData (./codec.go) | CRC-32 of it (4 bytes)
+type Store struct {
+	dir  string
+	opts StoreOptions
+
+	mu       sync.Mutex
+	segments map[string]*segment
+	// every segment on the disk (the oldest first) and their total size,
+	// so the retention doesn't list the directory on every Append
+	files []*segmentFile
+	size  int64
+}
+
+// An open segment which records are appended to
+type segment struct {
+	day  string
+	file *os.File
+	info *segmentFile
+}
+
+// OpenStore opens (or creates) the store in the directory, and applies the retention.
+func OpenStore(dir string, opts StoreOptions) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:      dir,
+		opts:     opts,
+		segments: make(map[string]*segment),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.retain(time.Now(), 0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+var unsafeFileName = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// deviceDir makes the device name (e.g. "/dev/ttyACM0") usable as a directory name.
+func (s *Store) deviceDir(device string) string {
+	return filepath.Join(s.dir, unsafeFileName.ReplaceAllString(path.Base(device), "_"))
+}
+
+// Append writes the data at the end of the segment of its day.
+func (s *Store) Append(device string, timeSeriesData *TimeSeriesData) error {
+	payload := appendTimeSeriesData(nil, timeSeriesData)
+
+	record := make([]byte, 0, recordHeaderSize+len(payload)+recordTrailerSize)
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(payload)))
+	record = append(record, payload...)
+	record = binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(payload))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// room is made before the write, so the new record is kept even if its segment has to go
+	if s.opts.MaxSize > 0 && s.size+int64(len(record)) > s.opts.MaxSize {
+		if err := s.retain(time.Now(), int64(len(record))); err != nil {
+			return err
+		}
+	}
+
+	seg, err := s.segment(device, timeSeriesData.Time.UTC().Format(segmentLayout))
+	if err != nil {
+		return err
+	}
+
+	// one write for one record, so a crash leaves at most one broken record at the end
+	if _, err := seg.file.Write(record); err != nil {
+		return err
+	}
+	seg.info.size += int64(len(record))
+	s.size += int64(len(record))
+	return nil
+}
+
+// segment returns the open segment of the device, and rotates it if the day has changed.
+func (s *Store) segment(device string, day string) (*segment, error) {
+	dir := s.deviceDir(device)
+	if seg, exist := s.segments[dir]; exist {
+		if seg.day == day {
+			return seg, nil
+		}
+
+		seg.file.Close()
+		delete(s.segments, dir)
+
+		// a new day, the old segments could be expired
+		if err := s.retain(time.Now(), 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	p := filepath.Join(dir, day+segmentExtension)
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	// a crash could have left a broken record at the end, and the next records must not follow it
+	size, err := recoverSegment(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	info := s.file(p, day)
+	s.size += size - info.size
+	info.size = size
+
+	seg := &segment{day: day, file: f, info: info}
+	s.segments[dir] = seg
+	return seg, nil
+}
+
+// recoverSegment truncates the segment after its last complete record,
+// and moves the offset to the end. It returns the size of the segment.
+func recoverSegment(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var good int64
+	r := bufio.NewReader(f)
+	for {
+		payload, err := readRecord(r)
+		if err == io.EOF || errors.Is(err, CorruptedRecordError()) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		good += int64(recordHeaderSize + len(payload) + recordTrailerSize)
+	}
+
+	if good < info.Size() {
+		log.Printf("%s: %d bytes after the last complete record are truncated", f.Name(), info.Size()-good)
+		if err := f.Truncate(good); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return good, nil
+}
+
+// A segment file on the disk
+type segmentFile struct {
+	path string
+	day  string
+	size int64
+}
+
+// load lists the segments of every device on the disk, the oldest first.
+func (s *Store) load() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*", "*"+segmentExtension))
+	if err != nil {
+		return err
+	}
+
+	s.files = make([]*segmentFile, 0, len(paths))
+	s.size = 0
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		day := strings.TrimSuffix(filepath.Base(p), segmentExtension)
+		if _, err := time.Parse(segmentLayout, day); err != nil {
+			continue
+		}
+		s.files = append(s.files, &segmentFile{path: p, day: day, size: info.Size()})
+		s.size += info.Size()
+	}
+
+	// the open segments keep their entries, so Append still counts their size
+	for _, seg := range s.segments {
+		for i, f := range s.files {
+			if f.path == seg.info.path {
+				s.files[i] = seg.info
+				s.size += seg.info.size - f.size
+			}
+		}
+	}
+
+	s.sortFiles()
+	return nil
+}
+
+func (s *Store) sortFiles() {
+	sort.Slice(s.files, func(i, j int) bool {
+		if s.files[i].day != s.files[j].day {
+			return s.files[i].day < s.files[j].day
+		}
+		return s.files[i].path < s.files[j].path
+	})
+}
+
+// file returns the entry of the segment, and adds it if it is new.
+func (s *Store) file(p string, day string) *segmentFile {
+	for _, f := range s.files {
+		if f.path == p {
+			return f
+		}
+	}
+
+	f := &segmentFile{path: p, day: day}
+	s.files = append(s.files, f)
+	s.sortFiles()
+	return f
+}
+
+// Retain lists the segments on the disk again, and removes them by MaxAge and MaxSize now.
+func (s *Store) Retain() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	return s.retain(time.Now(), 0)
+}
+
+// retain removes the expired segments, and then the oldest ones while the total size and the incoming bytes are over MaxSize.
+// An open segment which is removed is closed, so the next Append starts it again.
+func (s *Store) retain(now time.Time, incoming int64) error {
+	open := make(map[string]string, len(s.segments))
+	for dir, seg := range s.segments {
+		open[seg.info.path] = dir
+	}
+
+	kept := s.files[:0]
+	for i, f := range s.files {
+		// the segment expires when its whole day is older than MaxAge
+		day, _ := time.Parse(segmentLayout, f.day)
+		expired := s.opts.MaxAge > 0 && now.Sub(day.Add(24*time.Hour)) > s.opts.MaxAge
+		oversize := s.opts.MaxSize > 0 && s.size+incoming > s.opts.MaxSize
+		if !expired && !oversize {
+			kept = append(kept, f)
+			continue
+		}
+
+		if dir, exist := open[f.path]; exist {
+			s.segments[dir].file.Close()
+			delete(s.segments, dir)
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			s.files = append(kept, s.files[i:]...)
+			return err
+		}
+		s.size -= f.size
+	}
+
+	s.files = kept
+	return nil
+}
+
+// Query returns the data of the device whose time is in [from, to), in order of time.
+// Only the sensors given are returned (every sensor if it is empty).
+func (s *Store) Query(device string, sensors []byte, from time.Time, to time.Time) ([]*TimeSeriesData, error) {
+	wanted := make(map[byte]bool, len(sensors))
+	for _, cmd := range sensors {
+		wanted[cmd] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.deviceDir(device)
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	firstDay := from.UTC().Format(segmentLayout)
+	lastDay := to.UTC().Format(segmentLayout)
+
+	result := make([]*TimeSeriesData, 0)
+	for _, p := range paths {
+		day := strings.TrimSuffix(filepath.Base(p), segmentExtension)
+		if day < firstDay || day > lastDay {
+			continue
+		}
+
+		err := readSegment(p, func(timeSeriesData *TimeSeriesData) {
+			if timeSeriesData.Time.Before(from) || !timeSeriesData.Time.Before(to) {
+				return
+			}
+			if len(wanted) > 0 && !filterSensors(timeSeriesData, wanted) {
+				return
+			}
+			result = append(result, timeSeriesData)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time.Before(result[j].Time) })
+	return result, nil
+}
+
+// filterSensors leaves only the wanted sensors (in Data, Timing and Raw), and reports whether any data is left.
+func filterSensors(timeSeriesData *TimeSeriesData, wanted map[byte]bool) bool {
+	for cmd := range timeSeriesData.Data {
+		if !wanted[cmd] {
+			delete(timeSeriesData.Data, cmd)
+		}
+	}
+	for cmd := range timeSeriesData.Timing {
+		if !wanted[cmd] {
+			delete(timeSeriesData.Timing, cmd)
+		}
+	}
+	for cmd := range timeSeriesData.Raw {
+		if !wanted[cmd] {
+			delete(timeSeriesData.Raw, cmd)
+		}
+	}
+	return len(timeSeriesData.Data) > 0
+}
+
+// readSegment calls the function for each record of the segment.
+// The rest of the segment after a corrupted record is skipped,
+// since the length of the next record cannot be trusted.
+func readSegment(p string, fn func(*TimeSeriesData)) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		payload, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Printf("%s: %v", p, err)
+			return nil
+		}
+
+		timeSeriesData, err := decodeTimeSeriesData(payload)
+		if err != nil {
+			log.Printf("%s: %v", p, err)
+			continue
+		}
+		fn(timeSeriesData)
+	}
+}
+
+// readRecord reads a record and checks its CRC.
+// It returns io.EOF only at the end of the last complete record.
+func readRecord(r io.Reader) ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, CorruptedRecordError()
+	}
+
+	length := int(binary.LittleEndian.Uint32(header))
+	if length > maxRecordSize {
+		return nil, CorruptedRecordError()
+	}
+
+	body := make([]byte, length+recordTrailerSize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || err == io.EOF {
+			return nil, CorruptedRecordError()
+		}
+		return nil, err
+	}
+
+	payload := body[:length]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(body[length:]) {
+		return nil, CorruptedRecordError()
+	}
+	return payload, nil
+}
+
+// Close closes the open segments.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for dir, seg := range s.segments {
+		if closeErr := seg.file.Close(); err == nil {
+			err = closeErr
+		}
+		delete(s.segments, dir)
+	}
+	return err
+}
+
+// Sink returns a Sink (./sink.go) which appends the data of the device.
+// Closing it closes the store.
+func (s *Store) Sink(device string) Sink {
+	return &storeSink{store: s, device: device}
+}
+
+type storeSink struct {
+	store  *Store
+	device string
+}
+
+func (sink *storeSink) Write(ctx context.Context, batch []*TimeSeriesData) error {
+	for _, timeSeriesData := range batch {
+		if err := sink.store.Append(sink.device, timeSeriesData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sink *storeSink) Close() error {
+	return sink.store.Close()
+}
+
+// runStore appends every data from the channel to the store.
+func runStore(device string, in <-chan *TimeSeriesData, dir string, opts StoreOptions) error {
+	store, err := OpenStore(dir, opts)
+	if err != nil {
+		return err
+	}
+
+	return NewPipeline(PipelineOptions{}, store.Sink(device)).Run(context.Background(), in)
+}
@@ -0,0 +1,227 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func storeData(sec int) *TimeSeriesData {
+	return &TimeSeriesData{
+		Time: time.Date(2026, 1, 1, 0, 0, sec, 0, time.UTC),
+		Data: map[byte]SensorData{
+			TemperatureASCIICmd: TemperatureData(20 + float64(sec)),
+			HumidityASCIICmd:    HumidityData(40),
+		},
+	}
+}
+
+func queryTemperatures(t *testing.T, s *Store) []SensorData {
+	t.Helper()
+
+	result, err := s.Query("/dev/ttyACM0", nil, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	temperatures := make([]SensorData, 0, len(result))
+	for _, timeSeriesData := range result {
+		temperatures = append(temperatures, timeSeriesData.Data[TemperatureASCIICmd])
+	}
+	return temperatures
+}
+
+func TestStoreTornTail(t *testing.T) {
+	dir := t.TempDir()
+	segmentPath := filepath.Join(dir, "ttyACM0", "20260101"+segmentExtension)
+
+	s, err := OpenStore(dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for sec := 1; sec <= 2; sec++ {
+		if err := s.Append("/dev/ttyACM0", storeData(sec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	info, err := os.Stat(segmentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete := info.Size()
+
+	// a crash in the middle of the third record
+	f, err := os.OpenFile(segmentPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{40, 0, 0, 0, 1, 2, 3})
+	f.Close()
+
+	s, err = OpenStore(dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Append("/dev/ttyACM0", storeData(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the broken record is truncated, so the new one is readable after the others
+	want := []SensorData{TemperatureData(21), TemperatureData(22), TemperatureData(23)}
+	if got := queryTemperatures(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("temperatures = %v, want %v", got, want)
+	}
+	info, err = os.Stat(segmentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recordSize := complete / 2; info.Size() != complete+recordSize {
+		t.Errorf("size = %d, want %d", info.Size(), complete+recordSize)
+	}
+}
+
+func TestReadRecord(t *testing.T) {
+	payload := []byte("payload")
+	record := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))
+	record = append(record, payload...)
+	record = binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(payload))
+
+	badCRC := append([]byte(nil), record...)
+	badCRC[len(badCRC)-1] ^= 0xFF
+
+	tests := []struct {
+		name    string
+		b       []byte
+		want    []byte
+		wantErr error
+	}{
+		{"complete", record, payload, nil},
+		{"end", nil, nil, io.EOF},
+		{"torn header", record[:2], nil, CorruptedRecordError()},
+		{"torn payload", record[:recordHeaderSize+3], nil, CorruptedRecordError()},
+		{"torn CRC", record[:len(record)-1], nil, CorruptedRecordError()},
+		{"bad CRC", badCRC, nil, CorruptedRecordError()},
+		{"too long", binary.LittleEndian.AppendUint32(nil, uint32(maxRecordSize+1)), nil, CorruptedRecordError()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readRecord(bytes.NewReader(tt.b))
+			// recoverSegment tells the end and a broken record from the other errors by errors.Is
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("payload = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreMaxSize(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenStore(dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append("/dev/ttyACM0", storeData(1)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	info, err := os.Stat(filepath.Join(dir, "ttyACM0", "20260101"+segmentExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSize := info.Size()
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		appends int
+		want    []SensorData
+	}{
+		{"under the limit", 10 * recordSize, 2, []SensorData{TemperatureData(21), TemperatureData(22), TemperatureData(23)}},
+		// the open segment alone is over the limit, so it is dropped
+		{"open segment dropped", 2 * recordSize, 2, []SensorData{TemperatureData(23)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := OpenStore(dir, StoreOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Append("/dev/ttyACM0", storeData(1))
+			s.Close()
+
+			s, err = OpenStore(dir, StoreOptions{MaxSize: tt.maxSize})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			for i := 0; i < tt.appends; i++ {
+				if err := s.Append("/dev/ttyACM0", storeData(2+i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := queryTemperatures(t, s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("temperatures = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreQuerySensors(t *testing.T) {
+	s, err := OpenStore(t.TempDir(), StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	data := storeData(1)
+	data.Timing = map[byte]*SampleTiming{
+		TemperatureASCIICmd: {Sent: data.Time, Received: data.Time.Add(time.Millisecond)},
+		HumidityASCIICmd:    {Sent: data.Time, Received: data.Time.Add(time.Millisecond)},
+	}
+	if err := s.Append("/dev/ttyACM0", data); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.Query("/dev/ttyACM0", []byte{HumidityASCIICmd}, data.Time, data.Time.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("%d data, want 1", len(result))
+	}
+	if len(result[0].Data) != 1 || len(result[0].Timing) != 1 || result[0].Data[HumidityASCIICmd] != HumidityData(40) {
+		t.Errorf("result = %+v", result[0])
+	}
+}
+
+func TestFilterSensors(t *testing.T) {
+	data := &TimeSeriesData{
+		Data:   map[byte]SensorData{TemperatureASCIICmd: TemperatureData(23.45), HumidityASCIICmd: HumidityData(40)},
+		Timing: map[byte]*SampleTiming{TemperatureASCIICmd: {}, HumidityASCIICmd: {}, PressureASCIICmd: {}},
+		Raw:    map[byte][]byte{TemperatureASCIICmd: []byte("23.45"), PressureASCIICmd: []byte("Pres")},
+	}
+
+	if !filterSensors(data, map[byte]bool{TemperatureASCIICmd: true}) {
+		t.Fatal("nothing is left")
+	}
+	if len(data.Data) != 1 || len(data.Timing) != 1 || len(data.Raw) != 1 || data.Raw[TemperatureASCIICmd] == nil {
+		t.Errorf("data = %+v", data)
+	}
+}
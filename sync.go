@@ -0,0 +1,60 @@
+// Define input flush and resynchronization in this file
+package serial
+
+import (
+	"log"
+	"strings"
+)
+
+// Number of pings that resync sends before giving up
+const ResyncAttempts int = 3
+
+// SetFlushBeforeCommand makes every transaction discard the input before writing the command,
+// so the bytes left from a partially lost response are not read as the start of the next one.
+// It costs one more read timeout (InterCharacterTimeout) per command.
+func (d *DLPTH1C) SetFlushBeforeCommand(flush bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.flushBeforeCommand = flush
+}
+
+func (d *DLPTH1C) flushesBeforeCommand() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.flushBeforeCommand
+}
+
+// Resync discards the input and pings the sensor until it replies cleanly,
+// which means the request and the response are aligned again.
+func (d *DLPTH1C) Resync() error {
+	for i := 0; i < ResyncAttempts; i++ {
+		response := d.transact([]byte{PingASCIICmd}, HighPriority, true)
+		if response.err == ClosedError() {
+			return response.err
+		}
+		if response.err == nil && isCleanReply(response.b) {
+			return nil
+		}
+	}
+
+	return ResyncError()
+}
+
+// resyncAfter is called by the read functions when the response seems misaligned.
+func (d *DLPTH1C) resyncAfter(cmd byte, cause error) {
+	err := d.Resync()
+	d.stats.recordResync(err)
+
+	if err != nil {
+		log.Printf("%s: %v (after %v)", SensorName(cmd), err, cause)
+	}
+}
+
+// A clean reply of ping is one short line.
+// If there is more than that, a part of some other response is mixed in.
+func isCleanReply(b []byte) bool {
+	reply := strings.Trim(string(b), "\r\n\x00 ")
+	return len(reply) > 0 && !strings.ContainsAny(reply, "\r\n")
+}
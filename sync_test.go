@@ -0,0 +1,91 @@
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlushOncePerFailure(t *testing.T) {
+	tests := []struct {
+		name               string
+		flushBeforeCommand bool
+		read               func(d *DLPTH1C) error
+		// commands written and reads which returned nothing
+		wantCommands   string
+		wantEmptyReads int
+	}{
+		{
+			name:               "flush with flush before command",
+			flushBeforeCommand: true,
+			read:               func(d *DLPTH1C) error { return d.flush() },
+			wantEmptyReads:     1,
+		},
+		{
+			// t (end), resync: ' (flush, end), t (end)
+			name: "parse error resynced before retry",
+			read: func(d *DLPTH1C) error {
+				_, err := d.Read(TemperatureASCIICmd)
+				return err
+			},
+			wantCommands:   "t't",
+			wantEmptyReads: 4,
+		},
+		{
+			// the same, and every command is flushed before once
+			name:               "parse error with flush before command",
+			flushBeforeCommand: true,
+			read: func(d *DLPTH1C) error {
+				_, err := d.Read(TemperatureASCIICmd)
+				return err
+			},
+			wantCommands:   "t't",
+			wantEmptyReads: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := newFakePort(sensorResponses).then(TemperatureASCIICmd, "Temperat", temperatureResponse)
+			d := NewDLPTH1CFromPort("fake", port)
+			defer d.Close()
+			d.SetFlushBeforeCommand(tt.flushBeforeCommand)
+			d.SetRetryPolicy(TemperatureASCIICmd, RetryPolicy{Attempts: 2, Flush: true})
+
+			if err := tt.read(d); err != nil {
+				t.Fatal(err)
+			}
+			if commands := port.commands(); commands != tt.wantCommands {
+				t.Errorf("commands = %q, want %q", commands, tt.wantCommands)
+			}
+			if reads := port.reads(); reads != tt.wantEmptyReads {
+				t.Errorf("empty reads = %d, want %d", reads, tt.wantEmptyReads)
+			}
+		})
+	}
+}
+
+func TestResync(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []string
+		wantErr bool
+	}{
+		{"clean reply", []string{pingResponse}, false},
+		{"leftover mixed in", []string{"Z:1000\r\nQ\r\n", pingResponse}, false},
+		{"never clean", []string{"Z:1000\r\nQ\r\n"}, true},
+		{"silent", []string{""}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := newFakePort(nil).then(PingASCIICmd, tt.replies...)
+			d := NewDLPTH1CFromPort("fake", port)
+			defer d.Close()
+			d.SetCommandTimeout(PingASCIICmd, 10*time.Millisecond)
+
+			if err := d.Resync(); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}